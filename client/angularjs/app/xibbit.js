@@ -403,9 +403,13 @@ var xibbit = (function() {
             $(self).trigger(evt.type, evt);
           });
           self.socket.on('client', function(event) {
-            // deep clone
-            event = $.extend(true, {}, event);
-            self.dispatchEvent(event);
+            // a batching server can send an array of events
+            var events = $.isArray(event)? event: [event];
+            $.each(events, function(key, event) {
+              // deep clone
+              event = $.extend(true, {}, event);
+              self.dispatchEvent(event);
+            });
           });
           self.initInstance(method);
         });
@@ -26,12 +26,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"database/sql"
 	_ "github.com/go-sql-driver/mysql"
@@ -81,9 +85,24 @@ func main() {
 			"link":       link,
 			"SQL_PREFIX": config.Sql_prefix,
 		},
+		"sessionStore": "sql",
+		"globalVars":   "sql",
+		"eventQueue":   "sql",
+		// share the rate limit buckets between hubs
+		"rateLimiter":  "sql",
+		// broadcast presence_join and presence_leave events
+		"presenceEvents": true,
+		"rateLimits": map[string]interface{}{
+			"api": map[string]interface{}{"rate": 5, "burst": 20},
+			"on":  map[string]interface{}{"rate": 10, "burst": 40},
+		},
 		"vars": map[string]interface{}{
 			"pf":           pf,
 			"useInstances": true,
+			// the clients do not send _ack events so delete
+			//  events once they are received; set to true to
+			//  keep them until the client sends an _ack event
+			"ackEvents":    false,
 			"hacks":        config.Hacks,
 		},
 	})
@@ -92,18 +111,24 @@ func main() {
 	hub.On("api", "__receive", events.E__receive)
 	hub.On("api", "__send", events.E__send)
 	hub.On("api", "_instance", events.E_instance)
-	hub.On("api", "init", events.Init)
-	hub.On("api", "login", events.Login)
-	hub.On("on", "logout", events.Logout)
-	hub.On("api", "user_create", events.User_create)
-	hub.On("on", "user_profile_mail_update", events.User_profile_mail_update)
+	hub.On("api", "init", events.Init, map[string]interface{}{"schema": events.Init_schema})
+	// password hashing is slow so limit signing in and signing up
+	slow := map[string]interface{}{"rate": 0.2, "burst": 5}
+	hub.On("api", "login", events.Login, map[string]interface{}{"schema": events.Login_schema, "rateLimit": slow})
+	hub.On("on", "logout", events.Logout, map[string]interface{}{"schema": events.Logout_schema})
+	hub.On("api", "user_create", events.User_create, map[string]interface{}{"schema": events.User_create_schema(pf), "rateLimit": slow})
+	hub.On("on", "user_profile_mail_update", events.User_profile_mail_update, map[string]interface{}{"schema": events.User_profile_mail_update_schema})
 	hub.On("on", "user_profile_upload_photo", events.User_profile_upload_photo)
-	hub.On("on", "user_profile", events.User_profile)
+	xibbit.Handle(hub, "on", "user_profile", events.User_profile, map[string]interface{}{"schema": events.User_profile_schema})
 
 	// start the _events system
 	hub.Start("")
 
 	socketioHandler := gin.WrapH(socketioServer)
+	// the xio transport and clients without WebSockets
+	eventsHandler := gin.WrapH(hub.GetHttpHandler())
+	// receive-only clients like dashboards
+	streamHandler := gin.WrapH(hub.GetSseHandler())
 
 	// serve the client folder and ignore their url_config.js and socket.io versions
 	clientFolders := map[string]string{
@@ -131,7 +156,7 @@ func main() {
 			}
 			// show how to get sid but do nothing with it
 			sid := context.PostForm("sid")
-			sid = sid
+			_ = sid
 			instance := context.PostForm("instance")
 			session := hub.GetSessionByInstance(instance)
 			// get upload filename from user's browser
@@ -147,6 +172,10 @@ func main() {
 			delete(eventReply, "_session")
 			eventsReplyBytes, _ := json.Marshal([]map[string]interface{}{eventReply})
 			context.String(http.StatusOK, string(eventsReplyBytes))
+		} else if filepath == "/events" {
+			eventsHandler(context)
+		} else if filepath == "/events/stream" {
+			streamHandler(context)
 		} else if strings.HasPrefix(filepath, "/socket.io/") {
 			socketioHandler(context)
 		} else if _, err := os.Stat(clientFolder + filepath); err == nil {
@@ -162,5 +191,29 @@ func main() {
 	log.Printf("public figure server started on port %d...", APP_PORT)
 	go socketioServer.Serve()
 	defer socketioServer.Close()
-	ginServer.Run()
+	// same address as ginServer.Run()
+	addr := ":8080"
+	if port := os.Getenv("PORT"); port != "" {
+		addr = ":" + port
+	}
+	httpServer := &http.Server{Addr: addr, Handler: ginServer}
+	go func() {
+		if e := httpServer.ListenAndServe(); (e != nil) && (e != http.ErrServerClosed) {
+			log.Fatal(e)
+		}
+	}()
+
+	// finish the running events before exiting
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("public figure server stopping...")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if e := hub.StopHub(ctx); e != nil {
+		log.Println(e)
+	}
+	if e := httpServer.Shutdown(ctx); e != nil {
+		log.Println(e)
+	}
 }
@@ -43,6 +43,11 @@ func E__clock(event map[string]interface{}, vars map[string]interface{}) map[str
 	now := time.Now()
 	globalVars := event["globalVars"].(map[string]interface{})
 
+	// forget instances that have not been heard from in an hour
+	hub.ExpireSessions(60 * 60)
+	// forget events that were never acked
+	hub.ExpireQueuedEvents(60 * 60)
+
 	lastRandomEventTime, ok := globalVars["lastRandomEventTime"].(float64)
 	if !ok ||
 		now.After(time.Unix(int64(lastRandomEventTime), 0).Add(time.Second*time.Duration(10))) {
@@ -28,6 +28,7 @@ package events
 import (
 	"encoding/json"
 	"github.com/xibbit/xibbit/server/golang/src/publicfigure/pfapp"
+	"github.com/xibbit/xibbit/server/golang/src/xibbit"
 )
 
 /**
@@ -37,8 +38,10 @@ import (
  * @author DanielWHoward
  **/
 func E__receive(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
+	hub := vars["hub"].(*xibbit.XibbitHub)
 	pf := vars["pf"].(*pfapp.Pfapp)
 	useInstances, ok := vars["useInstances"].(bool)
+	ackEvents, _ := vars["ackEvents"].(bool)
 
 	// assume that this event does not need special handling
 	event["e"] = "unimplemented"
@@ -50,6 +53,10 @@ func E__receive(event map[string]interface{}, vars map[string]interface{}) map[s
 			// "zombie" sockets without instances can be created
 			// in long polling scenarios and where the user reloads
 			// multiple times and Receive() is called at a bad time
+		} else if ackEvents || hub.IsStreaming(instance) {
+			// events stay in the events table until they are acked
+			event["eventQueue"] = hub.ReceiveQueuedEvents(instance)
+			delete(event, "e")
 		} else {
 			event["eventQueue"] = []map[string]interface{}{}
 			// get the events from the events table
@@ -61,6 +68,10 @@ func E__receive(event map[string]interface{}, vars map[string]interface{}) map[s
 			})
 			// this is intentionally not ACID; the client will handle dups
 			for f := 0; f < len(events); f++ {
+				// rows with a seq belong to the event queue
+				if events[f]["seq"] != nil {
+					continue
+				}
 				evt := events[f]["event"].(string)
 				evtMap := make(map[string]interface{}, 0)
 				json.Unmarshal([]byte(evt), &evtMap)
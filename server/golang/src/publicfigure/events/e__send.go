@@ -34,8 +34,8 @@ import (
 )
 
 /**
- * Handle __send event.  Process "all" and user
- * aliases to send this event to multiple
+ * Handle __send event.  Process "all", #channel
+ * and user aliases to send this event to multiple
  * instances.
  *
  * @author DanielWHoward
@@ -44,6 +44,7 @@ func E__send(event map[string]interface{}, vars map[string]interface{}) map[stri
 	hub := vars["hub"].(*xibbit.XibbitHub)
 	pf := vars["pf"].(*pfapp.Pfapp)
 	useInstances, ok := vars["useInstances"].(bool)
+	ackEvents, _ := vars["ackEvents"].(bool)
 
 	// assume that this event does not need special handling
 	event["e"] = "unimplemented"
@@ -76,7 +77,6 @@ func E__send(event map[string]interface{}, vars map[string]interface{}) map[stri
 			},
 		})
 		// resolve the "to" address to instances
-		instances := hub.Sessions
 		q := map[string]interface{}{
 			"table": "instances",
 		}
@@ -88,31 +88,73 @@ func E__send(event map[string]interface{}, vars map[string]interface{}) map[stri
 				},
 			}
 		}
-		instances, _ = pf.ReadRows(q)
-		// send an event to each instance
-		for _, instance := range instances {
-			keysToSkip := []string{"_conn"}
-			sent = true
-			// clone the event so we can safely modify it
-			var evt = hub.CloneEvent(eventMap, keysToSkip)
-			// "to" is an instance ID in events table
-			instanceId := instance["instance"]
-			// overwrite "from" and add "fromid" field
-			if from != nil {
-				evt["from"] = from["username"]
-				evt["fromid"] = from["uid"]
+		channel := (len(toStr) > 1) && (toStr[0:1] == "#")
+		// read the instances a page at a time
+		const pageSize = 100
+		q["order by"] = []string{"id"}
+		q["limit"] = pageSize
+		for page := true; page; {
+			var instances []map[string]interface{}
+			if channel {
+				// a #channel is resolved by the subscribed sessions
+				for _, session := range hub.GetSessionsByUsername(toStr) {
+					sessionData, _ := session["session_data"].(map[string]interface{})
+					if instanceId, ok := sessionData["instance_id"].(string); ok {
+						instances = append(instances, map[string]interface{}{
+							"instance": instanceId,
+						})
+					}
+				}
+				page = false
+			} else {
+				instances, _ = pf.ReadRows(q)
+				page = len(instances) == pageSize
+				if page {
+					q["after"] = map[string]interface{}{
+						"id": instances[len(instances)-1]["id"],
+					}
+				}
+			}
+			// send an event to each instance
+			for _, instance := range instances {
+				keysToSkip := []string{"_conn"}
+				sent = true
+				// clone the event so we can safely modify it
+				var evt = hub.CloneEvent(eventMap, keysToSkip)
+				// "to" is an instance ID in events table
+				instanceId := instance["instance"]
+				// overwrite "from" and add "fromid" field
+				if from != nil {
+					evt["from"] = from["username"]
+					evt["fromid"] = from["uid"]
+				}
+				instanceStr, _ := instanceId.(string)
+				// a Server-Sent Events stream resumes from the queue
+				if ackEvents || hub.IsStreaming(instanceStr) {
+					if _, e := hub.QueueEvent(instanceStr, evt); e != nil {
+						log.Println(e)
+					}
+					hub.Wake(instanceStr)
+					continue
+				}
+				// write to a local instance without the events table
+				if hub.Deliver(instanceStr, evt) {
+					continue
+				}
+				evtBytes, _ := json.Marshal(evt)
+				evtStr := string(evtBytes)
+				pf.InsertRow(map[string]interface{}{
+					"table": "sockets_events",
+					"values": map[string]interface{}{
+						"id":      0,
+						"sid":     instanceId,
+						"event":   evtStr,
+						"touched": now,
+					},
+				})
+				// a remote instance can poll right away
+				hub.Wake(instanceStr)
 			}
-			evtBytes, _ := json.Marshal(evt)
-			evtStr := string(evtBytes)
-			pf.InsertRow(map[string]interface{}{
-				"table": "sockets_events",
-				"values": map[string]interface{}{
-					"id":      0,
-					"sid":     instanceId,
-					"event":   evtStr,
-					"touched": now,
-				},
-			})
 		}
 		if sent {
 			delete(event, "e")
@@ -25,9 +25,12 @@
 // @license http://opensource.org/licenses/MIT
 package events
 
-import (
-	"github.com/xibbit/xibbit/server/golang/src/publicfigure/asserte"
-)
+/**
+ * The properties of an init event.
+ *
+ * @author DanielWHoward
+ **/
+var Init_schema = map[string]interface{}{}
 
 /**
  * Handle init event.  Return information to
@@ -36,9 +39,6 @@ import (
  * @author DanielWHoward
  **/
 func Init(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
-
-	asserte.NoAsserte(event)
-
 	// info: initial values loaded
 	event["i"] = "initialized"
 	return event
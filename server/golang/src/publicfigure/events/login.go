@@ -26,13 +26,30 @@
 package events
 
 import (
-	"github.com/xibbit/xibbit/server/golang/src/publicfigure/asserte"
+	"context"
 	"github.com/xibbit/xibbit/server/golang/src/publicfigure/pfapp"
 	"github.com/xibbit/xibbit/server/golang/src/publicfigure/pwd"
-	"regexp"
 	"github.com/xibbit/xibbit/server/golang/src/xibbit"
 )
 
+/**
+ * The properties of a login event in the order
+ * they are checked.
+ *
+ * @author DanielWHoward
+ **/
+var Login_schema = []interface{}{
+	map[string]interface{}{
+		"name":   "to",
+		"type":   "string",
+		"regexp": `^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`,
+	},
+	map[string]interface{}{
+		"name": "pwd",
+		"type": "string",
+	},
+}
+
 /**
  * Handle login event.  Sign in a user.
  *
@@ -41,15 +58,10 @@ import (
 func Login(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
 	hub := vars["hub"].(*xibbit.XibbitHub)
 	pf := vars["pf"].(*pfapp.Pfapp)
+	// give up on the database if the client goes away
+	ctx, _ := vars["ctx"].(context.Context)
+	pf = pf.WithContext(ctx)
 
-	asserte.Asserte(func() bool { _, ok := event["to"]; return ok }, "missing:to")
-	asserte.Asserte(func() bool { _, ok := event["to"].(string); return ok }, "typeof:to")
-	asserte.Asserte(func() bool {
-		ok, _ := regexp.MatchString(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`, event["to"].(string))
-		return ok
-	}, "regexp:to")
-	asserte.Asserte(func() bool { _, ok := event["pwd"]; return ok }, "missing:pwd")
-	asserte.Asserte(func() bool { _, ok := event["pwd"].(string); return ok }, "typeof:pwd")
 	to := event["to"].(string)
 	passwd := event["pwd"].(string)
 
@@ -99,9 +111,17 @@ func Login(event map[string]interface{}, vars map[string]interface{}) map[string
 				event["i"] = "collect:username"
 			}
 			me = mes[len(mes)-1]
-			// connect to this user
+			// connect to this user with their roles
+			roles := []string{}
+			if meRoles, ok := me["roles"].([]interface{}); ok {
+				for _, role := range meRoles {
+					if role, ok := role.(string); ok {
+						roles = append(roles, role)
+					}
+				}
+			}
 			event["username"] = me["username"]
-			event = hub.Connect(event, me["username"].(string), true)
+			event = hub.Connect(event, me["username"].(string), true, roles...)
 			// add UID and user to the session variables
 			event["_session"].(map[string]interface{})["uid"] = me["id"]
 			// return user info
@@ -26,11 +26,17 @@
 package events
 
 import (
-	"github.com/xibbit/xibbit/server/golang/src/publicfigure/asserte"
 	"github.com/xibbit/xibbit/server/golang/src/publicfigure/pfapp"
 	"github.com/xibbit/xibbit/server/golang/src/xibbit"
 )
 
+/**
+ * The properties of a logout event.
+ *
+ * @author DanielWHoward
+ **/
+var Logout_schema = map[string]interface{}{}
+
 /**
  * Handle logout event.  Sign out a user.
  *
@@ -40,8 +46,6 @@ func Logout(event map[string]interface{}, vars map[string]interface{}) map[strin
 	hub := vars["hub"].(*xibbit.XibbitHub)
 	pf := vars["pf"].(*pfapp.Pfapp)
 
-	asserte.NoAsserte(event)
-
 	uid := event["_session"].(map[string]interface{})["uid"].(int)
 	instance := event["_session"].(map[string]interface{})["instance_id"].(string)
 
@@ -26,14 +26,55 @@
 package events
 
 import (
-	"github.com/xibbit/xibbit/server/golang/src/publicfigure/array"
-	"github.com/xibbit/xibbit/server/golang/src/publicfigure/asserte"
 	"github.com/xibbit/xibbit/server/golang/src/publicfigure/pfapp"
 	"github.com/xibbit/xibbit/server/golang/src/publicfigure/pwd"
-	"regexp"
 	"time"
 )
 
+/**
+ * Return the properties of a user_create event in
+ * the order they are checked.
+ *
+ * @param pf Pfapp The app with the usernames not allowed.
+ *
+ * @author DanielWHoward
+ **/
+func User_create_schema(pf *pfapp.Pfapp) []interface{} {
+	return []interface{}{
+		map[string]interface{}{
+			"name":   "username",
+			"type":   "string",
+			"not":    pf.UsernamesNotAllowed,
+			"regexp": `^[a-z][a-z0-9]{2,11}$`,
+		},
+		map[string]interface{}{
+			"name":   "email",
+			"type":   "string",
+			"regexp": `^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`,
+		},
+		map[string]interface{}{
+			"name": "pwd",
+			"type": "string",
+		},
+	}
+}
+
+/**
+ * A row of the users table.
+ *
+ * @author DanielWHoward
+ **/
+type userRow struct {
+	Id        int       `xibdb:"id"`
+	Uid       int       `xibdb:"uid"`
+	Username  string    `xibdb:"username"`
+	Email     string    `xibdb:"email"`
+	Pwd       string    `xibdb:"pwd"`
+	Created   time.Time `xibdb:"created"`
+	Connected time.Time `xibdb:"connected"`
+	Touched   time.Time `xibdb:"touched"`
+}
+
 /**
  * Handle user_create event.  Create a new user.
  *
@@ -42,25 +83,15 @@ import (
 func User_create(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
 	pf := vars["pf"].(*pfapp.Pfapp)
 
-	asserte.Asserte(func() bool { _, ok := event["username"]; return ok }, "missing:username")
-	asserte.Asserte(func() bool { _, ok := event["username"].(string); return ok }, "typeof:username")
-	asserte.Asserte(func() bool { return !array.ArrayIncludes(event["username"].(string), pf.UsernamesNotAllowed) }, "invalid:username")
-	asserte.Asserte(func() bool {
-		ok, _ := regexp.MatchString(`^[a-z][a-z0-9]{2,11}$`, event["username"].(string))
-		return ok
-	}, "regexp:username")
-	asserte.Asserte(func() bool { _, ok := event["email"]; return ok }, "missing:email")
-	asserte.Asserte(func() bool { _, ok := event["email"].(string); return ok }, "typeof:email")
-	asserte.Asserte(func() bool {
-		ok, _ := regexp.MatchString(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,4}$`, event["email"].(string))
-		return ok
-	}, "regexp:email")
-	asserte.Asserte(func() bool { _, ok := event["pwd"]; return ok }, "missing:pwd")
-	asserte.Asserte(func() bool { _, ok := event["pwd"].(string); return ok }, "typeof:pwd")
-
 	username := event["username"].(string)
 	email := event["email"].(string)
-	hashedPwd, _ := pwd.Pwd_hash(event["pwd"].(string), "", "", false)
+	hash, e := pwd.Pwd_hash(event["pwd"].(string), "", "", false)
+	hashedPwd, ok := hash.(string)
+	if (e != nil) || !ok {
+		delete(event, "pwd")
+		event["e"] = "create failed"
+		return event
+	}
 
 	nowStr := time.Now().Format("2006-01-02 15:04:05")
 	now, _ := time.Parse("2006-01-02 15:04:05", nowStr)
@@ -77,35 +108,41 @@ func User_create(event map[string]interface{}, vars map[string]interface{}) map[
 	})
 	// insert the user into the database
 	if me == nil {
-		// insert the user
-		user, _ := pf.InsertRow(map[string]interface{}{
-			"table": "users",
-			"values": map[string]interface{}{
-				"id":        0,
-				"uid":       0,
-				"username":  username,
-				"email":     email,
-				"pwd":       hashedPwd,
-				"created":   now,
-				"connected": nullDateTime,
-				"touched":   nullDateTime,
-			},
-		})
-		id := int(user["id"].(float64))
-		uid := int(user["id"].(float64))
-		// update the uid
-		values := map[string]interface{}{
-			"uid": uid,
-		}
-		pf.UpdateRow(map[string]interface{}{
-			"table":  "users",
-			"values": values,
-			"where": map[string]interface{}{
-				"id": id,
-			},
+		// insert the user and set its uid atomically
+		e := pf.WithTx(func(pf *pfapp.Pfapp) error {
+			user := userRow{
+				Username:  username,
+				Email:     email,
+				Pwd:       hashedPwd,
+				Created:   now,
+				Connected: nullDateTime,
+				Touched:   nullDateTime,
+			}
+			e := pf.InsertStruct("users", &user)
+			if e != nil {
+				return e
+			}
+			id := user.Id
+			uid := user.Id
+			// update the uid
+			values := map[string]interface{}{
+				"uid": uid,
+			}
+			_, e = pf.UpdateRow(map[string]interface{}{
+				"table":  "users",
+				"values": values,
+				"where": map[string]interface{}{
+					"id": id,
+				},
+			})
+			return e
 		})
 		delete(event, "pwd")
-		event["i"] = "created"
+		if e == nil {
+			event["i"] = "created"
+		} else {
+			event["e"] = "create failed"
+		}
 	} else {
 		delete(event, "pwd")
 		event["e"] = "already exists"
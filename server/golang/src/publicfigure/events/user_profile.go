@@ -26,33 +26,61 @@
 package events
 
 import (
+	"errors"
+
 	"github.com/xibbit/xibbit/server/golang/src/publicfigure/array"
-	"github.com/xibbit/xibbit/server/golang/src/publicfigure/asserte"
 	"github.com/xibbit/xibbit/server/golang/src/publicfigure/pfapp"
+	"github.com/xibbit/xibbit/server/golang/src/xibbit"
 )
 
+/**
+ * The properties of a user_profile event.
+ *
+ * @author DanielWHoward
+ **/
+var User_profile_schema = map[string]interface{}{}
+
+/**
+ * A user_profile event.
+ *
+ * @author DanielWHoward
+ **/
+type User_profile_request struct {
+}
+
+/**
+ * The reply to a user_profile event.
+ *
+ * @author DanielWHoward
+ **/
+type User_profile_reply struct {
+	Profile map[string]interface{} `json:"profile"`
+	I       string                 `json:"i"`
+}
+
 /**
  * Handle user_profile event.  Get this user's
  * profile.
  *
  * @author DanielWHoward
  **/
-func User_profile(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
-	pf := vars["pf"].(*pfapp.Pfapp)
-
-	asserte.NoAsserte(event)
+func User_profile(req *User_profile_request, ctx *xibbit.EventContext) (*User_profile_reply, error) {
+	pf := ctx.Vars["pf"].(*pfapp.Pfapp).WithContext(ctx.Context())
 
 	// get the current user
-	uid, ok := event["_session"].(map[string]interface{})["uid"].(int)
-	asserte.Asserte(func() bool { return ok }, "current user not found")
-	asserte.Asserte(func() bool { return uid > 0 }, "current user not found")
+	uid := ctx.Session.Int("uid")
+	if uid <= 0 {
+		return nil, errors.New("current user not found")
+	}
 	me, _ := pf.ReadOneRow(map[string]interface{}{
 		"table": "users",
 		"where": map[string]interface{}{
 			"uid": uid,
 		},
 	})
-	asserte.Asserte(func() bool { return me != nil }, "current user not found")
+	if me == nil {
+		return nil, errors.New("current user not found")
+	}
 	// set default values for missing values
 	me = array.ArrayMerge(map[string]interface{}{
 		"name":     "",
@@ -63,15 +91,16 @@ func User_profile(event map[string]interface{}, vars map[string]interface{}) map
 		"zip":      "",
 	}, me)
 	// return the profile
-	event["profile"] = map[string]interface{}{
-		"name":     me["name"],
-		"address":  me["address"],
-		"address2": me["address2"],
-		"city":     me["city"],
-		"state":    me["state"],
-		"zip":      me["zip"],
-	}
-	// info: profile returned
-	event["i"] = "profile found"
-	return event
+	return &User_profile_reply{
+		Profile: map[string]interface{}{
+			"name":     me["name"],
+			"address":  me["address"],
+			"address2": me["address2"],
+			"city":     me["city"],
+			"state":    me["state"],
+			"zip":      me["zip"],
+		},
+		// info: profile returned
+		I: "profile found",
+	}, nil
 }
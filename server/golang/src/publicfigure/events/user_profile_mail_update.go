@@ -26,11 +26,19 @@
 package events
 
 import (
-	"github.com/xibbit/xibbit/server/golang/src/publicfigure/array"
 	"github.com/xibbit/xibbit/server/golang/src/publicfigure/asserte"
 	"github.com/xibbit/xibbit/server/golang/src/publicfigure/pfapp"
 )
 
+/**
+ * The properties of a user_profile_mail_update event.
+ *
+ * @author DanielWHoward
+ **/
+var User_profile_mail_update_schema = map[string]interface{}{
+	"user": "object",
+}
+
 /**
  * Handle user_profile_mail_update event.  Change
  * this user"s mailing address and other values.
@@ -40,9 +48,6 @@ import (
 func User_profile_mail_update(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
 	pf := vars["pf"].(*pfapp.Pfapp)
 
-	asserte.Asserte(func() bool { _, ok := event["user"]; return ok }, "missing:user")
-	asserte.Asserte(func() bool { return array.HasStringKeys(event["user"].(map[string]interface{})) }, "typeof:user")
-
 	// get the current user
 	uid, ok := event["_session"].(map[string]interface{})["uid"].(int)
 	asserte.Asserte(func() bool { return ok }, "current user not found")
@@ -26,6 +26,7 @@
 package pfapp
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"github.com/xibbit/xibbit/server/golang/src/publicfigure/array"
@@ -107,7 +108,7 @@ func (self Pfapp) AddTableSpecifiers(a interface{}) interface{} {
 	} else if array.HasNumericKeys(a) || array.HasStringKeys(a) {
 		if array.HasNumericKeys(a) {
 			b := []interface{}{}
-			for _, value := range a.([]map[string]interface{}) {
+			for _, value := range a.([]interface{}) {
 				b = append(b, self.AddTableSpecifiers(value))
 			}
 			a = b
@@ -157,6 +158,35 @@ func (self Pfapp) ReadRows(table map[string]interface{}) ([]map[string]interface
 	return self.xibdb.ReadRowsNative(table, nil, nil, nil)
 }
 
+/**
+ * Read JSON table rows from the database one at a time
+ * for large tables.
+ *
+ * @param table array A database query array.
+ * @return An iterator that must be closed.
+ *
+ * @author DanielWHoward
+ */
+func (self Pfapp) ReadRowsIter(table map[string]interface{}) (*xibdb.RowIterator, error) {
+	table = self.AddTableSpecifiers(table).(map[string]interface{})
+	return self.xibdb.ReadRowsIter(table)
+}
+
+/**
+ * Read JSON table rows from the database into structs
+ * that use xibdb struct tags.
+ *
+ * @param pf Pfapp The app.
+ * @param table array A database query array.
+ * @return A slice of structs.
+ *
+ * @author DanielWHoward
+ */
+func ReadRowsInto[T any](pf *Pfapp, table map[string]interface{}) ([]T, error) {
+	table = pf.AddTableSpecifiers(table).(map[string]interface{})
+	return xibdb.ReadRowsInto[T](pf.xibdb, table)
+}
+
 /**
  * Get JSON table rows from the database.
  *
@@ -229,6 +259,20 @@ func (self Pfapp) InsertRow(table map[string]interface{}) (map[string]interface{
 	return row, e
 }
 
+/**
+ * Insert a struct that uses xibdb struct tags into a
+ * database table.
+ *
+ * @param table string A database table.
+ * @param v A pointer to a struct; its auto_increment field is set.
+ *
+ * @author DanielWHoward
+ */
+func (self Pfapp) InsertStruct(table string, v interface{}) error {
+	table = self.AddTableSpecifiers(table).(string)
+	return self.xibdb.InsertStruct(table, v)
+}
+
 /**
  * Delete a row of JSON from a database table.
  *
@@ -285,6 +329,39 @@ func (self Pfapp) MoveRow(table map[string]interface{}) error {
 	return self.xibdb.MoveRow(table, "", -1, -1)
 }
 
+/**
+ * Run several row operations in one database transaction.
+ *
+ * @param fn func A callback that uses its Pfapp argument.
+ * @return error The callback or commit error.
+ *
+ * @author DanielWHoward
+ */
+func (self Pfapp) WithTx(fn func(pf *Pfapp) error) error {
+	return self.xibdb.WithTx(func(tx *xibdb.XibDb) error {
+		pf := self
+		pf.xibdb = tx
+		return fn(&pf)
+	})
+}
+
+/**
+ * Return a copy that runs its queries with a context,
+ * usually the ctx var of an event handler.
+ *
+ * @param ctx Context The context for the queries.
+ * @return Pfapp A copy that uses the context.
+ *
+ * @author DanielWHoward
+ */
+func (self Pfapp) WithContext(ctx context.Context) *Pfapp {
+	if ctx == nil {
+		return &self
+	}
+	self.xibdb = self.xibdb.WithContext(ctx)
+	return &self
+}
+
 /**
  * Flexible mysql_query() function.
  *
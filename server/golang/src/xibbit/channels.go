@@ -0,0 +1,145 @@
+// The MIT License (MIT)
+//
+// xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// @version 2.0.0
+// @copyright xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+// @license http://opensource.org/licenses/MIT
+package xibbit
+
+import (
+	"errors"
+	"regexp"
+)
+
+/**
+ * The pattern for a channel name.
+ *
+ * @author DanielWHoward
+ **/
+var channelPattern = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,64}$`)
+
+/**
+ * Return the channels a session is subscribed to.
+ *
+ * Subscriptions are kept in the _channels key of the
+ * session data so they are saved with the instance
+ * and survive a page reload.
+ *
+ * @param sessionData map The session data.
+ * @return array The channel names without the #.
+ *
+ * @author DanielWHoward
+ **/
+func Channels(sessionData map[string]interface{}) []string {
+	return toStrings(sessionData["_channels"])
+}
+
+/**
+ * Subscribe a session to a channel.  Events sent
+ * to #channel are delivered to the session.
+ *
+ * @param sessionData map The session data.
+ * @param channel string The channel name with or without the #.
+ * @return error An error if the channel name is malformed.
+ *
+ * @author DanielWHoward
+ **/
+func Subscribe(sessionData map[string]interface{}, channel string) error {
+	channel, e := channelName(channel)
+	if e != nil {
+		return e
+	}
+	channels := []interface{}{}
+	for _, c := range Channels(sessionData) {
+		if c == channel {
+			return nil
+		}
+		channels = append(channels, c)
+	}
+	sessionData["_channels"] = append(channels, channel)
+	return nil
+}
+
+/**
+ * Unsubscribe a session from a channel.
+ *
+ * @param sessionData map The session data.
+ * @param channel string The channel name with or without the #.
+ * @return error An error if the channel name is malformed.
+ *
+ * @author DanielWHoward
+ **/
+func Unsubscribe(sessionData map[string]interface{}, channel string) error {
+	channel, e := channelName(channel)
+	if e != nil {
+		return e
+	}
+	channels := []interface{}{}
+	for _, c := range Channels(sessionData) {
+		if c != channel {
+			channels = append(channels, c)
+		}
+	}
+	if len(channels) == 0 {
+		delete(sessionData, "_channels")
+	} else {
+		sessionData["_channels"] = channels
+	}
+	return nil
+}
+
+/**
+ * Return true if a session data is addressed by
+ * a to value like a username, "all" or #channel.
+ *
+ * @author DanielWHoward
+ **/
+func isAddressed(sessionData map[string]interface{}, to string) bool {
+	if to == "all" {
+		return true
+	}
+	if (len(to) > 1) && (to[0:1] == "#") {
+		for _, c := range Channels(sessionData) {
+			if c == to[1:] {
+				return true
+			}
+		}
+		return false
+	}
+	username, _ := sessionData["_username"].(string)
+	return username == to
+}
+
+/**
+ * Validate a channel name and remove the #.
+ *
+ * @author DanielWHoward
+ **/
+func channelName(channel string) (string, error) {
+	if (len(channel) > 0) && (channel[0:1] == "#") {
+		channel = channel[1:]
+	}
+	if !channelPattern.MatchString(channel) {
+		return "", errors.New("malformed--channel")
+	}
+	return channel, nil
+}
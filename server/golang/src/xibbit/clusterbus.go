@@ -0,0 +1,370 @@
+// The MIT License (MIT)
+//
+// xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// @version 2.0.0
+// @copyright xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+// @license http://opensource.org/licenses/MIT
+package xibbit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+/**
+ * A broadcast bus that connects the hubs of a
+ * cluster.  Every message published by a node is
+ * delivered to every other subscribed node.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type ClusterBus interface {
+	// send a JSON-friendly message to the other nodes
+	Publish(node string, msg map[string]interface{}) error
+	// receive messages published by the other nodes
+	Subscribe(node string, fn func(from string, msg map[string]interface{})) error
+	// stop receiving messages
+	Unsubscribe(node string)
+}
+
+/**
+ * Return a copy of a message that has been through
+ * JSON like it would on a real network.
+ *
+ * @author DanielWHoward
+ **/
+func cloneClusterMessage(msg map[string]interface{}) (map[string]interface{}, error) {
+	b, e := json.Marshal(msg)
+	if e != nil {
+		return nil, e
+	}
+	clone := map[string]interface{}{}
+	e = json.Unmarshal(b, &clone)
+	return clone, e
+}
+
+/**
+ * An in-process bus for hubs that share a process,
+ * usually in unit tests.  Messages are delivered
+ * before Publish() returns.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type MemoryClusterBus struct {
+	mu       sync.Mutex
+	handlers map[string]func(from string, msg map[string]interface{})
+}
+
+/**
+ * Constructor.
+ *
+ * @author DanielWHoward
+ **/
+func NewMemoryClusterBus() *MemoryClusterBus {
+	self := new(MemoryClusterBus)
+	self.handlers = map[string]func(from string, msg map[string]interface{}){}
+	return self
+}
+
+/**
+ * Deliver a message to every other node.
+ *
+ * @param node string The publishing node.
+ * @param msg map The message.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemoryClusterBus) Publish(node string, msg map[string]interface{}) error {
+	self.mu.Lock()
+	handlers := map[string]func(from string, msg map[string]interface{}){}
+	for n, fn := range self.handlers {
+		if n != node {
+			handlers[n] = fn
+		}
+	}
+	self.mu.Unlock()
+	for _, fn := range handlers {
+		clone, e := cloneClusterMessage(msg)
+		if e != nil {
+			return e
+		}
+		fn(node, clone)
+	}
+	return nil
+}
+
+/**
+ * Receive messages from the other nodes.
+ *
+ * @param node string The subscribing node.
+ * @param fn func The message handler.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemoryClusterBus) Subscribe(node string, fn func(from string, msg map[string]interface{})) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.handlers[node] = fn
+	return nil
+}
+
+/**
+ * Stop receiving messages.
+ *
+ * @param node string The subscribing node.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemoryClusterBus) Unsubscribe(node string) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	delete(self.handlers, node)
+}
+
+/**
+ * A bus that uses the sockets_bus table so hub
+ * processes that share a database can talk to
+ * each other without any other service.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type SqlClusterBus struct {
+	link     *sql.DB
+	prefix   string
+	interval time.Duration
+	mu       sync.Mutex
+	stops    map[string]chan bool
+}
+
+/**
+ * Constructor.  The config has the same link and
+ * SQL_PREFIX keys as the hub's mysql config and an
+ * optional polling interval in milliseconds.
+ *
+ * @author DanielWHoward
+ **/
+func NewSqlClusterBus(config map[string]interface{}) *SqlClusterBus {
+	self := new(SqlClusterBus)
+	self.link, _ = config["link"].(*sql.DB)
+	self.prefix, _ = config["SQL_PREFIX"].(string)
+	self.interval = 100 * time.Millisecond
+	if ms, ok := config["interval"].(int); ok && (ms > 0) {
+		self.interval = time.Duration(ms) * time.Millisecond
+	}
+	self.stops = map[string]chan bool{}
+	return self
+}
+
+/**
+ * Add a message to the sockets_bus table.
+ *
+ * @param node string The publishing node.
+ * @param msg map The message.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlClusterBus) Publish(node string, msg map[string]interface{}) error {
+	b, e := json.Marshal(msg)
+	if e != nil {
+		return e
+	}
+	now := time.Now().Format("2006-01-02 15:04:05")
+	q := "INSERT INTO `" + self.prefix + "sockets_bus` "
+	q += "(`node`, `touched`, `msg`) VALUES (?, ?, ?);"
+	_, e = self.link.Exec(q, node, now, string(b))
+	return e
+}
+
+/**
+ * Poll the sockets_bus table for messages from the
+ * other nodes.  Only messages published after the
+ * subscription are delivered.
+ *
+ * @param node string The subscribing node.
+ * @param fn func The message handler.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlClusterBus) Subscribe(node string, fn func(from string, msg map[string]interface{})) error {
+	cursor := &busCursor{seen: map[int64]time.Time{}}
+	q := "SELECT COALESCE(MAX(`id`), 0) FROM `" + self.prefix + "sockets_bus`;"
+	if e := self.link.QueryRow(q).Scan(&cursor.floor); e != nil {
+		return e
+	}
+	stop := make(chan bool)
+	self.mu.Lock()
+	if old, ok := self.stops[node]; ok {
+		close(old)
+	}
+	self.stops[node] = stop
+	self.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(self.interval)
+		defer ticker.Stop()
+		cleaned := time.Now()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				self.poll(node, cursor, fn)
+				if time.Since(cleaned) > 10*time.Second {
+					self.deleteExpired()
+					cleaned = time.Now()
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+/**
+ * Stop polling for messages.
+ *
+ * @param node string The subscribing node.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlClusterBus) Unsubscribe(node string) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if stop, ok := self.stops[node]; ok {
+		close(stop)
+		delete(self.stops, node)
+	}
+}
+
+/**
+ * The messages that a node has read.
+ *
+ * Concurrent publishers can commit their IDs out of
+ * order so a lower ID can show up after a higher
+ * one.  Every ID up to floor has been read and the
+ * IDs above it that have been read are remembered
+ * until the gaps below them are filled or are too
+ * old to be filled.
+ *
+ * @author DanielWHoward
+ **/
+type busCursor struct {
+	floor int64
+	seen  map[int64]time.Time
+}
+
+/**
+ * Move the floor past the IDs that have been read
+ * and past the gaps that have been open too long.
+ *
+ * @param now Time The current time.
+ * @param window Duration How long to wait for a gap.
+ *
+ * @author DanielWHoward
+ **/
+func (self *busCursor) advance(now time.Time, window time.Duration) {
+	for len(self.seen) > 0 {
+		if _, ok := self.seen[self.floor+1]; ok {
+			self.floor++
+			delete(self.seen, self.floor)
+			continue
+		}
+		// the lowest ID that was read above the gap
+		lowest := int64(0)
+		for id, _ := range self.seen {
+			if (lowest == 0) || (id < lowest) {
+				lowest = id
+			}
+		}
+		if now.Sub(self.seen[lowest]) < window {
+			break
+		}
+		// the gap is a rollback or a deleted message
+		self.floor = lowest - 1
+	}
+}
+
+/**
+ * Deliver the messages after the floor of the
+ * cursor that have not been read yet.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlClusterBus) poll(node string, cursor *busCursor, fn func(from string, msg map[string]interface{})) {
+	q := "SELECT `id`, `node`, `msg` FROM `" + self.prefix + "sockets_bus` "
+	q += "WHERE `id` > ? ORDER BY `id`;"
+	rows, e := self.link.Query(q, cursor.floor)
+	if e != nil {
+		log.Println(e)
+		return
+	}
+	now := time.Now()
+	type message struct {
+		from string
+		msg  map[string]interface{}
+	}
+	messages := []message{}
+	for rows.Next() {
+		id := int64(0)
+		from := ""
+		s := ""
+		if e = rows.Scan(&id, &from, &s); e != nil {
+			log.Println(e)
+			break
+		}
+		if _, ok := cursor.seen[id]; ok {
+			continue
+		}
+		cursor.seen[id] = now
+		if from != node {
+			msg := map[string]interface{}{}
+			if e = json.Unmarshal([]byte(s), &msg); e == nil {
+				messages = append(messages, message{from, msg})
+			} else {
+				log.Println(e)
+			}
+		}
+	}
+	rows.Close()
+	cursor.advance(now, 5*time.Second)
+	for _, m := range messages {
+		fn(m.from, m.msg)
+	}
+}
+
+/**
+ * Delete messages that every node has had time
+ * to read.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlClusterBus) deleteExpired() {
+	expiration := time.Now().Add(-time.Minute).Format("2006-01-02 15:04:05")
+	q := "DELETE FROM `" + self.prefix + "sockets_bus` WHERE `touched` < ?;"
+	if _, e := self.link.Exec(q, expiration); e != nil {
+		log.Println(e)
+	}
+}
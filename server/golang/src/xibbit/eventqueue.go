@@ -0,0 +1,390 @@
+// The MIT License (MIT)
+//
+// xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// @version 2.0.0
+// @copyright xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+// @license http://opensource.org/licenses/MIT
+package xibbit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ * Events waiting to be delivered to instances.
+ *
+ * Each event gets a sequence number that goes up by
+ * one for each instance.  It never goes backwards,
+ * even after the events of an instance expire, so a
+ * hub never skips a new event because it sent an
+ * old one with the same number.  Events are kept
+ * until the client acks them or they expire.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type EventQueue interface {
+	// add an event for an instance and return its sequence number
+	Push(instance string, event map[string]interface{}) (int, error)
+	// return the unacked events after a sequence number in order
+	Read(instance string, after int) ([]map[string]interface{}, error)
+	// ack the events up to and including a sequence number
+	Ack(instance string, seq int) error
+	// remove the events that are older than secs
+	Expire(secs int) error
+}
+
+/**
+ * An event that is waiting in memory.
+ *
+ * @author DanielWHoward
+ **/
+type queuedEvent struct {
+	seq     int
+	event   map[string]interface{}
+	touched time.Time
+}
+
+/**
+ * The events and last sequence number for an
+ * instance.
+ *
+ * @author DanielWHoward
+ **/
+type memoryEventQueue struct {
+	seq     int
+	events  []queuedEvent
+	touched time.Time
+}
+
+/**
+ * An event queue for a single process.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type MemoryEventQueue struct {
+	mu        sync.Mutex
+	instances map[string]*memoryEventQueue
+}
+
+/**
+ * Constructor.
+ *
+ * @author DanielWHoward
+ **/
+func NewMemoryEventQueue() *MemoryEventQueue {
+	self := new(MemoryEventQueue)
+	self.instances = map[string]*memoryEventQueue{}
+	return self
+}
+
+/**
+ * Add an event for an instance.
+ *
+ * @param instance string An instance string.
+ * @param event map The event.
+ * @return int The sequence number of the event.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemoryEventQueue) Push(instance string, event map[string]interface{}) (int, error) {
+	b, e := json.Marshal(event)
+	if e != nil {
+		return 0, e
+	}
+	clone := map[string]interface{}{}
+	json.Unmarshal(b, &clone)
+	now := time.Now()
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	queue, ok := self.instances[instance]
+	if !ok {
+		queue = &memoryEventQueue{}
+		self.instances[instance] = queue
+	}
+	queue.seq++
+	queue.events = append(queue.events, queuedEvent{queue.seq, clone, now})
+	queue.touched = now
+	return queue.seq, nil
+}
+
+/**
+ * Return the events after a sequence number.
+ *
+ * @param instance string An instance string.
+ * @param after int A sequence number or 0.
+ * @return array The events with a _seq property.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemoryEventQueue) Read(instance string, after int) ([]map[string]interface{}, error) {
+	events := []map[string]interface{}{}
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if queue, ok := self.instances[instance]; ok {
+		for _, queued := range queue.events {
+			if queued.seq > after {
+				event := make(map[string]interface{}, len(queued.event)+1)
+				for key, value := range queued.event {
+					event[key] = value
+				}
+				event["_seq"] = queued.seq
+				events = append(events, event)
+			}
+		}
+	}
+	return events, nil
+}
+
+/**
+ * Remove the events up to and including a sequence
+ * number.
+ *
+ * @param instance string An instance string.
+ * @param seq int A sequence number.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemoryEventQueue) Ack(instance string, seq int) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if queue, ok := self.instances[instance]; ok {
+		events := []queuedEvent{}
+		for _, queued := range queue.events {
+			if queued.seq > seq {
+				events = append(events, queued)
+			}
+		}
+		queue.events = events
+		queue.touched = time.Now()
+	}
+	return nil
+}
+
+/**
+ * Remove the events that are older than secs.  An
+ * instance keeps its sequence number.
+ *
+ * @param secs int A number of seconds.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemoryEventQueue) Expire(secs int) error {
+	expiration := time.Now().Add(-time.Second * time.Duration(secs))
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	for _, queue := range self.instances {
+		events := []queuedEvent{}
+		for _, queued := range queue.events {
+			if !queued.touched.Before(expiration) {
+				events = append(events, queued)
+			}
+		}
+		queue.events = events
+	}
+	return nil
+}
+
+/**
+ * An event queue that keeps the events in the
+ * sockets_events table so every process can deliver
+ * them.
+ *
+ * Acked and expired events are marked instead of
+ * deleted while they are the latest event of their
+ * instance so its sequence number never goes
+ * backwards.  Rows without a seq are not queued
+ * events and are left alone.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type SqlEventQueue struct {
+	link   *sql.DB
+	prefix string
+}
+
+/**
+ * Constructor.  The config has the same link and
+ * SQL_PREFIX keys as the hub's mysql config.
+ *
+ * @author DanielWHoward
+ **/
+func NewSqlEventQueue(config map[string]interface{}) *SqlEventQueue {
+	self := new(SqlEventQueue)
+	self.link, _ = config["link"].(*sql.DB)
+	self.prefix, _ = config["SQL_PREFIX"].(string)
+	return self
+}
+
+/**
+ * Add an event for an instance.
+ *
+ * @param instance string An instance string.
+ * @param event map The event.
+ * @return int The sequence number of the event.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlEventQueue) Push(instance string, event map[string]interface{}) (int, error) {
+	b, e := json.Marshal(event)
+	if e != nil {
+		return 0, e
+	}
+	now := time.Now().Format("2006-01-02 15:04:05")
+	table := "`" + self.prefix + "sockets_events`"
+	// the next sequence number is computed in the same statement
+	q := "INSERT INTO " + table + " (`sid`, `seq`, `acked`, `event`, `touched`) "
+	q += "SELECT ?, COALESCE(MAX(`seq`), 0)+1, 0, ?, ? FROM " + table + " WHERE `sid` = ?;"
+	var result sql.Result
+	for tries := 0; tries < 3; tries++ {
+		result, e = self.link.Exec(q, instance, string(b), now, instance)
+		// concurrent pushes for an instance can deadlock or
+		//  pick the same sequence number so try again
+		if (e == nil) || ((mysqlErrno(e) != 1213) && (mysqlErrno(e) != 1062)) {
+			break
+		}
+	}
+	if e != nil {
+		return 0, e
+	}
+	id, e := result.LastInsertId()
+	if e != nil {
+		return 0, e
+	}
+	seq := 0
+	q = "SELECT `seq` FROM " + table + " WHERE `id` = ?;"
+	e = self.link.QueryRow(q, id).Scan(&seq)
+	return seq, e
+}
+
+/**
+ * Return the events after a sequence number.
+ *
+ * @param instance string An instance string.
+ * @param after int A sequence number or 0.
+ * @return array The events with a _seq property.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlEventQueue) Read(instance string, after int) ([]map[string]interface{}, error) {
+	events := []map[string]interface{}{}
+	q := "SELECT `seq`, `event` FROM `" + self.prefix + "sockets_events` "
+	q += "WHERE (`sid` = ? AND `seq` > ? AND `acked` = 0) ORDER BY `seq`;"
+	rows, e := self.link.Query(q, instance, after)
+	if e != nil {
+		return events, e
+	}
+	defer rows.Close()
+	for rows.Next() {
+		seq := 0
+		var evt sql.NullString
+		if e = rows.Scan(&seq, &evt); e != nil {
+			return events, e
+		}
+		event := map[string]interface{}{}
+		if json.Unmarshal([]byte(evt.String), &event) == nil {
+			event["_seq"] = seq
+			events = append(events, event)
+		}
+	}
+	return events, rows.Err()
+}
+
+/**
+ * Delete the events up to and including a sequence
+ * number, keeping the latest one as a marker.
+ *
+ * @param instance string An instance string.
+ * @param seq int A sequence number.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlEventQueue) Ack(instance string, seq int) error {
+	table := "`" + self.prefix + "sockets_events`"
+	var last sql.NullInt64
+	q := "SELECT MAX(`seq`) FROM " + table + " WHERE `sid` = ?;"
+	if e := self.link.QueryRow(q, instance).Scan(&last); e != nil {
+		return e
+	}
+	q = "DELETE FROM " + table + " WHERE (`sid` = ? AND `seq` <= ? AND `seq` < ?);"
+	if _, e := self.link.Exec(q, instance, seq, last.Int64); e != nil {
+		return e
+	}
+	q = "UPDATE " + table + " SET `acked` = 1 WHERE (`sid` = ? AND `seq` <= ?);"
+	_, e := self.link.Exec(q, instance, seq)
+	return e
+}
+
+/**
+ * Delete the events that are older than secs,
+ * keeping the latest one of each instance as a
+ * marker.
+ *
+ * @param secs int A number of seconds.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlEventQueue) Expire(secs int) error {
+	expiration := time.Now().Add(-time.Second * time.Duration(secs)).Format("2006-01-02 15:04:05")
+	table := "`" + self.prefix + "sockets_events`"
+	q := "DELETE `events` FROM " + table + " AS `events` JOIN "
+	q += "(SELECT `sid`, MAX(`seq`) AS `seq` FROM " + table + " WHERE `seq` IS NOT NULL GROUP BY `sid`) AS `latest` "
+	q += "ON `events`.`sid` = `latest`.`sid` "
+	q += "WHERE (`events`.`touched` < ? AND `events`.`seq` < `latest`.`seq`);"
+	if _, e := self.link.Exec(q, expiration); e != nil {
+		return e
+	}
+	q = "UPDATE " + table + " SET `acked` = 1 WHERE (`touched` < ? AND `seq` IS NOT NULL);"
+	_, e := self.link.Exec(q, expiration)
+	return e
+}
+
+/**
+ * Return the MySQL error number of an error or 0.
+ *
+ * @param e error An error from the database.
+ * @return int The error number.
+ *
+ * @author DanielWHoward
+ **/
+func mysqlErrno(e error) int {
+	msg := e.Error()
+	if !strings.HasPrefix(msg, "Error ") {
+		return 0
+	}
+	digits := msg[len("Error "):]
+	for d, c := range digits {
+		if (c < '0') || (c > '9') {
+			digits = digits[:d]
+			break
+		}
+	}
+	errno, _ := strconv.Atoi(digits)
+	return errno
+}
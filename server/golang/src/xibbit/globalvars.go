@@ -0,0 +1,279 @@
+// The MIT License (MIT)
+//
+// xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// @version 2.0.0
+// @copyright xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+// @license http://opensource.org/licenses/MIT
+package xibbit
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+/**
+ * A store for the global variables that are shared
+ * by the __clock event.  The variables should only
+ * be read and written while the store is locked.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type GlobalVarsStore interface {
+	// try to lock the global variables
+	Lock() bool
+	// unlock the global variables
+	Unlock() error
+	// read the global variables
+	Read() map[string]interface{}
+	// write the global variables
+	Write(vars map[string]interface{})
+}
+
+/**
+ * Global variables for a single hub process.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type MemoryGlobalVars struct {
+	mu   sync.Mutex
+	vars map[string]interface{}
+}
+
+/**
+ * Constructor.
+ *
+ * @author DanielWHoward
+ **/
+func NewMemoryGlobalVars() *MemoryGlobalVars {
+	self := new(MemoryGlobalVars)
+	self.vars = map[string]interface{}{}
+	return self
+}
+
+/**
+ * Lock the global variables.  This waits for other
+ * goroutines so it always succeeds.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemoryGlobalVars) Lock() bool {
+	self.mu.Lock()
+	return true
+}
+
+/**
+ * Unlock the global variables.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemoryGlobalVars) Unlock() error {
+	self.mu.Unlock()
+	return nil
+}
+
+/**
+ * Read the global variables.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemoryGlobalVars) Read() map[string]interface{} {
+	return self.vars
+}
+
+/**
+ * Write the global variables.
+ *
+ * @param vars map The JSON-friendly vars to save.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemoryGlobalVars) Write(vars map[string]interface{}) {
+	self.vars = vars
+}
+
+/**
+ * Global variables in the sockets_sessions table
+ * that are shared by several hub processes.
+ *
+ * The 'global' row has the variables.  The 'lock'
+ * row is a lease that belongs to the process whose
+ * ID is in it.  A lease that is not released in
+ * time is taken over by another process.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type SqlGlobalVars struct {
+	link   *sql.DB
+	prefix string
+	lease  time.Duration
+	mu     sync.Mutex
+	lockId string
+}
+
+/**
+ * Constructor.  The config has the same link and
+ * SQL_PREFIX keys as the hub's mysql config and an
+ * optional lease in seconds.
+ *
+ * @author DanielWHoward
+ **/
+func NewSqlGlobalVars(config map[string]interface{}) *SqlGlobalVars {
+	self := new(SqlGlobalVars)
+	self.link, _ = config["link"].(*sql.DB)
+	self.prefix, _ = config["SQL_PREFIX"].(string)
+	self.lease = 60 * time.Second
+	if secs, ok := config["lease"].(int); ok && (secs > 0) {
+		self.lease = time.Duration(secs) * time.Second
+	}
+	return self
+}
+
+/**
+ * Try to take the lease on the global variables.
+ *
+ * @return boolean True if this process has the lock.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlGlobalVars) Lock() bool {
+	now := time.Now()
+	// generate a unique lock identifier
+	b := make([]byte, 16)
+	if _, e := rand.Read(b); e != nil {
+		log.Println(e)
+		return false
+	}
+	lockId := hex.EncodeToString(b)
+	vars, _ := json.Marshal(map[string]interface{}{"id": lockId})
+	// release the lock if it has been too long
+	q := "DELETE FROM `" + self.prefix + "sockets_sessions` "
+	q += "WHERE `socksessid` = 'lock' AND `touched` < ?;"
+	if _, e := self.link.Exec(q, now.Add(-self.lease).Format("2006-01-02 15:04:05")); e != nil {
+		log.Println(e)
+	}
+	// try to get the lock
+	q = "INSERT INTO `" + self.prefix + "sockets_sessions` "
+	q += "(`socksessid`, `connected`, `touched`, `vars`) VALUES ('lock', ?, ?, ?);"
+	nowStr := now.Format("2006-01-02 15:04:05")
+	if _, e := self.link.Exec(q, nowStr, nowStr, string(vars)); e != nil {
+		return false
+	}
+	// retrieve lock ID and confirm that it's the same
+	q = "SELECT `vars` FROM `" + self.prefix + "sockets_sessions` WHERE `socksessid` = 'lock';"
+	s := ""
+	if e := self.link.QueryRow(q).Scan(&s); e != nil {
+		log.Println(e)
+		return false
+	}
+	owner := map[string]interface{}{}
+	if e := json.Unmarshal([]byte(s), &owner); (e != nil) || (owner["id"] != lockId) {
+		return false
+	}
+	self.mu.Lock()
+	self.lockId = lockId
+	self.mu.Unlock()
+	return true
+}
+
+/**
+ * Release the lease if this process still has it.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlGlobalVars) Unlock() error {
+	self.mu.Lock()
+	lockId := self.lockId
+	self.lockId = ""
+	self.mu.Unlock()
+	vars, _ := json.Marshal(map[string]interface{}{"id": lockId})
+	q := "DELETE FROM `" + self.prefix + "sockets_sessions` "
+	q += "WHERE `socksessid` = 'lock' AND `vars` = ?;"
+	_, e := self.link.Exec(q, string(vars))
+	return e
+}
+
+/**
+ * Release the lease only if this process has it,
+ * for example when the hub is stopped while the
+ * __clock event is running.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlGlobalVars) Release() error {
+	self.mu.Lock()
+	locked := self.lockId != ""
+	self.mu.Unlock()
+	if !locked {
+		return nil
+	}
+	return self.Unlock()
+}
+
+/**
+ * Read global variables from database.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlGlobalVars) Read() map[string]interface{} {
+	vars := map[string]interface{}{}
+	q := "SELECT `vars` FROM `" + self.prefix + "sockets_sessions` WHERE `socksessid` = 'global';"
+	s := sql.NullString{}
+	if e := self.link.QueryRow(q).Scan(&s); e != nil {
+		if e != sql.ErrNoRows {
+			log.Println(e)
+		}
+	} else if e = json.Unmarshal([]byte(s.String), &vars); e != nil {
+		log.Println(e)
+	}
+	return vars
+}
+
+/**
+ * Write global variables to database.
+ *
+ * @param vars map The JSON-friendly vars to save.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlGlobalVars) Write(vars map[string]interface{}) {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	b, e := json.Marshal(vars)
+	if e != nil {
+		log.Println(e)
+		return
+	}
+	q := "UPDATE `" + self.prefix + "sockets_sessions` SET "
+	q += "`touched` = ?, "
+	q += "`vars` = ? "
+	q += "WHERE `socksessid` = 'global';"
+	if _, e = self.link.Exec(q, now, string(b)); e != nil {
+		log.Println(e)
+	}
+}
@@ -0,0 +1,406 @@
+// The MIT License (MIT)
+//
+// xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// @version 2.0.0
+// @copyright xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+// @license http://opensource.org/licenses/MIT
+package xibbit
+
+import (
+	"sync"
+	"time"
+)
+
+/**
+ * An output stream that can write several events to
+ * a socket in one emit.  The client gets an array
+ * of events instead of one event.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type XibbitHubBatchWriter interface {
+	// write several events to a socket at once
+	WriteBatch(sock *SocketWrapper, eventName string, data []map[string]interface{})
+}
+
+/**
+ * Write several events to a socket in one emit.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHubOutputStreamImpl) WriteBatch(sock *SocketWrapper, eventName string, data []map[string]interface{}) {
+	sock.Emit(eventName, data)
+}
+
+/**
+ * Write several events to a stream, in one emit if
+ * the stream supports it.
+ *
+ * @author DanielWHoward
+ **/
+func writeBatch(stream XibbitHubOutputStream, sock *SocketWrapper, eventName string, data []map[string]interface{}) {
+	if len(data) == 1 {
+		stream.Write(sock, eventName, data[0])
+	} else if writer, ok := stream.(XibbitHubBatchWriter); ok {
+		writer.WriteBatch(sock, eventName, data)
+	} else {
+		for _, event := range data {
+			stream.Write(sock, eventName, event)
+		}
+	}
+}
+
+/**
+ * The events held for a socket.
+ *
+ * @author DanielWHoward
+ **/
+type outputBatch struct {
+	sock      *SocketWrapper
+	eventName string
+	events    []map[string]interface{}
+}
+
+/**
+ * An output stream that holds the events for each
+ * socket until Flush() and then writes them in one
+ * emit.  A single event is written as usual.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type BatchingOutputStream struct {
+	next    XibbitHubOutputStream
+	mu      sync.Mutex
+	flushMu sync.Mutex
+	batches []*outputBatch
+	index   map[string]*outputBatch
+}
+
+/**
+ * Constructor.
+ *
+ * @param next XibbitHubOutputStream The stream to write the batches to.
+ *
+ * @author DanielWHoward
+ **/
+func NewBatchingOutputStream(next XibbitHubOutputStream) *BatchingOutputStream {
+	self := new(BatchingOutputStream)
+	self.next = next
+	self.index = map[string]*outputBatch{}
+	return self
+}
+
+/**
+ * Hold an event until Flush().
+ *
+ * @author DanielWHoward
+ **/
+func (self *BatchingOutputStream) Write(sock *SocketWrapper, eventName string, data map[string]interface{}) {
+	key := sock.ID() + "\x00" + eventName
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	batch, ok := self.index[key]
+	if !ok {
+		batch = &outputBatch{sock: sock, eventName: eventName}
+		self.batches = append(self.batches, batch)
+		self.index[key] = batch
+	}
+	batch.events = append(batch.events, data)
+}
+
+/**
+ * Write the held events with one emit per socket.
+ * A Flush() waits for a concurrent Flush() so that
+ * the events are written when it returns.
+ *
+ * @author DanielWHoward
+ **/
+func (self *BatchingOutputStream) Flush() {
+	self.flushMu.Lock()
+	defer self.flushMu.Unlock()
+	self.mu.Lock()
+	batches := self.batches
+	self.batches = nil
+	self.index = map[string]*outputBatch{}
+	self.mu.Unlock()
+	for _, batch := range batches {
+		writeBatch(self.next, batch.sock, batch.eventName, batch.events)
+	}
+	self.next.Flush()
+}
+
+/**
+ * An event that was written to an output stream.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type OutputRecord struct {
+	SocketId  string
+	EventName string
+	Data      map[string]interface{}
+	Time      time.Time
+}
+
+/**
+ * An output stream that records a copy of every
+ * event for tests and auditing.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type RecordingOutputStream struct {
+	next    XibbitHubOutputStream
+	mu      sync.Mutex
+	records []OutputRecord
+}
+
+/**
+ * Constructor.
+ *
+ * @param next XibbitHubOutputStream The stream to write to or nil to only record.
+ *
+ * @author DanielWHoward
+ **/
+func NewRecordingOutputStream(next XibbitHubOutputStream) *RecordingOutputStream {
+	self := new(RecordingOutputStream)
+	self.next = next
+	self.records = []OutputRecord{}
+	return self
+}
+
+/**
+ * Record an event and write it.
+ *
+ * @author DanielWHoward
+ **/
+func (self *RecordingOutputStream) Write(sock *SocketWrapper, eventName string, data map[string]interface{}) {
+	self.record(sock, eventName, data)
+	if self.next != nil {
+		self.next.Write(sock, eventName, data)
+	}
+}
+
+/**
+ * Record several events and write them.
+ *
+ * @author DanielWHoward
+ **/
+func (self *RecordingOutputStream) WriteBatch(sock *SocketWrapper, eventName string, data []map[string]interface{}) {
+	for _, event := range data {
+		self.record(sock, eventName, event)
+	}
+	if self.next != nil {
+		writeBatch(self.next, sock, eventName, data)
+	}
+}
+
+/**
+ * Save a copy of an event.
+ *
+ * @author DanielWHoward
+ **/
+func (self *RecordingOutputStream) record(sock *SocketWrapper, eventName string, data map[string]interface{}) {
+	clone, e := cloneClusterMessage(data)
+	if e != nil {
+		clone = data
+	}
+	self.mu.Lock()
+	self.records = append(self.records, OutputRecord{sock.ID(), eventName, clone, time.Now()})
+	self.mu.Unlock()
+}
+
+/**
+ * Flush the next stream.
+ *
+ * @author DanielWHoward
+ **/
+func (self *RecordingOutputStream) Flush() {
+	if self.next != nil {
+		self.next.Flush()
+	}
+}
+
+/**
+ * Return the recorded events.
+ *
+ * @return array The recorded events in order.
+ *
+ * @author DanielWHoward
+ **/
+func (self *RecordingOutputStream) Records() []OutputRecord {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return append([]OutputRecord{}, self.records...)
+}
+
+/**
+ * Forget the recorded events.
+ *
+ * @author DanielWHoward
+ **/
+func (self *RecordingOutputStream) Reset() {
+	self.mu.Lock()
+	self.records = []OutputRecord{}
+	self.mu.Unlock()
+}
+
+/**
+ * An event held by a BoundedOutputStream.
+ *
+ * @author DanielWHoward
+ **/
+type boundedEvent struct {
+	eventName string
+	data      map[string]interface{}
+}
+
+/**
+ * An output stream that holds at most a limited
+ * number of events for each socket until Flush().
+ *
+ * When a socket has too many events, an older event
+ * of the same type is replaced if coalescing and
+ * otherwise the oldest event is dropped.  Replies,
+ * which have an _id or __id, are only dropped if
+ * every held event is a reply.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type BoundedOutputStream struct {
+	next     XibbitHubOutputStream
+	limit    int
+	coalesce bool
+	mu       sync.Mutex
+	socks    []*SocketWrapper
+	pending  map[string][]boundedEvent
+	dropped  int
+}
+
+/**
+ * Constructor.
+ *
+ * @param next XibbitHubOutputStream The stream to write to.
+ * @param limit int The most events to hold for a socket.
+ * @param coalesce boolean Replace events of the same type.
+ *
+ * @author DanielWHoward
+ **/
+func NewBoundedOutputStream(next XibbitHubOutputStream, limit int, coalesce bool) *BoundedOutputStream {
+	self := new(BoundedOutputStream)
+	self.next = next
+	self.limit = limit
+	if self.limit < 1 {
+		self.limit = 1
+	}
+	self.coalesce = coalesce
+	self.pending = map[string][]boundedEvent{}
+	return self
+}
+
+/**
+ * Hold an event until Flush(), dropping or
+ * coalescing if there are too many.
+ *
+ * @author DanielWHoward
+ **/
+func (self *BoundedOutputStream) Write(sock *SocketWrapper, eventName string, data map[string]interface{}) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	id := sock.ID()
+	queue, ok := self.pending[id]
+	if !ok {
+		self.socks = append(self.socks, sock)
+	}
+	if len(queue) >= self.limit {
+		remove := -1
+		if self.coalesce && !isReply(data) {
+			for i, held := range queue {
+				if (held.eventName == eventName) && !isReply(held.data) && (held.data["type"] == data["type"]) {
+					remove = i
+					break
+				}
+			}
+		}
+		// drop the oldest event that is not a reply
+		for i := 0; (remove < 0) && (i < len(queue)); i++ {
+			if !isReply(queue[i].data) {
+				remove = i
+			}
+		}
+		if remove < 0 {
+			remove = 0
+		}
+		queue = append(queue[:remove:remove], queue[remove+1:]...)
+		self.dropped++
+	}
+	self.pending[id] = append(queue, boundedEvent{eventName, data})
+}
+
+/**
+ * Write the held events.
+ *
+ * @author DanielWHoward
+ **/
+func (self *BoundedOutputStream) Flush() {
+	self.mu.Lock()
+	socks := self.socks
+	pending := self.pending
+	self.socks = nil
+	self.pending = map[string][]boundedEvent{}
+	self.mu.Unlock()
+	for _, sock := range socks {
+		for _, held := range pending[sock.ID()] {
+			self.next.Write(sock, held.eventName, held.data)
+		}
+	}
+	self.next.Flush()
+}
+
+/**
+ * Return the number of events that were dropped or
+ * coalesced.
+ *
+ * @return int The number of events.
+ *
+ * @author DanielWHoward
+ **/
+func (self *BoundedOutputStream) Dropped() int {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.dropped
+}
+
+/**
+ * Return true if an event is the reply to a client
+ * event.
+ *
+ * @author DanielWHoward
+ **/
+func isReply(event map[string]interface{}) bool {
+	_, id := event["_id"]
+	_, id2 := event["__id"]
+	return id || id2
+}
@@ -0,0 +1,318 @@
+// The MIT License (MIT)
+//
+// xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// @version 2.0.0
+// @copyright xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+// @license http://opensource.org/licenses/MIT
+package xibbit
+
+import (
+	"sort"
+	"time"
+)
+
+/**
+ * The presence of one instance.
+ *
+ * @author DanielWHoward
+ **/
+type presenceInstance struct {
+	username  string
+	connected bool
+	left      time.Time
+	touched   time.Time
+}
+
+/**
+ * Change the username and connected state of an
+ * instance and remember when it lost its sockets.
+ *
+ * @author DanielWHoward
+ **/
+func (p *presenceInstance) update(username string, connected bool, now time.Time, active bool) {
+	if p.connected && !connected {
+		p.left = now
+	}
+	p.connected = connected
+	p.username = username
+	if active {
+		p.touched = now
+	}
+}
+
+/**
+ * Return the users that are online on this node
+ * and, in a cluster, on the other nodes.
+ *
+ * A user is online if one of their instances has a
+ * socket or lost its last socket less than the
+ * presenceGrace seconds ago.
+ *
+ * @return array Maps with username, instances and touched keys.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) Presence() []map[string]interface{} {
+	now := time.Now()
+	users := map[string]map[string]interface{}{}
+	self.presenceMu.Lock()
+	for _, p := range self.allPresence() {
+		if !self.isPresent(p, now) {
+			continue
+		}
+		user, ok := users[p.username]
+		if !ok {
+			user = map[string]interface{}{
+				"username":  p.username,
+				"instances": 0,
+				"touched":   p.touched,
+			}
+			users[p.username] = user
+		}
+		user["instances"] = user["instances"].(int) + 1
+		if p.touched.After(user["touched"].(time.Time)) {
+			user["touched"] = p.touched
+		}
+	}
+	self.presenceMu.Unlock()
+	usernames := []string{}
+	for username, _ := range users {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	presence := []map[string]interface{}{}
+	for _, username := range usernames {
+		presence = append(presence, users[username])
+	}
+	return presence
+}
+
+/**
+ * Return the presence of the instances on this node
+ * and on the other nodes.  The caller holds the
+ * presence lock.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) allPresence() []*presenceInstance {
+	all := []*presenceInstance{}
+	for _, p := range self.presence {
+		all = append(all, p)
+	}
+	for _, instances := range self.clusterPresence {
+		for _, p := range instances {
+			all = append(all, p)
+		}
+	}
+	return all
+}
+
+/**
+ * Return true if an instance makes its user online.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) isPresent(p *presenceInstance, now time.Time) bool {
+	if p.username == "" {
+		return false
+	}
+	return p.connected || (now.Sub(p.left) < self.presenceGrace)
+}
+
+/**
+ * Update the presence of some instances after their
+ * sessions changed and send presence_join and
+ * presence_leave events.
+ *
+ * @param active boolean The instances sent an event.
+ * @param instances string The instances that changed.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) updatePresence(active bool, instances ...string) {
+	now := time.Now()
+	self.presenceMu.Lock()
+	for _, instance := range instances {
+		if instance != "" {
+			self.setPresence(instance, self.Sessions.GetSessionByInstance(instance), now, active)
+		}
+	}
+	joins, leaves := self.diffPresence(now)
+	self.presenceMu.Unlock()
+	self.sendPresence(joins, leaves)
+}
+
+/**
+ * Update the presence of every instance so expired
+ * sessions and grace periods are noticed.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) sweepPresence() {
+	now := time.Now()
+	self.presenceMu.Lock()
+	instances := map[string]bool{}
+	for _, session := range self.Sessions.All() {
+		sessionData, _ := session["session_data"].(map[string]interface{})
+		if instance, _ := sessionData["instance_id"].(string); instance != "" {
+			instances[instance] = true
+			self.setPresence(instance, session, now, false)
+		}
+	}
+	for instance, _ := range self.presence {
+		if !instances[instance] {
+			delete(self.presence, instance)
+		}
+	}
+	joins, leaves := self.diffPresence(now)
+	self.presenceMu.Unlock()
+	self.sendPresence(joins, leaves)
+}
+
+/**
+ * Copy the username and sockets of a session into
+ * the presence of its instance.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) setPresence(instance string, session map[string]interface{}, now time.Time, active bool) {
+	if session == nil {
+		delete(self.presence, instance)
+		return
+	}
+	sessionData, _ := session["session_data"].(map[string]interface{})
+	username, _ := sessionData["_username"].(string)
+	connected := false
+	if _conn, ok := session["_conn"].(map[string]interface{}); ok {
+		socks, _ := _conn["sockets"].([]*SocketWrapper)
+		connected = len(socks) > 0
+	}
+	p, ok := self.presence[instance]
+	if !ok {
+		p = &presenceInstance{touched: now}
+		self.presence[instance] = p
+	}
+	p.update(username, connected, now, active)
+}
+
+/**
+ * Update the presence of an instance on another node
+ * in the cluster, or forget all of the instances of
+ * the node if instance is empty, and send
+ * presence_join and presence_leave events.
+ *
+ * @param node string The node with the instance.
+ * @param instance string The instance or "".
+ * @param sessionData map The session_data or nil if it is gone.
+ * @param connected boolean The instance has sockets.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) updateClusterPresence(node string, instance string, sessionData map[string]interface{}, connected bool) {
+	now := time.Now()
+	self.presenceMu.Lock()
+	if instance == "" {
+		delete(self.clusterPresence, node)
+	} else if sessionData == nil {
+		delete(self.clusterPresence[node], instance)
+	} else {
+		if _, ok := self.clusterPresence[node]; !ok {
+			self.clusterPresence[node] = map[string]*presenceInstance{}
+		}
+		p, ok := self.clusterPresence[node][instance]
+		if !ok {
+			p = &presenceInstance{touched: now}
+			self.clusterPresence[node][instance] = p
+		}
+		username, _ := sessionData["_username"].(string)
+		p.update(username, connected, now, true)
+	}
+	joins, leaves := self.diffPresence(now)
+	self.presenceMu.Unlock()
+	self.sendPresence(joins, leaves)
+}
+
+/**
+ * Return the users that came online and went
+ * offline since the last time.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) diffPresence(now time.Time) (joins []string, leaves []string) {
+	online := map[string]bool{}
+	for _, p := range self.allPresence() {
+		if self.isPresent(p, now) {
+			online[p.username] = true
+		}
+	}
+	for username, _ := range online {
+		if !self.presenceOnline[username] {
+			joins = append(joins, username)
+		}
+	}
+	for username, _ := range self.presenceOnline {
+		if !online[username] {
+			leaves = append(leaves, username)
+		}
+	}
+	sort.Strings(joins)
+	sort.Strings(leaves)
+	self.presenceOnline = online
+	return
+}
+
+/**
+ * Send presence_join and presence_leave events to
+ * all users if the presenceEvents config is true.
+ *
+ * In a cluster, every node knows the presence of
+ * the whole cluster so each node only tells its
+ * own sockets.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) sendPresence(joins []string, leaves []string) {
+	if !self.presenceEvents {
+		return
+	}
+	send := func(event map[string]interface{}) {
+		if self.cluster != nil {
+			self.emit(event, "all")
+		} else {
+			self.Send(event, "", false)
+		}
+	}
+	for _, username := range joins {
+		send(map[string]interface{}{
+			"type":     "presence_join",
+			"to":       "all",
+			"username": username,
+		})
+	}
+	for _, username := range leaves {
+		send(map[string]interface{}{
+			"type":     "presence_leave",
+			"to":       "all",
+			"username": username,
+		})
+	}
+}
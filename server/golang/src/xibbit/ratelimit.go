@@ -0,0 +1,292 @@
+// The MIT License (MIT)
+//
+// xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// @version 2.0.0
+// @copyright xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+// @license http://opensource.org/licenses/MIT
+package xibbit
+
+import (
+	"database/sql"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+/**
+ * Token buckets that limit how often something can
+ * happen.  Each key has a bucket that holds up to
+ * burst tokens and refills at rate tokens per second.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type RateLimiter interface {
+	// take a token or return how long until one is available
+	Allow(key string, rate float64, burst float64) (bool, time.Duration)
+}
+
+/**
+ * Take a token from a bucket.
+ *
+ * @param tokens float The tokens in the bucket.
+ * @param last float The time of the last update in seconds.
+ * @param now float The current time in seconds.
+ * @return The new tokens, whether a token was taken and the wait.
+ *
+ * @author DanielWHoward
+ **/
+func takeToken(tokens float64, last float64, now float64, rate float64, burst float64) (float64, bool, time.Duration) {
+	tokens = math.Min(burst, tokens+(now-last)*rate)
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+	wait := time.Duration((1 - tokens) / rate * float64(time.Second))
+	return tokens, false, wait
+}
+
+/**
+ * Token buckets for a single hub process.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type MemoryRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string][2]float64
+	swept   time.Time
+}
+
+/**
+ * Constructor.
+ *
+ * @author DanielWHoward
+ **/
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	self := new(MemoryRateLimiter)
+	self.buckets = map[string][2]float64{}
+	self.swept = time.Now()
+	return self
+}
+
+/**
+ * Take a token from the bucket for a key.
+ *
+ * @param key string The bucket.
+ * @param rate float The tokens added per second.
+ * @param burst float The most tokens in the bucket.
+ * @return The success and the time until a token is available.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemoryRateLimiter) Allow(key string, rate float64, burst float64) (bool, time.Duration) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	now := float64(time.Now().UnixNano()) / float64(time.Second)
+	bucket, ok := self.buckets[key]
+	if !ok {
+		bucket = [2]float64{burst, now}
+	}
+	tokens, allowed, wait := takeToken(bucket[0], bucket[1], now, rate, burst)
+	self.buckets[key] = [2]float64{tokens, now}
+	// forget buckets that have not been used for a while
+	if time.Since(self.swept) > time.Minute {
+		for k, b := range self.buckets {
+			if now-b[1] > 60*60 {
+				delete(self.buckets, k)
+			}
+		}
+		self.swept = time.Now()
+	}
+	return allowed, wait
+}
+
+/**
+ * Token buckets that are kept in the global variables
+ * of a store that is not shared between hub processes.
+ * Use SqlRateLimiter to share buckets instead.
+ *
+ * If the global variables cannot be locked, the event
+ * is denied so that a flood cannot get through.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type GlobalVarsRateLimiter struct {
+	store GlobalVarsStore
+}
+
+/**
+ * Constructor.
+ *
+ * @author DanielWHoward
+ **/
+func NewGlobalVarsRateLimiter(store GlobalVarsStore) *GlobalVarsRateLimiter {
+	self := new(GlobalVarsRateLimiter)
+	self.store = store
+	return self
+}
+
+/**
+ * Take a token from the bucket for a key.
+ *
+ * @param key string The bucket.
+ * @param rate float The tokens added per second.
+ * @param burst float The most tokens in the bucket.
+ * @return The success and the time until a token is available.
+ *
+ * @author DanielWHoward
+ **/
+func (self *GlobalVarsRateLimiter) Allow(key string, rate float64, burst float64) (bool, time.Duration) {
+	if !self.store.Lock() {
+		return false, time.Second
+	}
+	defer self.store.Unlock()
+	vars := self.store.Read()
+	buckets, _ := vars["_rateLimits"].(map[string]interface{})
+	if buckets == nil {
+		buckets = map[string]interface{}{}
+	}
+	now := float64(time.Now().UnixNano()) / float64(time.Second)
+	tokens := burst
+	last := now
+	if bucket, ok := buckets[key].(map[string]interface{}); ok {
+		tokens, _ = toFloat(bucket["tokens"])
+		last, _ = toFloat(bucket["last"])
+	}
+	tokens, allowed, wait := takeToken(tokens, last, now, rate, burst)
+	buckets[key] = map[string]interface{}{"tokens": tokens, "last": now}
+	// forget buckets that have not been used for a while
+	for k, b := range buckets {
+		if bucket, ok := b.(map[string]interface{}); ok {
+			if last, _ := toFloat(bucket["last"]); now-last > 60*60 {
+				delete(buckets, k)
+			}
+		}
+	}
+	vars["_rateLimits"] = buckets
+	self.store.Write(vars)
+	return allowed, wait
+}
+
+/**
+ * Token buckets in the sockets_ratelimits table that
+ * are shared by several hub processes.
+ *
+ * Each bucket is a row that is refilled and taken
+ * from in one UPDATE so no lock is needed.  If the
+ * database fails, the event is denied.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type SqlRateLimiter struct {
+	link   *sql.DB
+	prefix string
+	mu     sync.Mutex
+	swept  time.Time
+}
+
+/**
+ * Constructor.  The config has the same link and
+ * SQL_PREFIX keys as the hub's mysql config.
+ *
+ * @author DanielWHoward
+ **/
+func NewSqlRateLimiter(config map[string]interface{}) *SqlRateLimiter {
+	self := new(SqlRateLimiter)
+	self.link, _ = config["link"].(*sql.DB)
+	self.prefix, _ = config["SQL_PREFIX"].(string)
+	self.swept = time.Now()
+	return self
+}
+
+/**
+ * Take a token from the bucket for a key.
+ *
+ * @param key string The bucket.
+ * @param rate float The tokens added per second.
+ * @param burst float The most tokens in the bucket.
+ * @return The success and the time until a token is available.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlRateLimiter) Allow(key string, rate float64, burst float64) (bool, time.Duration) {
+	table := "`" + self.prefix + "sockets_ratelimits`"
+	now := float64(time.Now().UnixNano()) / float64(time.Second)
+	self.sweep(now)
+	// refill and take a token only if there is one
+	q := "UPDATE " + table + " SET "
+	q += "`tokens` = LEAST(?, `tokens` + (? - `last`) * ?) - 1, "
+	q += "`last` = ? "
+	q += "WHERE (`bucket` = ? AND LEAST(?, `tokens` + (? - `last`) * ?) >= 1);"
+	for tries := 0; tries < 2; tries++ {
+		result, e := self.link.Exec(q, burst, now, rate, now, key, burst, now, rate)
+		if e != nil {
+			log.Println(e)
+			return false, time.Second
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			return true, 0
+		}
+		// find out how long to wait
+		tokens := 0.0
+		last := 0.0
+		qs := "SELECT `tokens`, `last` FROM " + table + " WHERE `bucket` = ?;"
+		e = self.link.QueryRow(qs, key).Scan(&tokens, &last)
+		if e == nil {
+			_, _, wait := takeToken(tokens, last, now, rate, burst)
+			return false, wait
+		} else if e != sql.ErrNoRows {
+			log.Println(e)
+			return false, time.Second
+		}
+		// a new bucket starts full
+		qi := "INSERT IGNORE INTO " + table + " (`bucket`, `tokens`, `last`) VALUES (?, ?, ?);"
+		if _, e = self.link.Exec(qi, key, burst, now); e != nil {
+			log.Println(e)
+			return false, time.Second
+		}
+	}
+	return false, time.Second
+}
+
+/**
+ * Forget buckets that have not been used for a while.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlRateLimiter) sweep(now float64) {
+	self.mu.Lock()
+	due := time.Since(self.swept) > time.Minute
+	if due {
+		self.swept = time.Now()
+	}
+	self.mu.Unlock()
+	if due {
+		q := "DELETE FROM `" + self.prefix + "sockets_ratelimits` WHERE `last` < ?;"
+		if _, e := self.link.Exec(q, now-60*60); e != nil {
+			log.Println(e)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+// The MIT License (MIT)
+//
+// xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// @version 2.0.0
+// @copyright xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+// @license http://opensource.org/licenses/MIT
+package xibbit
+
+import (
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/**
+ * Return an error string like "missing:to" if an event
+ * does not match a schema or the empty string if it
+ * does.
+ *
+ * A schema maps each property name to a type name like
+ * "string" or to a map with these keys:
+ *
+ *   type string "string", "number", "int", "bool", "object", "array" or "any"
+ *   optional bool True if the property can be missing
+ *   not array The values that are "invalid:" for a string
+ *   regexp string A regular expression for a string
+ *   enum array The allowed values
+ *   minLength int The shortest string or array
+ *   maxLength int The longest string or array
+ *   properties map A schema for an object
+ *   additionalProperties bool True to allow unknown properties in an object
+ *
+ * A map schema checks its properties in name order.  To
+ * check them in a declared order, the schema can be an
+ * array of these maps, each with a name key, instead.
+ * The properties of an object can also be an array.
+ *
+ * The type, from and underscore properties are always
+ * allowed in an event.  Any other unknown property is
+ * an error.
+ *
+ * @param event map The event to check.
+ * @param schema mixed The properties of the event as a map or array.
+ * @return string The error or the empty string.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) ValidateEvent(event map[string]interface{}, schema interface{}) string {
+	return validateObject(event, schema, false, "", true)
+}
+
+/**
+ * A property name and its schema in checking order.
+ *
+ * @author DanielWHoward
+ **/
+type schemaProperty struct {
+	name string
+	spec map[string]interface{}
+}
+
+/**
+ * Return the properties of a map or array schema in
+ * the order that they are checked.
+ *
+ * @author DanielWHoward
+ **/
+func schemaProperties(schema interface{}) []schemaProperty {
+	properties := []schemaProperty{}
+	if schemaMap, ok := schema.(map[string]interface{}); ok {
+		// check the properties in a predictable order
+		keys := make([]string, 0, len(schemaMap))
+		for key := range schemaMap {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			spec, ok := schemaMap[key].(map[string]interface{})
+			if typ, isStr := schemaMap[key].(string); isStr {
+				spec = map[string]interface{}{"type": typ}
+			} else if !ok {
+				spec = map[string]interface{}{}
+			}
+			properties = append(properties, schemaProperty{key, spec})
+		}
+	} else if schemaList, ok := schema.([]interface{}); ok {
+		// check the properties in the declared order
+		for _, item := range schemaList {
+			if spec, ok := item.(map[string]interface{}); ok {
+				name, _ := spec["name"].(string)
+				properties = append(properties, schemaProperty{name, spec})
+			}
+		}
+	}
+	return properties
+}
+
+/**
+ * Check the properties of an object against a schema.
+ *
+ * @author DanielWHoward
+ **/
+func validateObject(obj map[string]interface{}, schema interface{}, additional bool, path string, isEvent bool) string {
+	known := map[string]bool{}
+	for _, property := range schemaProperties(schema) {
+		key := property.name
+		spec := property.spec
+		known[key] = true
+		value, exists := obj[key]
+		if !exists {
+			if optional, _ := spec["optional"].(bool); !optional {
+				return "missing:" + path + key
+			}
+			continue
+		}
+		if msg := validateValue(value, spec, path+key); msg != "" {
+			return msg
+		}
+	}
+	// reject properties that are not in the schema
+	if !additional {
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if known[key] {
+				continue
+			}
+			if isEvent && ((key == "type") || (key == "from") || strings.HasPrefix(key, "_")) {
+				continue
+			}
+			return "property:" + path + key
+		}
+	}
+	return ""
+}
+
+/**
+ * Check one value against the schema for a property.
+ *
+ * @author DanielWHoward
+ **/
+func validateValue(value interface{}, spec map[string]interface{}, name string) string {
+	typ, _ := spec["type"].(string)
+	length := -1
+	switch typ {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return "typeof:" + name
+		}
+		length = len([]rune(s))
+		if not, ok := spec["not"].([]string); ok {
+			for _, invalid := range not {
+				if s == invalid {
+					return "invalid:" + name
+				}
+			}
+		}
+		if not, ok := spec["not"].([]interface{}); ok {
+			for _, invalid := range not {
+				if s == invalid {
+					return "invalid:" + name
+				}
+			}
+		}
+		if pattern, ok := spec["regexp"].(string); ok {
+			if matched, _ := regexp.MatchString(pattern, s); !matched {
+				return "regexp:" + name
+			}
+		}
+	case "number":
+		if _, ok := toFloat(value); !ok {
+			return "typeof:" + name
+		}
+	case "int":
+		if f, ok := toFloat(value); !ok || (f != math.Trunc(f)) {
+			return "typeof:" + name
+		}
+	case "bool":
+		if _, ok := value.(bool); !ok {
+			return "typeof:" + name
+		}
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return "typeof:" + name
+		}
+		properties := spec["properties"]
+		additional, _ := spec["additionalProperties"].(bool)
+		if properties == nil {
+			additional = true
+		}
+		if msg := validateObject(obj, properties, additional, name+".", false); msg != "" {
+			return msg
+		}
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return "typeof:" + name
+		}
+		length = len(arr)
+	}
+	if enum, ok := spec["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			// comparing maps or slices would panic
+			comparable := (allowed == nil) || reflect.TypeOf(allowed).Comparable()
+			if comparable && (allowed == value) {
+				found = true
+				break
+			}
+			f1, ok1 := toFloat(allowed)
+			f2, ok2 := toFloat(value)
+			if ok1 && ok2 && (f1 == f2) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "enum:" + name
+		}
+	}
+	if length != -1 {
+		if min, ok := spec["minLength"].(int); ok && (length < min) {
+			return "length:" + name
+		}
+		if max, ok := spec["maxLength"].(int); ok && (length > max) {
+			return "length:" + name
+		}
+	}
+	return ""
+}
+
+/**
+ * Return a number as a float64 whether it came from
+ * JSON or from Go code.
+ *
+ * @author DanielWHoward
+ **/
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,590 @@
+// The MIT License (MIT)
+//
+// xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// @version 2.0.0
+// @copyright xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+// @license http://opensource.org/licenses/MIT
+package xibbit
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+/**
+ * A store for the sessions of a hub.
+ *
+ * A session is a map with a session_data key and
+ * a _conn key.  The _conn key has a map with a
+ * sockets key that is an array of sockets.  The
+ * store only replaces these maps and arrays so
+ * the values it returns are safe to read from
+ * other goroutines.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type SessionStore interface {
+	// add a new, empty session only for this socket
+	AddSession(sock *SocketWrapper)
+	// add an existing session map
+	InsertSession(session map[string]interface{})
+	// the session that contains a socket or nil
+	GetSession(sockId string) map[string]interface{}
+	// the session for an instance or nil
+	GetSessionByInstance(instance_id string) map[string]interface{}
+	// the sessions for a username, #channel or "all" sessions
+	GetSessionsByUsername(username string) []map[string]interface{}
+	// change the session_data of the session with a socket
+	SetSessionData(sock *SocketWrapper, sessionData map[string]interface{}) bool
+	// remove a socket or the whole session
+	RemoveSocketFromSession(sock *SocketWrapper) bool
+	// move a socket to the session for an instance
+	CombineSessions(instance_id string, sock *SocketWrapper) bool
+	// the saved session_data for an instance or nil
+	LoadSessionData(instance_id string) map[string]interface{}
+	// remove sessions without sockets that are idle
+	Expire(secs int)
+	// a snapshot of all the sessions in insertion order
+	All() []map[string]interface{}
+	// the number of sessions
+	Len() int
+}
+
+/**
+ * A session store that can save all of its sessions,
+ * for example when the hub is stopped.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type PersistentSessionStore interface {
+	SessionStore
+	// save the session_data of all the instances
+	Persist() error
+}
+
+/**
+ * A session in a MemorySessionStore.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type memorySession struct {
+	prev     *memorySession
+	next     *memorySession
+	session  map[string]interface{}
+	instance string
+	username string
+	channels []string
+	touched  time.Time
+}
+
+/**
+ * Return the sockets in this session.
+ *
+ * @author DanielWHoward
+ **/
+func (self *memorySession) sockets() []*SocketWrapper {
+	if _conn, ok := self.session["_conn"].(map[string]interface{}); ok {
+		socks, _ := _conn["sockets"].([]*SocketWrapper)
+		return socks
+	}
+	return nil
+}
+
+/**
+ * Return the session_data in this session.
+ *
+ * @author DanielWHoward
+ **/
+func (self *memorySession) data() map[string]interface{} {
+	sessionData, _ := self.session["session_data"].(map[string]interface{})
+	return sessionData
+}
+
+/**
+ * An in-memory session store that is safe to use
+ * from multiple goroutines.  Sessions are indexed
+ * by socket ID, instance_id, _username and the
+ * channels in _channels.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type MemorySessionStore struct {
+	mu         sync.RWMutex
+	first      *memorySession
+	last       *memorySession
+	count      int
+	bySocket   map[string]*memorySession
+	byInstance map[string]*memorySession
+	byUsername map[string][]*memorySession
+	byChannel  map[string][]*memorySession
+}
+
+/**
+ * Constructor.
+ *
+ * @author DanielWHoward
+ **/
+func NewMemorySessionStore() *MemorySessionStore {
+	self := new(MemorySessionStore)
+	self.bySocket = map[string]*memorySession{}
+	self.byInstance = map[string]*memorySession{}
+	self.byUsername = map[string][]*memorySession{}
+	self.byChannel = map[string][]*memorySession{}
+	return self
+}
+
+/**
+ * Add a new, empty session only for this socket.
+ *
+ * @param sock socketio.Conn A socket.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) AddSession(sock *SocketWrapper) {
+	self.InsertSession(map[string]interface{}{
+		"session_data": map[string]interface{}{
+			"instance_id": "",
+		},
+		"_conn": map[string]interface{}{
+			"sockets": []*SocketWrapper{
+				sock,
+			},
+		},
+	})
+}
+
+/**
+ * Add a session.  The session_data and _conn keys
+ * are optional.
+ *
+ * @param session map The session object.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) InsertSession(session map[string]interface{}) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	// the sessions are a linked list in insertion order
+	entry := &memorySession{prev: self.last, session: session, touched: time.Now()}
+	if self.last == nil {
+		self.first = entry
+	} else {
+		self.last.next = entry
+	}
+	self.last = entry
+	self.count++
+	for _, sock := range entry.sockets() {
+		self.bySocket[sock.ID()] = entry
+	}
+	self.index(entry)
+}
+
+/**
+ * Get the session associated with a socket.
+ *
+ * @param sockId string A socket ID.
+ * @return map A copy of the session or nil.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) GetSession(sockId string) map[string]interface{} {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	if entry, ok := self.bySocket[sockId]; ok {
+		return self.copy(entry)
+	}
+	return nil
+}
+
+/**
+ * Get the session associated with an instance.
+ *
+ * @param instance_id string An instance string.
+ * @return map A copy of the session or nil.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) GetSessionByInstance(instance_id string) map[string]interface{} {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	if entry, ok := self.byInstance[instance_id]; ok && (instance_id != "") {
+		return self.copy(entry)
+	}
+	return nil
+}
+
+/**
+ * Get the sessions associated with a user.  The
+ * special &quot;all&quot; username refers to all
+ * sessions and a name like #channel refers to the
+ * sessions subscribed to that channel.
+ *
+ * @param username string The username.
+ * @return array Copies of the sessions.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) GetSessionsByUsername(username string) []map[string]interface{} {
+	if username == "all" {
+		return self.All()
+	}
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	entries := self.byUsername[username]
+	if strings.HasPrefix(username, "#") {
+		entries = self.byChannel[username[1:]]
+	}
+	var sessions []map[string]interface{}
+	for _, entry := range entries {
+		sessions = append(sessions, self.copy(entry))
+	}
+	return sessions
+}
+
+/**
+ * Change the session_data associated with a socket.
+ *
+ * If the session_data has a different instance_id
+ * that already has a session, the socket moves to
+ * that session.
+ *
+ * @param sock socketio.Conn A socket.
+ * @param sessionData map The session values.
+ * @return boolean False if the socket has no session.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) SetSessionData(sock *SocketWrapper, sessionData map[string]interface{}) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	entry1, ok := self.bySocket[sock.ID()]
+	if !ok {
+		return false
+	}
+	instance1, ok1 := entry1.data()["instance_id"].(string)
+	instance2, ok2 := sessionData["instance_id"].(string)
+	if (!ok1 && !ok2) || (ok1 && ok2 && (instance1 == instance2)) {
+		self.setData(entry1, sessionData)
+	} else if ok1 && !ok2 {
+		sessionData["instance_id"] = instance1
+		self.setData(entry1, sessionData)
+	} else if entry2, ok := self.byInstance[instance2]; !ok {
+		self.setData(entry1, sessionData)
+	} else {
+		self.detach(entry1, sock)
+		if len(entry1.sockets()) == 0 {
+			self.remove(entry1)
+		}
+		self.setData(entry2, sessionData)
+		self.attach(entry2, sock)
+	}
+	return true
+}
+
+/**
+ * Remove the socket from the session or the whole
+ * session if it is the only socket.
+ *
+ * @param sock socketio.Conn A socket.
+ * @return boolean False if the socket has no session.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) RemoveSocketFromSession(sock *SocketWrapper) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	entry, ok := self.bySocket[sock.ID()]
+	if !ok {
+		return false
+	}
+	// instances and their session_data should hang
+	/// around even if there are no sockets because
+	//  instances can be retrieved later and assigned
+	//  to a new socket (e.g. page reload)
+	if (len(entry.sockets()) > 1) || (entry.instance != "") {
+		self.detach(entry, sock)
+	} else {
+		self.remove(entry)
+	}
+	return true
+}
+
+/**
+ * Delete the session that contains a socket and
+ * add the socket to the session which represents
+ * an instance.
+ *
+ * @param instance_id string The instance to add the socket to.
+ * @param sock socketio.Conn The socket to be moved.
+ * @return boolean False if the instance has no session.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) CombineSessions(instance_id string, sock *SocketWrapper) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if entry, ok := self.bySocket[sock.ID()]; ok {
+		self.detach(entry, sock)
+		if len(entry.sockets()) == 0 {
+			self.remove(entry)
+		}
+	}
+	entry, ok := self.byInstance[instance_id]
+	if ok {
+		self.attach(entry, sock)
+	}
+	return ok
+}
+
+/**
+ * Return the saved session_data for an instance.
+ * Memory does not survive a restart so there is
+ * never any saved session_data.
+ *
+ * @param instance_id string An instance string.
+ * @return map Always nil.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) LoadSessionData(instance_id string) map[string]interface{} {
+	return nil
+}
+
+/**
+ * Remove sessions that have no sockets and have
+ * not been touched recently.
+ *
+ * @param secs int A number of seconds.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) Expire(secs int) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	expiration := time.Now().Add(-time.Second * time.Duration(secs))
+	for entry := self.last; entry != nil; {
+		prev := entry.prev
+		if (len(entry.sockets()) == 0) && entry.touched.Before(expiration) {
+			self.remove(entry)
+		}
+		entry = prev
+	}
+}
+
+/**
+ * Return copies of all the sessions in the order
+ * they were added.
+ *
+ * @return array The sessions.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) All() []map[string]interface{} {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	sessions := make([]map[string]interface{}, 0, self.count)
+	for entry := self.first; entry != nil; entry = entry.next {
+		sessions = append(sessions, self.copy(entry))
+	}
+	return sessions
+}
+
+/**
+ * Return the number of sessions.
+ *
+ * @return int The number of sessions.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) Len() int {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	return self.count
+}
+
+/**
+ * Return a shallow copy of a session.  The nested
+ * maps and arrays are never modified in place.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) copy(entry *memorySession) map[string]interface{} {
+	session := make(map[string]interface{}, len(entry.session))
+	for k, v := range entry.session {
+		session[k] = v
+	}
+	return session
+}
+
+/**
+ * Replace the session_data and update the indexes.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) setData(entry *memorySession, sessionData map[string]interface{}) {
+	self.unindex(entry)
+	entry.session["session_data"] = sessionData
+	entry.touched = time.Now()
+	self.index(entry)
+}
+
+/**
+ * Add a session to the instance, username and
+ * channel indexes.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) index(entry *memorySession) {
+	entry.instance, _ = entry.data()["instance_id"].(string)
+	entry.username, _ = entry.data()["_username"].(string)
+	entry.channels = toStrings(entry.data()["_channels"])
+	if entry.instance != "" {
+		self.byInstance[entry.instance] = entry
+	}
+	if entry.username != "" {
+		self.byUsername[entry.username] = append(self.byUsername[entry.username], entry)
+	}
+	for _, channel := range entry.channels {
+		self.byChannel[channel] = append(self.byChannel[channel], entry)
+	}
+}
+
+/**
+ * Remove a session from the instance, username and
+ * channel indexes.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) unindex(entry *memorySession) {
+	if self.byInstance[entry.instance] == entry {
+		delete(self.byInstance, entry.instance)
+	}
+	unindexEntry(self.byUsername, entry.username, entry)
+	for _, channel := range entry.channels {
+		unindexEntry(self.byChannel, channel, entry)
+	}
+}
+
+/**
+ * Remove a session from one key of an index.
+ *
+ * @author DanielWHoward
+ **/
+func unindexEntry(index map[string][]*memorySession, key string, entry *memorySession) {
+	if entries, ok := index[key]; ok {
+		kept := make([]*memorySession, 0, len(entries))
+		for _, e := range entries {
+			if e != entry {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == 0 {
+			delete(index, key)
+		} else {
+			index[key] = kept
+		}
+	}
+}
+
+/**
+ * Add a socket to a session.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) attach(entry *memorySession, sock *SocketWrapper) {
+	old := entry.sockets()
+	socks := make([]*SocketWrapper, 0, len(old)+1)
+	socks = append(socks, old...)
+	socks = append(socks, sock)
+	self.setSockets(entry, socks)
+	entry.touched = time.Now()
+	self.bySocket[sock.ID()] = entry
+}
+
+/**
+ * Remove a socket from a session.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) detach(entry *memorySession, sock *SocketWrapper) {
+	old := entry.sockets()
+	socks := make([]*SocketWrapper, 0, len(old))
+	for _, skt := range old {
+		if !sock.equals(skt) {
+			socks = append(socks, skt)
+		}
+	}
+	self.setSockets(entry, socks)
+	entry.touched = time.Now()
+	if self.bySocket[sock.ID()] == entry {
+		delete(self.bySocket, sock.ID())
+	}
+}
+
+/**
+ * Replace the _conn map with a new sockets array.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) setSockets(entry *memorySession, socks []*SocketWrapper) {
+	_conn := map[string]interface{}{}
+	if old, ok := entry.session["_conn"].(map[string]interface{}); ok {
+		for k, v := range old {
+			_conn[k] = v
+		}
+	}
+	_conn["sockets"] = socks
+	entry.session["_conn"] = _conn
+}
+
+/**
+ * Remove a whole session and its sockets.
+ *
+ * @author DanielWHoward
+ **/
+func (self *MemorySessionStore) remove(entry *memorySession) {
+	self.unindex(entry)
+	for _, sock := range entry.sockets() {
+		if self.bySocket[sock.ID()] == entry {
+			delete(self.bySocket, sock.ID())
+		}
+	}
+	// unlink it so the other sessions keep their order
+	if entry.prev == nil {
+		self.first = entry.next
+	} else {
+		entry.prev.next = entry.next
+	}
+	if entry.next == nil {
+		self.last = entry.prev
+	} else {
+		entry.next.prev = entry.prev
+	}
+	entry.prev = nil
+	entry.next = nil
+	self.count--
+}
@@ -0,0 +1,173 @@
+// The MIT License (MIT)
+//
+// xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// @version 2.0.0
+// @copyright xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+// @license http://opensource.org/licenses/MIT
+package xibbit
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+/**
+ * A session store that keeps the session_data for
+ * each instance in the sockets_sessions table so
+ * instances survive a server restart.  Sockets are
+ * live connections so they are kept in memory.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type SqlSessionStore struct {
+	*MemorySessionStore
+	link   *sql.DB
+	prefix string
+}
+
+/**
+ * Constructor.  The config has the same link and
+ * SQL_PREFIX keys as the hub's mysql config.
+ *
+ * @author DanielWHoward
+ **/
+func NewSqlSessionStore(config map[string]interface{}) *SqlSessionStore {
+	self := new(SqlSessionStore)
+	self.MemorySessionStore = NewMemorySessionStore()
+	self.link, _ = config["link"].(*sql.DB)
+	self.prefix, _ = config["SQL_PREFIX"].(string)
+	return self
+}
+
+/**
+ * Change the session_data associated with a socket
+ * and save it for the instance.
+ *
+ * @param sock socketio.Conn A socket.
+ * @param sessionData map The session values.
+ * @return boolean False if the socket has no session.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlSessionStore) SetSessionData(sock *SocketWrapper, sessionData map[string]interface{}) bool {
+	if !self.MemorySessionStore.SetSessionData(sock, sessionData) {
+		return false
+	}
+	if instance, _ := sessionData["instance_id"].(string); instance != "" {
+		self.save(instance, sessionData)
+	}
+	return true
+}
+
+/**
+ * Return the saved session_data for an instance.
+ *
+ * @param instance_id string An instance string.
+ * @return map The session_data or nil.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlSessionStore) LoadSessionData(instance_id string) map[string]interface{} {
+	if (instance_id == "") || (instance_id == "global") || (instance_id == "lock") {
+		return nil
+	}
+	q := "SELECT `vars` FROM `" + self.prefix + "sockets_sessions` WHERE `socksessid` = ?;"
+	var vars sql.NullString
+	e := self.link.QueryRow(q, instance_id).Scan(&vars)
+	if e != nil {
+		if e != sql.ErrNoRows {
+			log.Println(e)
+		}
+		return nil
+	}
+	sessionData := map[string]interface{}{}
+	if e = json.Unmarshal([]byte(vars.String), &sessionData); e != nil {
+		log.Println(e)
+		return nil
+	}
+	return sessionData
+}
+
+/**
+ * Delete saved instances and remove sessions without
+ * sockets that have not been touched recently.
+ *
+ * @param secs int A number of seconds.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlSessionStore) Expire(secs int) {
+	self.MemorySessionStore.Expire(secs)
+	expiration := time.Now().Add(-time.Second * time.Duration(secs)).Format("2006-01-02 15:04:05")
+	q := "DELETE FROM `" + self.prefix + "sockets_sessions` "
+	q += "WHERE (`touched` < ? AND `socksessid` <> 'global' AND `socksessid` <> 'lock');"
+	if _, e := self.link.Exec(q, expiration); e != nil {
+		log.Println(e)
+	}
+}
+
+/**
+ * Save the session_data of every session that has
+ * an instance so the instances can be recreated
+ * by another process.
+ *
+ * @return error The last error while saving.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlSessionStore) Persist() error {
+	var e error = nil
+	for _, session := range self.All() {
+		sessionData, _ := session["session_data"].(map[string]interface{})
+		if instance, _ := sessionData["instance_id"].(string); instance != "" {
+			if err := self.save(instance, sessionData); err != nil {
+				e = err
+			}
+		}
+	}
+	return e
+}
+
+/**
+ * Write the session_data for an instance and
+ * update its touched time.
+ *
+ * @author DanielWHoward
+ **/
+func (self *SqlSessionStore) save(instance_id string, sessionData map[string]interface{}) error {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	b, e := json.Marshal(sessionData)
+	if e != nil {
+		log.Println(e)
+		return e
+	}
+	q := "INSERT INTO `" + self.prefix + "sockets_sessions` "
+	q += "(`socksessid`, `connected`, `touched`, `vars`) VALUES (?, ?, ?, ?) "
+	q += "ON DUPLICATE KEY UPDATE `touched` = VALUES(`touched`), `vars` = VALUES(`vars`);"
+	if _, e = self.link.Exec(q, instance_id, now, now, string(b)); e != nil {
+		log.Println(e)
+	}
+	return e
+}
@@ -0,0 +1,306 @@
+// The MIT License (MIT)
+//
+// xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// @version 2.0.0
+// @copyright xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+// @license http://opensource.org/licenses/MIT
+package xibbit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+
+	socketio "github.com/googollee/go-socket.io"
+)
+
+/**
+ * The session_data of an event with accessors that
+ * do not panic when a value has the wrong type, such
+ * as a float64 that has been through JSON.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type Session map[string]interface{}
+
+/**
+ * Return a string value or the empty string.
+ *
+ * @author DanielWHoward
+ **/
+func (self Session) String(key string) string {
+	switch v := self[key].(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	}
+	b, _ := json.Marshal(self[key])
+	return string(b)
+}
+
+/**
+ * Return an integer value or 0.
+ *
+ * @author DanielWHoward
+ **/
+func (self Session) Int(key string) int {
+	if f, ok := toFloat(self[key]); ok {
+		return int(math.Round(f))
+	}
+	switch v := self[key].(type) {
+	case json.Number:
+		i, _ := v.Int64()
+		return int(i)
+	case string:
+		i, _ := strconv.Atoi(v)
+		return i
+	}
+	return 0
+}
+
+/**
+ * Return a boolean value or false.
+ *
+ * @author DanielWHoward
+ **/
+func (self Session) Bool(key string) bool {
+	b, _ := self[key].(bool)
+	return b
+}
+
+/**
+ * Return the username of the connected user or
+ * the empty string.
+ *
+ * @author DanielWHoward
+ **/
+func (self Session) Username() string {
+	return self.String("_username")
+}
+
+/**
+ * Return the instance or the empty string.
+ *
+ * @author DanielWHoward
+ **/
+func (self Session) InstanceId() string {
+	return self.String("instance_id")
+}
+
+/**
+ * Set a value that is saved when the handler returns.
+ *
+ * @author DanielWHoward
+ **/
+func (self Session) Set(key string, value interface{}) {
+	self[key] = value
+}
+
+/**
+ * Remove a value.
+ *
+ * @author DanielWHoward
+ **/
+func (self Session) Delete(key string) {
+	delete(self, key)
+}
+
+/**
+ * The _conn property of an event.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type Conn map[string]interface{}
+
+/**
+ * Return the socket that sent the event or nil.
+ *
+ * @author DanielWHoward
+ **/
+func (self Conn) Socket() socketio.Conn {
+	sock, _ := self["socket"].(socketio.Conn)
+	return sock
+}
+
+/**
+ * Return the ID of the socket that sent the event
+ * or the empty string.
+ *
+ * @author DanielWHoward
+ **/
+func (self Conn) ID() string {
+	if sock, ok := self["socket"].(interface{ ID() string }); ok {
+		return sock.ID()
+	}
+	return ""
+}
+
+/**
+ * The event, session and connection for a typed
+ * event handler.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type EventContext struct {
+	Hub     *XibbitHub
+	Type    string
+	Event   map[string]interface{}
+	Vars    map[string]interface{}
+	Session Session
+	Conn    Conn
+}
+
+/**
+ * Constructor.  The session shares its map with the
+ * _session property of the event so changes are
+ * saved.
+ *
+ * @author DanielWHoward
+ **/
+func NewEventContext(hub *XibbitHub, event map[string]interface{}, vars map[string]interface{}) *EventContext {
+	self := new(EventContext)
+	self.Hub = hub
+	self.Type, _ = event["type"].(string)
+	self.Event = event
+	self.Vars = vars
+	session, ok := event["_session"].(map[string]interface{})
+	if !ok {
+		session = map[string]interface{}{}
+		event["_session"] = session
+	}
+	self.Session = Session(session)
+	_conn, _ := event["_conn"].(map[string]interface{})
+	self.Conn = Conn(_conn)
+	return self
+}
+
+/**
+ * Return the context of the event which is done when
+ * the socket is removed or the handler times out.
+ *
+ * @author DanielWHoward
+ **/
+func (self *EventContext) Context() context.Context {
+	if ctx, ok := self.Vars["ctx"].(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+/**
+ * Decode an event into a struct using its json tags.
+ * The _session and _conn properties are skipped.
+ *
+ * A property with the wrong type returns an error
+ * like "typeof:to".
+ *
+ * @param event map The event.
+ * @param v interface A pointer to a struct.
+ * @return error An error or nil.
+ *
+ * @author DanielWHoward
+ **/
+func DecodeEvent(event map[string]interface{}, v interface{}) error {
+	values := make(map[string]interface{}, len(event))
+	for key, value := range event {
+		if (key != "_session") && (key != "_conn") {
+			values[key] = value
+		}
+	}
+	b, e := json.Marshal(values)
+	if e != nil {
+		return e
+	}
+	e = json.Unmarshal(b, v)
+	var typeError *json.UnmarshalTypeError
+	if errors.As(e, &typeError) {
+		return errors.New("typeof:" + typeError.Field)
+	}
+	return e
+}
+
+/**
+ * Encode a struct using its json tags and add its
+ * properties to an event.
+ *
+ * @param v interface A struct, a pointer to a struct or nil.
+ * @param event map The event to add to.
+ * @return error An error or nil.
+ *
+ * @author DanielWHoward
+ **/
+func EncodeReply(v interface{}, event map[string]interface{}) error {
+	b, e := json.Marshal(v)
+	if e != nil {
+		return e
+	}
+	values := map[string]interface{}{}
+	if e = json.Unmarshal(b, &values); e != nil {
+		return e
+	}
+	for key, value := range values {
+		event[key] = value
+	}
+	return nil
+}
+
+/**
+ * Provide a typed callback for an event.
+ *
+ * The event is decoded into a Req struct and the
+ * Resp struct that is returned is added to the
+ * reply so the events on the wire are the same as
+ * for a map handler.  An error becomes the e
+ * property of the reply.
+ *
+ * @param hub XibbitHub The hub.
+ * @param group string The group like "api" or "on".
+ * @param typ string The event to handle.
+ * @param fn func A function that will handle the event.
+ * @param options map Optional settings for the handler.
+ *
+ * @author DanielWHoward
+ **/
+func Handle[Req any, Resp any](hub *XibbitHub, group string, typ string, fn func(req *Req, ctx *EventContext) (*Resp, error), options ...map[string]interface{}) {
+	hub.On(group, typ, func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
+		ctx := NewEventContext(hub, event, vars)
+		req := new(Req)
+		if e := DecodeEvent(event, req); e != nil {
+			event["e"] = e.Error()
+			return event
+		}
+		resp, e := fn(req, ctx)
+		if e == nil && (resp != nil) {
+			e = EncodeReply(resp, event)
+		}
+		if e != nil {
+			event["e"] = e.Error()
+		}
+		return event
+	}, options...)
+}
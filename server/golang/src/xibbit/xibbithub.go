@@ -26,6 +26,7 @@
 package xibbit
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
@@ -33,8 +34,10 @@ import (
 	"errors"
 	"fmt"
 	socketio "github.com/googollee/go-socket.io"
+	"io"
 	"log"
 	"math"
+	"net/http"
 	"regexp"
 	"runtime"
 	"strconv"
@@ -75,9 +78,19 @@ func (self *LogMeImpl) Println(msg string, lvl int) {
 	log.Println(strconv.Itoa(lvl) + ":" + msg)
 }
 
+/**
+ * Where the hub writes the events for sockets.  The
+ * hub calls Flush() after it handles an event from
+ * a client and after each poll of the instances.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
 type XibbitHubOutputStream interface {
-	write(sock *SocketWrapper, eventName string, data map[string]interface{})
-	flush()
+	// write an event to a socket
+	Write(sock *SocketWrapper, eventName string, data map[string]interface{})
+	// write any events that are being held
+	Flush()
 }
 
 /**
@@ -104,7 +117,7 @@ func NewXibbitHubOutputStreamImpl() *XibbitHubOutputStreamImpl {
  *
  * @author DanielWHoward
  **/
-func (self *XibbitHubOutputStreamImpl) write(sock *SocketWrapper, eventName string, data map[string]interface{}) {
+func (self *XibbitHubOutputStreamImpl) Write(sock *SocketWrapper, eventName string, data map[string]interface{}) {
 	sock.Emit(eventName, data)
 }
 
@@ -113,7 +126,7 @@ func (self *XibbitHubOutputStreamImpl) write(sock *SocketWrapper, eventName stri
  *
  * @author DanielWHoward
  **/
-func (self *XibbitHubOutputStreamImpl) flush() {
+func (self *XibbitHubOutputStreamImpl) Flush() {
 }
 
 /**
@@ -127,6 +140,12 @@ type SocketWrapper struct {
 	use_conn bool
 	fake_sid string
 	Fake_data string
+	collect bool
+	collected []map[string]interface{}
+	stream chan map[string]interface{}
+	overflow chan struct{}
+	overflowOnce sync.Once
+	mu sync.Mutex
 }
 func NewSocket(conn *socketio.Conn) *SocketWrapper {
 	self := new(SocketWrapper)
@@ -140,6 +159,17 @@ func NewFakeSocket(fake_sid string) *SocketWrapper {
 	self.fake_sid = fake_sid
 	return self
 }
+func NewCollectingSocket(fake_sid string) *SocketWrapper {
+	self := NewFakeSocket(fake_sid)
+	self.collect = true
+	return self
+}
+func NewStreamingSocket(fake_sid string, size int) *SocketWrapper {
+	self := NewFakeSocket(fake_sid)
+	self.stream = make(chan map[string]interface{}, size)
+	self.overflow = make(chan struct{})
+	return self
+}
 func (self *SocketWrapper) ID() (sid string) {
 	if self.use_conn {
 		sid = (*self.conn).ID()
@@ -151,11 +181,29 @@ func (self *SocketWrapper) ID() (sid string) {
 func (self *SocketWrapper) Emit(eventName string, v ...interface{}) {
 	if self.use_conn {
 		(*self.conn).Emit(eventName, v...)
+	} else if self.stream != nil {
+		for _, argMap := range emittedEvents(v) {
+			select {
+			case self.stream <- argMap:
+			default:
+				// the reader is too slow so it has to resume
+				self.overflowOnce.Do(func() {
+					close(self.overflow)
+				})
+			}
+		}
+	} else if self.collect {
+		self.mu.Lock()
+		self.collected = append(self.collected, emittedEvents(v)...)
+		self.mu.Unlock()
 	} else {
 		for _, arg := range v {
 			if argMap, ok := arg.(map[string]interface{}); ok {
 				b, _ := json.Marshal(argMap)
 				self.Fake_data += string(b)
+			} else if argArr, ok := arg.([]map[string]interface{}); ok {
+				b, _ := json.Marshal(argArr)
+				self.Fake_data += string(b)
 			} else if argStr, ok := arg.(string); ok {
 				self.Fake_data += argStr
 			}
@@ -163,6 +211,28 @@ func (self *SocketWrapper) Emit(eventName string, v ...interface{}) {
 	}
 	return
 }
+func emittedEvents(v []interface{}) []map[string]interface{} {
+	events := []map[string]interface{}{}
+	for _, arg := range v {
+		if argMap, ok := arg.(map[string]interface{}); ok {
+			events = append(events, argMap)
+		} else if argArr, ok := arg.([]map[string]interface{}); ok {
+			events = append(events, argArr...)
+		}
+	}
+	return events
+}
+func (self *SocketWrapper) Collected() []map[string]interface{} {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return append([]map[string]interface{}{}, self.collected...)
+}
+func (self *SocketWrapper) Conn() interface{} {
+	if self.use_conn {
+		return *self.conn
+	}
+	return self
+}
 func (self *SocketWrapper) equals(other *SocketWrapper) (same bool) {
 	same = true
 	if same && (self.use_conn != other.use_conn) {
@@ -177,6 +247,36 @@ func (self *SocketWrapper) equals(other *SocketWrapper) (same bool) {
 	return
 }
 
+/**
+ * A function that handles an event.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type EventHandler = func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{}
+
+/**
+ * A function that wraps an event handler.  It can
+ * read or modify the event and vars before calling
+ * next, return an event with an e property instead
+ * of calling next or change the reply from next.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type Middleware func(next EventHandler) EventHandler
+
+/**
+ * A middleware and the handler groups it applies to.
+ *
+ * @package xibbit
+ * @author DanielWHoward
+ **/
+type middlewareEntry struct {
+	fn     Middleware
+	groups []string
+}
+
 /**
  * A socket handling hub object that makes it
  * easy to set up sockets, dispatch client socket
@@ -191,11 +291,65 @@ type XibbitHub struct {
 	config         map[string]interface{}
 	suppressCloneSession bool
 	Handler_groups map[string]map[string]func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{}
+	Handler_options map[string]map[string]map[string]interface{}
+	middlewares    []middlewareEntry
+	rateLimiter    RateLimiter
 	prefix         string
-	Sessions       []map[string]interface{}
+	Sessions       SessionStore
+	Events         EventQueue
+	eventsSent     map[string]int
+	eventsMu       sync.Mutex
 	OutputStream   XibbitHubOutputStream
-	mu             sync.Mutex
-	globalVars     map[string]interface{}
+	globalVars     GlobalVarsStore
+	globalVarsSql  *SqlGlobalVars
+	node           string
+	cluster        ClusterBus
+	clusterMu      sync.Mutex
+	clusterSessions map[string]map[string]map[string]interface{}
+	clusterStreaming map[string]map[string]bool
+	clusterPublished map[string]string
+	requests       map[string]*pendingRequest
+	clusterRequests map[string]*pendingRequest
+	requestsMu     sync.Mutex
+	pollInterval   time.Duration
+	maxPollInterval time.Duration
+	polls          map[string]*pollState
+	pollsMu        sync.Mutex
+	wake           chan string
+	presence       map[string]*presenceInstance
+	clusterPresence map[string]map[string]*presenceInstance
+	presenceOnline map[string]bool
+	presenceGrace  time.Duration
+	presenceEvents bool
+	presenceMu     sync.Mutex
+	stopping       bool
+	stopMu         sync.RWMutex
+	inflight       sync.WaitGroup
+	stop           chan struct{}
+	stopped        chan struct{}
+	handlerTimeout time.Duration
+	socketContexts map[string]*cancelContext
+	socketCtxMu    sync.Mutex
+}
+
+/**
+ * A context and the function that cancels it.
+ *
+ * @author DanielWHoward
+ **/
+type cancelContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+/**
+ * When to call Receive() next for an instance.
+ *
+ * @author DanielWHoward
+ **/
+type pollState struct {
+	interval time.Duration
+	next     time.Time
 }
 
 /**
@@ -216,12 +370,19 @@ func NewXibbitHub(config map[string]interface{}) *XibbitHub {
 	self.Handler_groups["api"] = map[string]func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{}{}
 	self.Handler_groups["on"] = map[string]func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{}{}
 	self.Handler_groups["int"] = map[string]func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{}{}
+	self.Handler_options = map[string]map[string]map[string]interface{}{
+		"api": map[string]map[string]interface{}{},
+		"on":  map[string]map[string]interface{}{},
+		"int": map[string]map[string]interface{}{},
+	}
 	if _, ok := config["socketio"].(*socketio.Server); ok {
 		self.socketio = config["socketio"].(*socketio.Server)
 	}
-	self.Sessions = make([]map[string]interface{}, 0)
-	self.OutputStream = NewXibbitHubOutputStreamImpl()
-	self.globalVars = map[string]interface{}{}
+	if stream, ok := config["outputStream"].(XibbitHubOutputStream); ok {
+		self.OutputStream = stream
+	} else {
+		self.OutputStream = NewXibbitHubOutputStreamImpl()
+	}
 	mysql, ok := self.config["mysql"].(map[string]interface{})
 	if ok && self.prefix == "" {
 		self.prefix, _ = mysql["SQL_PREFIX"].(string)
@@ -230,15 +391,206 @@ func NewXibbitHub(config map[string]interface{}) *XibbitHub {
 	if ok && self.prefix == "" {
 		self.prefix, _ = mysqli["SQL_PREFIX"].(string)
 	}
+	if store, ok := config["sessionStore"].(SessionStore); ok {
+		self.Sessions = store
+	} else if store, _ := config["sessionStore"].(string); (store == "sql") && (mysql != nil) {
+		self.Sessions = NewSqlSessionStore(mysql)
+	} else {
+		self.Sessions = NewMemorySessionStore()
+	}
+	// queue events until the clients ack them
+	if queue, ok := config["eventQueue"].(EventQueue); ok {
+		self.Events = queue
+	} else if queue, _ := config["eventQueue"].(string); (queue == "sql") && (mysql != nil) {
+		self.Events = NewSqlEventQueue(mysql)
+	} else {
+		self.Events = NewMemoryEventQueue()
+	}
+	self.eventsSent = map[string]int{}
+	// poll idle instances less often
+	self.pollInterval = time.Second
+	if ms, ok := toFloat(config["pollInterval"]); ok && (ms > 0) {
+		self.pollInterval = time.Duration(ms) * time.Millisecond
+	}
+	self.maxPollInterval = self.pollInterval * 8
+	if ms, ok := toFloat(config["maxPollInterval"]); ok && (ms > 0) {
+		self.maxPollInterval = time.Duration(ms) * time.Millisecond
+	}
+	if self.maxPollInterval < self.pollInterval {
+		self.maxPollInterval = self.pollInterval
+	}
+	self.polls = map[string]*pollState{}
+	// keep users online while they reconnect
+	self.presence = map[string]*presenceInstance{}
+	self.clusterPresence = map[string]map[string]*presenceInstance{}
+	self.presenceOnline = map[string]bool{}
+	self.presenceGrace = 30 * time.Second
+	if secs, ok := toFloat(config["presenceGrace"]); ok && (secs >= 0) {
+		self.presenceGrace = time.Duration(secs * float64(time.Second))
+	}
+	self.presenceEvents, _ = config["presenceEvents"].(bool)
+	self.wake = make(chan string, 100)
+	self.stop = make(chan struct{})
+	// cancel slow handlers
+	self.handlerTimeout = 0
+	if secs, ok := toFloat(config["handlerTimeout"]); ok && (secs > 0) {
+		self.handlerTimeout = time.Duration(secs * float64(time.Second))
+	}
+	self.socketContexts = map[string]*cancelContext{}
+	// share global variables between processes using the database
+	if mysql != nil {
+		self.globalVarsSql = NewSqlGlobalVars(mysql)
+	}
+	if store, ok := config["globalVars"].(GlobalVarsStore); ok {
+		self.globalVars = store
+	} else if store, _ := config["globalVars"].(string); (store == "sql") && (self.globalVarsSql != nil) {
+		self.globalVars = self.globalVarsSql
+	} else {
+		self.globalVars = NewMemoryGlobalVars()
+	}
+	// limit how often events can be sent
+	if limiter, ok := config["rateLimiter"].(RateLimiter); ok {
+		self.rateLimiter = limiter
+	} else if limiter, _ := config["rateLimiter"].(string); (limiter == "sql") && (mysql != nil) {
+		self.rateLimiter = NewSqlRateLimiter(mysql)
+	} else if (limiter == "globalVars") && (mysql != nil) && (self.globalVars == GlobalVarsStore(self.globalVarsSql)) {
+		// do not share the __clock lease with every event
+		self.rateLimiter = NewSqlRateLimiter(mysql)
+	} else if limiter == "globalVars" {
+		self.rateLimiter = NewGlobalVarsRateLimiter(self.globalVars)
+	} else {
+		self.rateLimiter = NewMemoryRateLimiter()
+	}
+	// join a cluster of hubs
+	self.node, _ = config["node"].(string)
+	if self.node == "" {
+		self.node = self.GenerateInstance()
+	}
+	self.clusterSessions = map[string]map[string]map[string]interface{}{}
+	self.clusterStreaming = map[string]map[string]bool{}
+	self.clusterPublished = map[string]string{}
+	self.requests = map[string]*pendingRequest{}
+	self.clusterRequests = map[string]*pendingRequest{}
+	if bus, ok := config["cluster"].(ClusterBus); ok {
+		self.cluster = bus
+	} else if bus, _ := config["cluster"].(string); (bus == "sql") && (mysql != nil) {
+		self.cluster = NewSqlClusterBus(mysql)
+	}
+	if self.cluster != nil {
+		if e := self.cluster.Subscribe(self.node, self.receiveClusterMessage); e != nil {
+			log.Println(e)
+		}
+		self.cluster.Publish(self.node, map[string]interface{}{"type": "hello"})
+	}
 	return self
 }
 
 /**
  * Shut down this hub instance.
  *
+ * New events are refused, the ticker is stopped and
+ * the events that are already running are allowed
+ * to finish until the context is done.  Then the
+ * output stream is flushed, the sessions are saved
+ * if the store is persistent and the SQL lock on
+ * the global variables is released.
+ *
+ * @param ctx Context The deadline for the running events.
+ * @return error The context error if the events did not finish.
+ *
  * @author DanielWHoward
  **/
-func (self *XibbitHub) StopHub() {
+func (self *XibbitHub) StopHub(ctx context.Context) error {
+	self.stopMu.Lock()
+	if self.stopping {
+		self.stopMu.Unlock()
+		return nil
+	}
+	self.stopping = true
+	self.stopMu.Unlock()
+	close(self.stop)
+	// wait for the ticker and the running events
+	drained := make(chan struct{})
+	go func() {
+		if self.stopped != nil {
+			<-self.stopped
+		}
+		self.inflight.Wait()
+		close(drained)
+	}()
+	var e error = nil
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		e = ctx.Err()
+		// ask the running handlers to give up
+		self.socketCtxMu.Lock()
+		for _, socketCtx := range self.socketContexts {
+			socketCtx.cancel()
+		}
+		self.socketCtxMu.Unlock()
+	}
+	self.OutputStream.Flush()
+	if store, ok := self.Sessions.(PersistentSessionStore); ok {
+		if err := store.Persist(); err != nil {
+			log.Println(err)
+		}
+	}
+	// the globalVars store can be the globalVarsSql store
+	if vars, ok := self.globalVars.(*SqlGlobalVars); ok && (vars != self.globalVarsSql) {
+		if err := vars.Release(); err != nil {
+			log.Println(err)
+		}
+	}
+	if self.globalVarsSql != nil {
+		if err := self.globalVarsSql.Release(); err != nil {
+			log.Println(err)
+		}
+	}
+	if self.cluster != nil {
+		self.cluster.Publish(self.node, map[string]interface{}{"type": "bye"})
+		self.cluster.Unsubscribe(self.node)
+	}
+	return e
+}
+
+/**
+ * Start running an event unless the hub is stopping.
+ * Every true return must be matched by a call to
+ * endEvent().
+ *
+ * @return boolean False if the hub is stopping.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) beginEvent() bool {
+	self.stopMu.RLock()
+	defer self.stopMu.RUnlock()
+	if self.stopping {
+		return false
+	}
+	self.inflight.Add(1)
+	return true
+}
+
+/**
+ * Finish running an event.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) endEvent() {
+	self.inflight.Done()
+}
+
+/**
+ * Return true if StopHub() has been called.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) isStopping() bool {
+	self.stopMu.RLock()
+	defer self.stopMu.RUnlock()
+	return self.stopping
 }
 
 /**
@@ -278,16 +630,15 @@ func (self *XibbitHub) GetSocketIO() *socketio.Server {
  **/
 func (self *XibbitHub) GetSession(sockId string) map[string]interface{} {
 	var session map[string]interface{} = nil
-	i := self.GetSessionIndex(sockId)
-	if i != -1 {
-		session = self.CloneSession(self.Sessions[i])
+	if s := self.Sessions.GetSession(sockId); s != nil {
+		session = self.CloneSession(s)
 	}
 	return session
 }
 
 /**
- * This is an implementation helper.  It assumes that
- * the session store is an array.
+ * This is an implementation helper.  It returns the
+ * index of the session in a snapshot of the store.
  *
  * @param sock string A socket ID.
  * @returns int The index into a session array.
@@ -295,10 +646,13 @@ func (self *XibbitHub) GetSession(sockId string) map[string]interface{} {
  * @author DanielWHoward
  **/
 func (self *XibbitHub) GetSessionIndex(sockId string) int {
-	for s, _ := range self.Sessions {
-		for ss, _ := range self.Sessions[s]["_conn"].(map[string]interface{})["sockets"].([]*SocketWrapper) {
-			if self.Sessions[s]["_conn"].(map[string]interface{})["sockets"].([]*SocketWrapper)[ss].ID() == sockId {
-				return s
+	for s, session := range self.Sessions.All() {
+		if _conn, ok := session["_conn"].(map[string]interface{}); ok {
+			sockets, _ := _conn["sockets"].([]*SocketWrapper)
+			for _, sock := range sockets {
+				if sock.ID() == sockId {
+					return s
+				}
 			}
 		}
 	}
@@ -314,10 +668,8 @@ func (self *XibbitHub) GetSessionIndex(sockId string) int {
  **/
 func (self *XibbitHub) GetSessionByInstance(instance_id string) map[string]interface{} {
 	if instance_id != "" {
-		for _, session := range self.Sessions {
-			if session["session_data"].(map[string]interface{})["instance_id"].(string) == instance_id {
-				return self.CloneSession(session)
-			}
+		if session := self.Sessions.GetSessionByInstance(instance_id); session != nil {
+			return self.CloneSession(session)
 		}
 	}
 	return nil
@@ -335,12 +687,20 @@ func (self *XibbitHub) GetSessionByInstance(instance_id string) map[string]inter
  * @author DanielWHoward
  **/
 func (self *XibbitHub) GetSessionsByUsername(username string) (sessions []map[string]interface{}) {
-	if username == "all" {
-		sessions = self.Sessions
-	} else {
-		for _, session := range self.Sessions {
-			if _username, _ := session["session_data"].(map[string]interface{})["_username"].(string); _username == username {
-				sessions = append(sessions, session)
+	sessions = self.Sessions.GetSessionsByUsername(username)
+	// sessions on other nodes have no local sockets
+	self.clusterMu.Lock()
+	defer self.clusterMu.Unlock()
+	for node, instances := range self.clusterSessions {
+		for _, sessionData := range instances {
+			if isAddressed(sessionData, username) {
+				sessions = append(sessions, map[string]interface{}{
+					"session_data": sessionData,
+					"_conn": map[string]interface{}{
+						"node":    node,
+						"sockets": []*SocketWrapper{},
+					},
+				})
 			}
 		}
 	}
@@ -356,50 +716,14 @@ func (self *XibbitHub) GetSessionsByUsername(username string) (sessions []map[st
  * @author DanielWHoward
  **/
 func (self *XibbitHub) SetSessionData(sock *SocketWrapper, sessionData map[string]interface{}) {
-	i1 := self.GetSessionIndex(sock.ID())
-	if i1 == -1 {
+	instance := self.socketInstance(sock)
+	clone := self.CloneSession(sessionData)
+	if !self.Sessions.SetSessionData(sock, clone) {
 		log.Println("XibbitHub.SetSessionData() could not find the session")
-		//		self.AddSession(sock, session)
-	} else {
-		instance1, ok1 := self.Sessions[i1]["session_data"].(map[string]interface{})["instance_id"].(string)
-		instance2, ok2 := sessionData["instance_id"].(string)
-		if (!ok1 && !ok2) || (ok1 && ok2 && (instance1 == instance2)) {
-			clone := self.CloneSession(sessionData)
-			self.Sessions[i1]["session_data"] = clone
-		} else if ok1 && !ok2 {
-			clone := self.CloneSession(sessionData)
-			self.Sessions[i1]["session_data"] = clone
-			self.Sessions[i1]["session_data"].(map[string]interface{})["instance_id"] = instance1
-		} else {
-			i2 := -1
-			for i2i, sess := range self.Sessions {
-				if instance2 == sess["session_data"].(map[string]interface{})["instance_id"] {
-					i2 = i2i
-				}
-			}
-			if i2 == -1 {
-				clone := self.CloneSession(sessionData)
-				self.Sessions[i1]["session_data"] = clone
-			} else {
-				socks := self.Sessions[i1]["_conn"].(map[string]interface{})["sockets"].([]*SocketWrapper)
-				if len(socks) == 1 {
-					last := len(self.Sessions) - 1
-					if i1 != last {
-						self.Sessions[i1], self.Sessions[last] = self.Sessions[last], self.Sessions[i1]
-					}
-					self.Sessions = self.Sessions[:last]
-					if i2 > i1 {
-						i2--
-					}
-				}
-				clone := self.CloneSession(sessionData)
-				self.Sessions[i2]["session_data"] = clone
-				socks = self.Sessions[i2]["_conn"].(map[string]interface{})["sockets"].([]*SocketWrapper)
-				socks = append(socks, sock)
-				self.Sessions[i2]["_conn"].(map[string]interface{})["sockets"] = socks
-			}
-		}
 	}
+	self.PublishSession(instance)
+	self.PublishSession(self.socketInstance(sock))
+	self.updatePresence(true, instance, self.socketInstance(sock))
 }
 
 /**
@@ -410,17 +734,7 @@ func (self *XibbitHub) SetSessionData(sock *SocketWrapper, sessionData map[strin
  * @author DanielWHoward
  **/
 func (self *XibbitHub) AddSession(sock *SocketWrapper) {
-	session := map[string]interface{}{
-		"session_data": map[string]interface{}{
-			"instance_id": "",
-		},
-		"_conn": map[string]interface{}{
-			"sockets": []*SocketWrapper{
-				sock,
-			},
-		},
-	}
-	self.Sessions = append(self.Sessions, session)
+	self.Sessions.AddSession(sock)
 }
 
 /**
@@ -432,41 +746,39 @@ func (self *XibbitHub) AddSession(sock *SocketWrapper) {
  * @author DanielWHoward
  **/
 func (self *XibbitHub) RemoveSocketFromSession(sock *SocketWrapper) {
-	s := -1
-	ss := -1
-	found := false
-	// find the session index and socket index
-	for si, session := range self.Sessions {
-		socks := session["_conn"].(map[string]interface{})["sockets"].([]*SocketWrapper)
-		instance, _ := session["session_data"].(map[string]interface{})["instance_id"].(string)
-		for ssi, skt := range socks {
-			if (*sock).equals(skt) {
-				s = si
-				// instances and their session_data should hang
-				/// around even if there are no sockets because
-				//  instances can be retrieved later and assigned
-				//  to a new socket (e.g. page reload)
-				if (len(socks) > 1) || (instance != "") {
-					ss = ssi
-				}
-				found = true
-				break
-			}
-		}
-		if found {
-			break
-		}
-	}
-	// remove the whole session or just the socket
-	if found && (ss == -1) {
-		self.Sessions = append(self.Sessions[0:s], self.Sessions[s+1:]...)
-	} else if found {
-		socks := self.Sessions[s]["_conn"].(map[string]interface{})["sockets"].([]*SocketWrapper)
-		socks = append(socks[0:ss], socks[ss+1:]...)
-		self.Sessions[s]["_conn"].(map[string]interface{})["sockets"] = socks
-	} else {
+	instance := self.socketInstance(sock)
+	if !self.Sessions.RemoveSocketFromSession(sock) {
 		log.Println("XibbitHub.RemoveSocketFromSession() could not find the session")
 	}
+	self.PublishSession(instance)
+	self.updatePresence(false, instance)
+	// cancel the events that are running for the socket
+	self.socketCtxMu.Lock()
+	if socketCtx, ok := self.socketContexts[sock.ID()]; ok {
+		socketCtx.cancel()
+		delete(self.socketContexts, sock.ID())
+	}
+	self.socketCtxMu.Unlock()
+}
+
+/**
+ * Return a context for a socket that is cancelled
+ * when the socket is removed.
+ *
+ * @param sockId string The socket ID.
+ * @return Context The context for the socket.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) socketContext(sockId string) context.Context {
+	self.socketCtxMu.Lock()
+	defer self.socketCtxMu.Unlock()
+	if socketCtx, ok := self.socketContexts[sockId]; ok {
+		return socketCtx.ctx
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	self.socketContexts[sockId] = &cancelContext{ctx, cancel}
+	return ctx
 }
 
 /**
@@ -486,16 +798,192 @@ func (self *XibbitHub) RemoveSocketFromSession(sock *SocketWrapper) {
  * @author DanielWHoward
  **/
 func (self *XibbitHub) CombineSessions(instance_id string, sock *SocketWrapper) {
-	i := self.GetSessionIndex(sock.ID())
-	self.Sessions = append(self.Sessions[0:i], self.Sessions[i+1:]...)
-	for s, _ := range self.Sessions {
-		if self.Sessions[s]["session_data"].(map[string]interface{})["instance_id"].(string) == instance_id {
-			socks := self.Sessions[s]["_conn"].(map[string]interface{})["sockets"].([]*SocketWrapper)
-			socks = append(socks, sock)
-			self.Sessions[s]["_conn"].(map[string]interface{})["sockets"] = socks
-			break
+	self.Sessions.CombineSessions(instance_id, sock)
+	self.PublishSession(instance_id)
+	self.updatePresence(true, instance_id)
+}
+
+/**
+ * Return the instance of the session that has
+ * a socket or the empty string.
+ *
+ * @param sock socketio.Conn A socket.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) socketInstance(sock *SocketWrapper) string {
+	instance := ""
+	if session := self.Sessions.GetSession(sock.ID()); session != nil {
+		if sessionData, ok := session["session_data"].(map[string]interface{}); ok {
+			instance, _ = sessionData["instance_id"].(string)
 		}
 	}
+	return instance
+}
+
+/**
+ * Tell the other nodes in the cluster about the
+ * current session_data for an instance, or that
+ * the instance is gone.  Nothing is sent if it
+ * has not changed.
+ *
+ * @param instance_id string The instance.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) PublishSession(instance_id string) {
+	if (self.cluster == nil) || (instance_id == "") {
+		return
+	}
+	msg := map[string]interface{}{
+		"type":        "session",
+		"instance_id": instance_id,
+	}
+	if session := self.Sessions.GetSessionByInstance(instance_id); session != nil {
+		msg["session_data"] = session["session_data"]
+		if isStreamingSession(session) {
+			msg["streaming"] = true
+		}
+		if _conn, ok := session["_conn"].(map[string]interface{}); ok {
+			if socks, _ := _conn["sockets"].([]*SocketWrapper); len(socks) > 0 {
+				msg["connected"] = true
+			}
+		}
+	}
+	b, _ := json.Marshal(msg)
+	self.clusterMu.Lock()
+	published, ok := self.clusterPublished[instance_id]
+	if ok && (published == string(b)) {
+		self.clusterMu.Unlock()
+		return
+	}
+	if msg["session_data"] == nil {
+		delete(self.clusterPublished, instance_id)
+	} else {
+		self.clusterPublished[instance_id] = string(b)
+	}
+	self.clusterMu.Unlock()
+	if e := self.cluster.Publish(self.node, msg); e != nil {
+		log.Println(e)
+	}
+}
+
+/**
+ * Handle a message from another node in the cluster.
+ *
+ * @param from string The node that sent the message.
+ * @param msg map The message.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) receiveClusterMessage(from string, msg map[string]interface{}) {
+	switch msg["type"] {
+	case "send":
+		event, _ := msg["event"].(map[string]interface{})
+		address, _ := msg["to"].(string)
+		if event != nil {
+			self.emit(event, address)
+		}
+	case "wake":
+		if instance, ok := msg["instance_id"].(string); ok && (self.Sessions.GetSessionByInstance(instance) != nil) {
+			self.Wake(instance)
+		}
+	case "request":
+		rid, _ := msg["rid"].(string)
+		address, _ := msg["to"].(string)
+		self.requestsMu.Lock()
+		self.clusterRequests[rid] = &pendingRequest{address: address, node: from}
+		self.requestsMu.Unlock()
+	case "request_done":
+		rid, _ := msg["rid"].(string)
+		self.requestsMu.Lock()
+		delete(self.clusterRequests, rid)
+		self.requestsMu.Unlock()
+	case "reply":
+		event, _ := msg["event"].(map[string]interface{})
+		sessionData, _ := msg["session_data"].(map[string]interface{})
+		if node, _ := msg["node"].(string); (event != nil) && (node == self.node) {
+			self.reply(event, sessionData)
+		}
+	case "session":
+		instance, _ := msg["instance_id"].(string)
+		sessionData, _ := msg["session_data"].(map[string]interface{})
+		streaming, _ := msg["streaming"].(bool)
+		connected, _ := msg["connected"].(bool)
+		self.clusterMu.Lock()
+		if _, ok := self.clusterSessions[from]; !ok {
+			self.clusterSessions[from] = map[string]map[string]interface{}{}
+			self.clusterStreaming[from] = map[string]bool{}
+		}
+		if sessionData == nil {
+			delete(self.clusterSessions[from], instance)
+		} else {
+			self.clusterSessions[from][instance] = self.CloneSession(sessionData)
+		}
+		if streaming {
+			self.clusterStreaming[from][instance] = true
+		} else {
+			delete(self.clusterStreaming[from], instance)
+		}
+		self.clusterMu.Unlock()
+		self.updateClusterPresence(from, instance, sessionData, connected)
+	case "hello":
+		// a new node needs to know all the sessions
+		self.clusterMu.Lock()
+		self.clusterPublished = map[string]string{}
+		self.clusterMu.Unlock()
+		for _, session := range self.Sessions.All() {
+			if sessionData, ok := session["session_data"].(map[string]interface{}); ok {
+				instance, _ := sessionData["instance_id"].(string)
+				self.PublishSession(instance)
+			}
+		}
+	case "bye":
+		self.clusterMu.Lock()
+		delete(self.clusterSessions, from)
+		delete(self.clusterStreaming, from)
+		self.clusterMu.Unlock()
+		self.updateClusterPresence(from, "", nil, false)
+		self.requestsMu.Lock()
+		for rid, request := range self.clusterRequests {
+			if request.node == from {
+				delete(self.clusterRequests, rid)
+			}
+		}
+		self.requestsMu.Unlock()
+	}
+}
+
+/**
+ * Remove sessions that have no sockets and have not
+ * been touched recently.  A persistent session store
+ * also deletes its saved instances.
+ *
+ * @param secs int A number of seconds.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) ExpireSessions(secs int) {
+	self.Sessions.Expire(secs)
+	// tell the cluster about the expired sessions
+	self.clusterMu.Lock()
+	instances := []string{}
+	for instance, _ := range self.clusterPublished {
+		instances = append(instances, instance)
+	}
+	self.clusterMu.Unlock()
+	for _, instance := range instances {
+		self.PublishSession(instance)
+	}
+	self.sweepPresence()
+	// forget when to poll the expired sessions
+	self.pollsMu.Lock()
+	for instance, _ := range self.polls {
+		if self.Sessions.GetSessionByInstance(instance) == nil {
+			delete(self.polls, instance)
+		}
+	}
+	self.pollsMu.Unlock()
 }
 
 /**
@@ -684,176 +1172,677 @@ func (self *XibbitHub) Start(method string) {
 				fmt.Println(e)
 			}
 		}()
-		allowedKeys := []string{"_id"}
-		allowedTypes := []string{"_instance"}
-		session := self.GetSession(sock.ID())
-		// process the event
-		events := []struct {eventName string; data map[string]interface{}}{}
-		handled := false
-		if !handled {
-			// see if the event has illegal keys
-			for key, _ := range event {
-				malformed := true
-				// _id is a special property so sender can invoke callbacks
-				if key[0:1] == "_" {
-					for _, r := range allowedKeys {
-						if key == r {
-							malformed = false
-						}
-					}
-				} else {
-					malformed = false
+		wrapper := NewSocket(&sock)
+		if !self.beginEvent() {
+			event["e"] = "unavailable"
+			self.OutputStream.Write(wrapper, "client", event)
+			self.OutputStream.Flush()
+			return
+		}
+		defer self.endEvent()
+		self.OutputStream.Write(wrapper, "client", self.readEvent(wrapper, event))
+		self.OutputStream.Flush()
+	})
+	// socket disconnected
+	self.socketio.OnDisconnect("/", func(sock socketio.Conn, reason string) {
+		self.RemoveSocketFromSession(NewSocket(&sock))
+		self.CheckClock()
+	})
+
+	ticker := time.NewTicker(self.pollInterval)
+	self.stopped = make(chan struct{})
+	go func() {
+		defer close(self.stopped)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-self.stop:
+				return
+			case <-ticker.C:
+				self.CheckClock()
+				now := time.Now()
+				for _, session := range self.Sessions.All() {
+					self.poll(session, now, false)
 				}
-				if malformed {
-					event["e"] = "malformed--property"
-					events = append(events, struct {eventName string; data map[string]interface{}}{"client", event})
-					handled = true
-					break
+				self.sweepPresence()
+				self.OutputStream.Flush()
+			case instance := <-self.wake:
+				if session := self.Sessions.GetSessionByInstance(instance); session != nil {
+					self.poll(session, time.Now(), true)
 				}
+				self.OutputStream.Flush()
 			}
 		}
-		if !handled {
-			// see if there is no event type
-			if _, ok := event["type"]; !ok {
-				event["e"] = "malformed--type"
-				events = append(events, struct {eventName string; data map[string]interface{}}{"client", event})
-				handled = true
+	}()
+}
+
+/**
+ * Return an HTTP handler that streams the events for
+ * an instance as Server-Sent Events.  The instance is
+ * the instance query value.
+ *
+ * Queued events have their _seq as the event ID so a
+ * client that reconnects with a Last-Event-ID header
+ * acks the events it has and gets the rest again.
+ * Event handlers use IsStreaming() to queue the
+ * events for these instances even when they do not
+ * otherwise ack events.
+ *
+ * @return Handler An HTTP handler.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) GetSseHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		if self.isStopping() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		instance := r.URL.Query().Get("instance")
+		if self.GetSessionByInstance(instance) == nil {
+			http.Error(w, "unknown:instance", http.StatusNotFound)
+			return
+		}
+		// listen to the instance like another socket
+		sock := NewStreamingSocket("sse_"+self.GenerateInstance(), 256)
+		self.AddSession(sock)
+		self.CombineSessions(instance, sock)
+		if self.GetSession(sock.ID()) == nil {
+			http.Error(w, "unknown:instance", http.StatusNotFound)
+			return
+		}
+		defer self.RemoveSocketFromSession(sock)
+		// the client has the events up to Last-Event-ID
+		if seq, e := strconv.Atoi(r.Header.Get("Last-Event-ID")); (e == nil) && (seq > 0) {
+			if e = self.AckEvents(instance, seq); e != nil {
+				log.Println(e)
 			}
 		}
-		if !handled {
-			// see if event type has illegal value
-			typeStr, _ := event["type"].(string)
-			typeValidated, _ := regexp.MatchString(`^[a-z][a-z_]*$`, typeStr)
-			if !typeValidated {
-				for _, v := range allowedTypes {
-					if typeStr == v {
-						typeValidated = true
+		self.ReplayQueuedEvents(instance)
+		self.Wake(instance)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		keepAlive := time.NewTicker(15 * time.Second)
+		defer keepAlive.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-self.stop:
+				// the client reconnects to another hub
+				return
+			case <-sock.overflow:
+				return
+			case event := <-sock.stream:
+				b, e := json.Marshal(event)
+				if e != nil {
+					log.Println(e)
+					continue
+				}
+				frame := ""
+				if seq, ok := toFloat(event["_seq"]); ok {
+					frame += "id: " + strconv.Itoa(int(seq)) + "\n"
+				}
+				frame += "data: " + string(b) + "\n\n"
+				if _, e = io.WriteString(w, frame); e != nil {
+					return
+				}
+				flusher.Flush()
+			case <-keepAlive.C:
+				if _, e := io.WriteString(w, ": keep-alive\n\n"); e != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	})
+}
+
+/**
+ * Return true if an instance has a Server-Sent Events
+ * stream on this node or on another node in the
+ * cluster.  Its events should be queued with
+ * QueueEvent() so that the stream can resume.
+ *
+ * @param instance string An instance string.
+ * @return bool True if the instance is streaming.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) IsStreaming(instance string) bool {
+	if session := self.Sessions.GetSessionByInstance(instance); (session != nil) && isStreamingSession(session) {
+		return true
+	}
+	self.clusterMu.Lock()
+	defer self.clusterMu.Unlock()
+	for _, instances := range self.clusterStreaming {
+		if instances[instance] {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Return true if a session has a socket for a
+ * Server-Sent Events stream.
+ *
+ * @param session map A session.
+ * @return bool True if the session is streaming.
+ *
+ * @author DanielWHoward
+ **/
+func isStreamingSession(session map[string]interface{}) bool {
+	_conn, _ := session["_conn"].(map[string]interface{})
+	sockets, _ := _conn["sockets"].([]*SocketWrapper)
+	for _, sock := range sockets {
+		if sock.stream != nil {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Validate an event from a socket, run it and return
+ * the reply.  The socket is either a Socket.IO
+ * connection or a temporary socket for an HTTP
+ * request.
+ *
+ * @param sock SocketWrapper The socket that sent the event.
+ * @param event map The event from the client.
+ * @return map The reply event.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) readEvent(sock *SocketWrapper, event map[string]interface{}) map[string]interface{} {
+	allowedKeys := []string{"_id", "_rid"}
+	allowedTypes := []string{"_instance", "_reply", "_ack", "_subscribe", "_unsubscribe"}
+	session := self.GetSession(sock.ID())
+	// process the event
+	var reply map[string]interface{}
+	handled := false
+	if !handled {
+		// see if the event has illegal keys
+		for key, _ := range event {
+			malformed := true
+			// _id is a special property so sender can invoke callbacks
+			if key[0:1] == "_" {
+				for _, r := range allowedKeys {
+					if key == r {
+						malformed = false
 					}
 				}
+			} else {
+				malformed = false
 			}
-			if !typeValidated {
-				event["e"] = "malformed--type:" + event["type"].(string)
-				events = append(events, struct {eventName string; data map[string]interface{}}{"client", event})
+			if malformed {
+				event["e"] = "malformed--property"
+				reply = event
 				handled = true
+				break
 			}
 		}
-		// handle _instance event
-		if !handled && (event["type"] == "_instance") {
-			created := "retrieved"
-			// instance value in event takes priority
-			instance := ""
-			if value, ok := event["instance"].(string); ok {
-				instance = value
-			}
-			// recreate session
-			if sess := self.GetSessionByInstance(instance); sess == nil {
-				instanceMatched, _ := regexp.MatchString(`^[a-zA-Z0-9]{25}$`, instance)
-				if instanceMatched {
-					created = "recreated"
-				} else {
-					instance = self.GenerateInstance()
-					created = "created"
+	}
+	if !handled {
+		// see if there is no event type
+		if _, ok := event["type"]; !ok {
+			event["e"] = "malformed--type"
+			reply = event
+			handled = true
+		}
+	}
+	if !handled {
+		// see if event type has illegal value
+		typeStr, _ := event["type"].(string)
+		typeValidated, _ := regexp.MatchString(`^[a-z][a-z_]*$`, typeStr)
+		if !typeValidated {
+			for _, v := range allowedTypes {
+				if typeStr == v {
+					typeValidated = true
 				}
-				// create a new instance for every tab even though they share session cookie
-				event["instance"] = instance
-				// save new instance_id in session
-				session["session_data"].(map[string]interface{})["instance_id"] = instance
-				self.SetSessionData(NewSocket(&sock), session["session_data"].(map[string]interface{}))
-			} else {
-				self.CombineSessions(instance, NewSocket(&sock))
-			}
-			session = self.GetSessionByInstance(instance)
-			event["i"] = "instance " + created
-		}
-		// handle the event
-		if !handled {
-			event["_session"] = session["session_data"]
-			event["_conn"] = map[string]interface{}{
-				"socket": sock,
 			}
-			eventReply, _ := self.Trigger(event)
-			// save session changes
-			self.SetSessionData(NewSocket(&sock), eventReply["_session"].(map[string]interface{}))
-			// remove the session property
-			if _, ok := eventReply["_session"]; ok {
-				delete(eventReply, "_session")
+		}
+		if !typeValidated {
+			event["e"] = "malformed--type:" + event["type"].(string)
+			reply = event
+			handled = true
+		}
+	}
+	// handle _instance event
+	if !handled && (event["type"] == "_instance") {
+		created := "retrieved"
+		// instance value in event takes priority
+		instance := ""
+		if value, ok := event["instance"].(string); ok {
+			instance = value
+		}
+		// recreate session
+		if sess := self.GetSessionByInstance(instance); sess == nil {
+			instanceMatched, _ := regexp.MatchString(`^[a-zA-Z0-9]{25}$`, instance)
+			if instanceMatched {
+				created = "recreated"
+			} else {
+				instance = self.GenerateInstance()
+				created = "created"
 			}
-			// remove the connection property
-			if _, ok := eventReply["_conn"]; ok {
-				delete(eventReply, "_conn")
+			// create a new instance for every tab even though they share session cookie
+			event["instance"] = instance
+			// restore the saved session_data for a recreated instance
+			if created == "recreated" {
+				if sessionData := self.Sessions.LoadSessionData(instance); sessionData != nil {
+					session["session_data"] = sessionData
+				}
 			}
-			// _instance event does not require an implementation; it's optional
-			ee, ok := eventReply["e"].(string)
-			if (eventReply["type"].(string) == "_instance") && ok && (ee == "unimplemented") {
-				delete(eventReply, "e")
+			// save new instance_id in session
+			session["session_data"].(map[string]interface{})["instance_id"] = instance
+			self.SetSessionData(sock, session["session_data"].(map[string]interface{}))
+		} else {
+			self.CombineSessions(instance, sock)
+		}
+		session = self.GetSessionByInstance(instance)
+		// send the unacked events again
+		if created != "created" {
+			self.ReplayQueuedEvents(instance)
+		}
+		event["i"] = "instance " + created
+	}
+	// handle _reply event
+	if !handled && (event["type"] == "_reply") {
+		// the reply must come from the session that was asked
+		replyEvent := map[string]interface{}{}
+		for k, v := range event {
+			replyEvent[k] = v
+		}
+		replyEvent["_session"] = session["session_data"]
+		if self.Reply(replyEvent) {
+			event["i"] = "reply received"
+		} else {
+			event["e"] = "unknown:_rid"
+		}
+	}
+	// handle _ack event
+	if !handled && (event["type"] == "_ack") {
+		instance, _ := session["session_data"].(map[string]interface{})["instance_id"].(string)
+		seq, ok := toFloat(event["seq"])
+		if !ok {
+			event["e"] = "typeof:seq"
+		} else if e := self.AckEvents(instance, int(seq)); e != nil {
+			event["e"] = e.Error()
+		} else {
+			event["i"] = "events acked"
+		}
+	}
+	// handle _subscribe and _unsubscribe events
+	if !handled && ((event["type"] == "_subscribe") || (event["type"] == "_unsubscribe")) {
+		channel, ok := event["channel"].(string)
+		subscribe := event["type"] == "_subscribe"
+		var e error
+		if !ok {
+			e = errors.New("typeof:channel")
+		} else if subscribe {
+			e = Subscribe(session["session_data"].(map[string]interface{}), channel)
+		} else {
+			e = Unsubscribe(session["session_data"].(map[string]interface{}), channel)
+		}
+		if e != nil {
+			event["e"] = e.Error()
+		} else if subscribe {
+			event["i"] = "subscribed"
+		} else {
+			event["i"] = "unsubscribed"
+		}
+	}
+	// handle the event
+	if !handled {
+		event["_session"] = session["session_data"]
+		event["_conn"] = map[string]interface{}{
+			"socket":  sock.Conn(),
+			"context": self.socketContext(sock.ID()),
+		}
+		eventReply, _ := self.Trigger(event)
+		// save session changes
+		self.SetSessionData(sock, eventReply["_session"].(map[string]interface{}))
+		// remove the session property
+		if _, ok := eventReply["_session"]; ok {
+			delete(eventReply, "_session")
+		}
+		// remove the connection property
+		if _, ok := eventReply["_conn"]; ok {
+			delete(eventReply, "_conn")
+		}
+		// built in events do not require an implementation; it's optional
+		ee, ok := eventReply["e"].(string)
+		typeStr, _ := eventReply["type"].(string)
+		builtin := false
+		for _, v := range allowedTypes {
+			if typeStr == v {
+				builtin = true
 			}
-			// reorder the properties so they look pretty
-			reorderedEventReply := eventReply
-			events = append(events, struct {eventName string; data map[string]interface{}}{"client", reorderedEventReply})
-			handled = true
 		}
-		// emit all events
-		for i := 0; i < len(events); i++ {
-			self.OutputStream.write(NewSocket(&sock), events[i].eventName, events[i].data)
+		if builtin && ok && (ee == "unimplemented") {
+			delete(eventReply, "e")
 		}
-	})
-	// socket disconnected
-	self.socketio.OnDisconnect("/", func(sock socketio.Conn, reason string) {
-		self.RemoveSocketFromSession(NewSocket(&sock))
-		self.CheckClock()
-	})
+		// reorder the properties so they look pretty
+		reorderedEventReply := eventReply
+		reply = reorderedEventReply
+		handled = true
+	}
+	return reply
+}
 
-	ticker := time.NewTicker(time.Second)
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				self.CheckClock()
-				keysToSkip := []string{"_session", "_conn"}
-				for _, session := range self.Sessions {
-					events := []map[string]interface{}{}
-					instance, ok := session["instance_id"].(string)
-					if ok {
-						session["session_data"].(map[string]interface{})["instance_id"] = instance
-					}
-					events = self.Receive(events, session["session_data"].(map[string]interface{}), false)
-					for _, event := range events {
-						if _conn, ok := session["_conn"].(map[string]interface{}); ok {
-							socks := _conn["sockets"].([]*SocketWrapper)
-							for _, sock := range socks {
-								clone := self.CloneEvent(event, keysToSkip)
-								self.OutputStream.write(sock, "client", clone)
-							}
-						}
-					}
-				}
+/**
+ * Call Receive() for a session and write the events
+ * to its sockets.  Sessions without sockets are not
+ * polled and sessions without events are polled less
+ * often until an event arrives.
+ *
+ * @param session map The session.
+ * @param now Time The current time.
+ * @param force boolean Poll even if it is not time yet.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) poll(session map[string]interface{}, now time.Time, force bool) {
+	keysToSkip := []string{"_session", "_conn"}
+	// HTTP requests collect their own events
+	socks := []*SocketWrapper{}
+	if _conn, ok := session["_conn"].(map[string]interface{}); ok {
+		all, _ := _conn["sockets"].([]*SocketWrapper)
+		for _, sock := range all {
+			if !sock.collect {
+				socks = append(socks, sock)
 			}
 		}
-	}()
+	}
+	if len(socks) == 0 {
+		return
+	}
+	sessionData, ok := session["session_data"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	instance, ok := session["instance_id"].(string)
+	if ok {
+		sessionData["instance_id"] = instance
+	} else {
+		instance, _ = sessionData["instance_id"].(string)
+	}
+	// skip the instance if it is idle
+	self.pollsMu.Lock()
+	state, ok := self.polls[instance]
+	if !ok {
+		state = &pollState{interval: self.pollInterval}
+		self.polls[instance] = state
+	}
+	due := force || !now.Before(state.next)
+	self.pollsMu.Unlock()
+	if !due {
+		return
+	}
+	events := self.Receive([]map[string]interface{}{}, sessionData, false)
+	for _, event := range events {
+		for _, sock := range socks {
+			clone := self.CloneEvent(event, keysToSkip)
+			self.OutputStream.Write(sock, "client", clone)
+		}
+	}
+	// back off while there are no events
+	self.pollsMu.Lock()
+	if len(events) > 0 {
+		state.interval = self.pollInterval
+	} else if state.interval < self.maxPollInterval {
+		state.interval *= 2
+		if state.interval > self.maxPollInterval {
+			state.interval = self.maxPollInterval
+		}
+	}
+	state.next = now.Add(state.interval)
+	self.pollsMu.Unlock()
+}
+
+/**
+ * Poll an instance right away because an event was
+ * queued for it.  Instances on other nodes are woken
+ * up through the cluster.
+ *
+ * @param instance string An instance string.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) Wake(instance string) {
+	if self.Sessions.GetSessionByInstance(instance) == nil {
+		if self.cluster != nil {
+			self.cluster.Publish(self.node, map[string]interface{}{
+				"type":        "wake",
+				"instance_id": instance,
+			})
+		}
+		return
+	}
+	self.pollsMu.Lock()
+	if state, ok := self.polls[instance]; ok {
+		state.interval = self.pollInterval
+		state.next = time.Time{}
+	}
+	self.pollsMu.Unlock()
+	select {
+	case self.wake <- instance:
+	default:
+		// the next tick will poll it
+	}
+}
+
+/**
+ * Write an event to the sockets of an instance on
+ * this node without queuing it.
+ *
+ * @param instance string An instance string.
+ * @param event map The event to write.
+ * @return boolean False if the instance has no sockets on this node.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) Deliver(instance string, event map[string]interface{}) bool {
+	keysToSkip := []string{"_session", "_conn"}
+	session := self.Sessions.GetSessionByInstance(instance)
+	if session == nil {
+		return false
+	}
+	_conn, _ := session["_conn"].(map[string]interface{})
+	socks, _ := _conn["sockets"].([]*SocketWrapper)
+	for _, sock := range socks {
+		clone := self.CloneEvent(event, keysToSkip)
+		self.OutputStream.Write(sock, "client", clone)
+	}
+	return len(socks) > 0
 }
 
 /**
  * Package events, execute them and return response events.
  *
- * @param array fileEvents
- * @return NULL[]
+ * The events are run on a temporary socket for the
+ * instance so they get the same validation and
+ * session handling as events from a Socket.IO
+ * socket.  The queued events for the instance are
+ * added after the replies.
+ *
+ * An empty event or a _poll event only returns the
+ * queued events.  The events are not run if the hub
+ * is stopping.
+ *
+ * @param events array The events from the client.
+ * @param instance string The instance of the client or "".
+ * @return array The reply and queued events.
  *
  * @author DanielWHoward
  **/
-func (self *XibbitHub) ReadAndWriteUploadEvent(event []map[string]interface{}) []map[string]interface{} {
-	return event
+func (self *XibbitHub) ReadAndWriteUploadEvent(events []map[string]interface{}, instance string) []map[string]interface{} {
+	if !self.beginEvent() {
+		replies := []map[string]interface{}{}
+		for _, event := range events {
+			if (len(event) > 0) && (event["type"] != "_poll") {
+				event["e"] = "unavailable"
+				replies = append(replies, event)
+			}
+		}
+		return replies
+	}
+	defer self.endEvent()
+	sock := NewCollectingSocket("http_" + self.GenerateInstance())
+	self.AddSession(sock)
+	if (instance != "") && (self.GetSessionByInstance(instance) != nil) {
+		self.CombineSessions(instance, sock)
+	}
+	for _, event := range events {
+		if (len(event) == 0) || (event["type"] == "_poll") {
+			continue
+		}
+		self.OutputStream.Write(sock, "client", self.readEvent(sock, event))
+	}
+	// add the waiting events
+	if session := self.GetSession(sock.ID()); session != nil {
+		sessionData, _ := session["session_data"].(map[string]interface{})
+		if instance, _ := sessionData["instance_id"].(string); instance != "" {
+			keysToSkip := []string{"_session", "_conn"}
+			for _, event := range self.Receive([]map[string]interface{}{}, sessionData, false) {
+				self.OutputStream.Write(sock, "client", self.CloneEvent(event, keysToSkip))
+			}
+		}
+	}
+	self.OutputStream.Flush()
+	self.RemoveSocketFromSession(sock)
+	return sock.Collected()
+}
+
+/**
+ * Return an HTTP handler for clients that cannot
+ * use Socket.IO.  It accepts a POST with a JSON
+ * event or array of events, either as the body or
+ * as the XIO form value with an instance form value,
+ * and returns a JSON array of reply and queued
+ * events.
+ *
+ * @return Handler An HTTP handler.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) GetHttpHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var body []byte
+		instance := r.Header.Get("X-Xibbit-Instance")
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			b, e := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+			if e != nil {
+				http.Error(w, e.Error(), http.StatusBadRequest)
+				return
+			}
+			body = b
+		} else {
+			body = []byte(r.FormValue("XIO"))
+		}
+		if value := r.FormValue("instance"); value != "" {
+			instance = value
+		}
+		// accept one event or an array of events
+		events := []map[string]interface{}{}
+		body = []byte(strings.TrimSpace(string(body)))
+		if (len(body) > 0) && (body[0] == '[') {
+			if e := json.Unmarshal(body, &events); e != nil {
+				http.Error(w, "malformed--json", http.StatusBadRequest)
+				return
+			}
+		} else if len(body) > 0 {
+			event := map[string]interface{}{}
+			if e := json.Unmarshal(body, &event); e != nil {
+				http.Error(w, "malformed--json", http.StatusBadRequest)
+				return
+			}
+			events = append(events, event)
+		}
+		replies := self.ReadAndWriteUploadEvent(events, instance)
+		b, e := json.Marshal(replies)
+		if e != nil {
+			http.Error(w, e.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
 }
 
 /**
  * Provide an authenticated callback for an event.
  *
+ * The optional options map can have a schema key
+ * that Trigger() uses to validate the event before
+ * the handler is invoked.  See ValidateEvent().
+ *
+ * It can also have a roles key with roles, any of
+ * which the user needs, and a permissions key with
+ * permissions, all of which the user needs.  See
+ * IsAuthorized().
+ *
+ * A rateLimit key with a map with rate and burst
+ * keys overrides the rateLimits in the config for
+ * this event type.  See Throttle().
+ *
  * @param typ string The event to handle.
  * @param fn mixed A function that will handle the event.
+ * @param options map Optional settings for the handler.
  *
  * @author DanielWHoward
  **/
-func (self *XibbitHub) On(group string, typ string, fn func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{}) {
+func (self *XibbitHub) On(group string, typ string, fn func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{}, options ...map[string]interface{}) {
 	self.Handler_groups[group][typ] = fn
+	if _, ok := self.Handler_options[group]; !ok {
+		self.Handler_options[group] = map[string]map[string]interface{}{}
+	}
+	delete(self.Handler_options[group], typ)
+	for _, option := range options {
+		if _, ok := self.Handler_options[group][typ]; !ok {
+			self.Handler_options[group][typ] = map[string]interface{}{}
+		}
+		for key, value := range option {
+			self.Handler_options[group][typ][key] = value
+		}
+	}
+}
+
+/**
+ * Add a middleware that wraps event handlers.  The
+ * first middleware added is the outermost one.
+ *
+ * The middleware applies to the handlers in the given
+ * groups like "api" or "on", or to all handlers if
+ * there are no groups.  Internal events like __send
+ * and __clock are in the "int" group.
+ *
+ * @param middleware Middleware A function that wraps a handler.
+ * @param groups string The optional groups to apply it to.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) Use(middleware Middleware, groups ...string) {
+	self.middlewares = append(self.middlewares, middlewareEntry{middleware, groups})
 }
 
 /**
@@ -880,6 +1869,15 @@ func (self *XibbitHub) LoadHandler(event map[string]interface{}) error {
 /**
  * Invoke callbacks for an event.
  *
+ * The handler gets a context in the ctx var.  It is
+ * cancelled when the socket that sent the event is
+ * removed or after the timeout option of the handler
+ * or the handlerTimeout config, in seconds.  When
+ * the context is done, the event is replied to with
+ * a timeout or cancelled error without waiting for
+ * the handler to return.  The handler gets its own
+ * copy of the event, including _session and _conn.
+ *
  * @param event array The event to handle.
  *
  * @author DanielWHoward
@@ -906,7 +1904,7 @@ func (self *XibbitHub) Trigger(event map[string]interface{}) (map[string]interfa
 	onHandler, _ := self.Handler_groups["on"][eventType]
 	apiHandler, _ := self.Handler_groups["api"][eventType]
 	// try to load event handler dynamically
-	if (onHandler == nil) && (apiHandler == nil) {
+	if _, ok := eventReply["e"]; !ok && (onHandler == nil) && (apiHandler == nil) {
 		e := self.LoadHandler(event)
 		if e != nil {
 			eventReply["e"] = e.Error()
@@ -916,12 +1914,15 @@ func (self *XibbitHub) Trigger(event map[string]interface{}) (map[string]interfa
 	onHandler, _ = self.Handler_groups["on"][eventType]
 	apiHandler, _ = self.Handler_groups["api"][eventType]
 	// determine event handler to invoke
+	handlerGroup := ""
 	if _, ok := eventReply["e"]; ok {
 		handler = nil
 	} else if (onHandler != nil) && authenticated {
 		handler = onHandler
+		handlerGroup = "on"
 	} else if (apiHandler != nil)  {
 		handler = apiHandler
+		handlerGroup = "api"
 	} else if (onHandler != nil) && !authenticated {
 		handler = nil
 		eventReply["e"] = "unauthenticated"
@@ -929,28 +1930,264 @@ func (self *XibbitHub) Trigger(event map[string]interface{}) (map[string]interfa
 		handler = nil
 		eventReply["e"] = "unimplemented"
 	}
-	// invoke the handler
-	if handler != nil {
-		func() {
-			defer func() {
-				if e, _ := recover().(error); e != nil {
-					eventReply["e"] = e.Error()
-					b := make([]byte, 2048) // adjust buffer size to be larger than expected stack
-					n := runtime.Stack(b, false)
-					eventReply["e_stacktrace"] = string(b[:n])
-				}
-			}()
-			eventReply = handler(eventReply, self.config["vars"].(map[string]interface{}))
-		}()
+	// limit how often the handler can be invoked
+	if (handler != nil) && !strings.HasPrefix(eventType, "__") {
+		if allowed, wait := self.Throttle(event, handlerGroup); !allowed {
+			handler = nil
+			eventReply["e"] = "throttled"
+			eventReply["retryAfter"] = int(math.Ceil(float64(wait) / float64(time.Millisecond)))
+		}
+	}
+	// check the roles and permissions for the handler
+	if handler != nil {
+		options := self.Handler_options[handlerGroup][eventType]
+		_, hasRoles := options["roles"]
+		_, hasPermissions := options["permissions"]
+		session, _ := event["_session"].(map[string]interface{})
+		if (hasRoles || hasPermissions) && !authenticated {
+			handler = nil
+			eventReply["e"] = "unauthenticated"
+		} else if (hasRoles || hasPermissions) && !self.IsAuthorized(session, options) {
+			handler = nil
+			eventReply["e"] = "unauthorized"
+		}
+	}
+	// validate the event against the handler's schema
+	if handler != nil {
+		if schema, ok := self.Handler_options[handlerGroup][eventType]["schema"]; ok && (schema != nil) {
+			if msg := self.ValidateEvent(eventReply, schema); msg != "" {
+				handler = nil
+				eventReply["e"] = msg
+			}
+		}
+	}
+	// wrap the handler with middleware
+	vars := self.config["vars"].(map[string]interface{})
+	if (handler != nil) && (len(self.middlewares) > 0) {
+		group := handlerGroup
+		if strings.HasPrefix(eventType, "__") {
+			group = "int"
+		}
+		for m := len(self.middlewares) - 1; m >= 0; m-- {
+			matched := len(self.middlewares[m].groups) == 0
+			for _, g := range self.middlewares[m].groups {
+				if g == group {
+					matched = true
+				}
+			}
+			if matched {
+				handler = self.middlewares[m].fn(handler)
+			}
+		}
+	}
+	// middleware can change vars for just this event
+	var ctx context.Context = nil
+	if handler != nil {
+		eventVars := make(map[string]interface{}, len(vars)+1)
+		for k, v := range vars {
+			eventVars[k] = v
+		}
+		vars = eventVars
+		// give the handler a context with a deadline
+		var cancel context.CancelFunc
+		ctx, cancel = self.eventContext(event, self.Handler_options[handlerGroup][eventType])
+		defer cancel()
+		vars["ctx"] = ctx
+	}
+	// invoke the handler
+	if handler != nil {
+		// the handler gets its own _session and _conn so
+		// a timed out reply shares nothing with it
+		request, _ := copyValue(eventReply).(map[string]interface{})
+		run := func() (reply map[string]interface{}) {
+			reply = request
+			defer func() {
+				if e, _ := recover().(error); e != nil {
+					reply["e"] = e.Error()
+					b := make([]byte, 2048) // adjust buffer size to be larger than expected stack
+					n := runtime.Stack(b, false)
+					reply["e_stacktrace"] = string(b[:n])
+				}
+			}()
+			reply = handler(request, vars)
+			return
+		}
+		var reply map[string]interface{}
+		finished := true
+		if self.beginEvent() {
+			done := make(chan map[string]interface{}, 1)
+			go func() {
+				// StopHub() waits for an abandoned handler
+				defer self.endEvent()
+				done <- run()
+			}()
+			select {
+			case reply = <-done:
+			case <-ctx.Done():
+				// the handler keeps running until it notices
+				finished = false
+			}
+		} else {
+			// the hub is stopping so wait for the handler
+			reply = run()
+		}
+		if finished {
+			eventReply = reply
+			if _, ok := eventReply["e"]; (eventReply != nil) && !ok && (ctx.Err() == context.DeadlineExceeded) {
+				eventReply["e"] = "timeout"
+			}
+		} else {
+			eventReply["e"] = "timeout"
+			if ctx.Err() != context.DeadlineExceeded {
+				eventReply["e"] = "cancelled"
+			}
+		}
+	}
+	return eventReply, nil
+}
+
+/**
+ * Return a copy of a value with its own maps and
+ * arrays.  Other values, like sockets and contexts,
+ * are shared.
+ *
+ * @param value mixed A value.
+ * @return mixed The copy.
+ *
+ * @author DanielWHoward
+ **/
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		clone := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			clone[key] = copyValue(item)
+		}
+		return clone
+	case []map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		clone := make([]map[string]interface{}, len(v))
+		for i, item := range v {
+			clone[i], _ = copyValue(item).(map[string]interface{})
+		}
+		return clone
+	case []interface{}:
+		if v == nil {
+			return v
+		}
+		clone := make([]interface{}, len(v))
+		for i, item := range v {
+			clone[i] = copyValue(item)
+		}
+		return clone
+	case []string:
+		if v == nil {
+			return v
+		}
+		return append([]string{}, v...)
+	}
+	return value
+}
+
+/**
+ * Return the context for a handler.  It is derived
+ * from the socket context in the _conn property, if
+ * any, and has the handler timeout.
+ *
+ * @param event map The event to handle.
+ * @param options map The handler options.
+ * @return Context The context and its cancel function.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) eventContext(event map[string]interface{}, options map[string]interface{}) (context.Context, context.CancelFunc) {
+	var parent context.Context = context.Background()
+	if _conn, ok := event["_conn"].(map[string]interface{}); ok {
+		if ctx, ok := _conn["context"].(context.Context); ok {
+			parent = ctx
+		}
+	}
+	timeout := self.handlerTimeout
+	if secs, ok := toFloat(options["timeout"]); ok && (secs > 0) {
+		timeout = time.Duration(secs * float64(time.Second))
+	}
+	if timeout > 0 {
+		return context.WithTimeout(parent, timeout)
 	}
-	return eventReply, nil
+	return context.WithCancel(parent)
+}
+
+/**
+ * Take a token from the rate limit buckets for the
+ * socket, instance and user that sent an event.
+ *
+ * The limit comes from the rateLimit option of the
+ * handler or from the rateLimits map in the config
+ * which has api and on keys.  Each limit is a map
+ * with a rate key, the events per second, and a
+ * burst key, the most events at once.
+ *
+ * @param event map The event.
+ * @param group string The group of the handler, "api" or "on".
+ * @return The success and the time until the event is allowed.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) Throttle(event map[string]interface{}, group string) (bool, time.Duration) {
+	eventType, _ := event["type"].(string)
+	limit, ok := self.Handler_options[group][eventType]["rateLimit"].(map[string]interface{})
+	if !ok {
+		limits, _ := self.config["rateLimits"].(map[string]interface{})
+		limit, ok = limits[group].(map[string]interface{})
+	}
+	rate, _ := toFloat(limit["rate"])
+	if !ok || (rate <= 0) {
+		return true, 0
+	}
+	burst, _ := toFloat(limit["burst"])
+	if burst < 1 {
+		burst = math.Max(1, rate)
+	}
+	// find the socket, instance and user that sent the event
+	keys := []string{}
+	if _conn, ok := event["_conn"].(map[string]interface{}); ok {
+		if sock, ok := _conn["socket"].(interface{ ID() string }); ok {
+			keys = append(keys, "socket:"+sock.ID())
+		}
+	}
+	if session, ok := event["_session"].(map[string]interface{}); ok {
+		if instance, _ := session["instance_id"].(string); instance != "" {
+			keys = append(keys, "instance:"+instance)
+		}
+		if username, _ := session["_username"].(string); username != "" {
+			keys = append(keys, "username:"+username)
+		}
+	}
+	allowed := true
+	wait := time.Duration(0)
+	for _, key := range keys {
+		if ok, w := self.rateLimiter.Allow(key+":"+eventType, rate, burst); !ok {
+			allowed = false
+			if w > wait {
+				wait = w
+			}
+		}
+	}
+	return allowed, wait
 }
 
 /**
  * Send an event to another user.
  *
  * The special &quot;all&quot; recipient
- * sends it to all logged in users.
+ * sends it to all logged in users and a
+ * #channel recipient sends it to the instances
+ * subscribed to the channel.
  *
  * @param event map The event to send.
  * @param recipient string The username to send to.
@@ -961,7 +2198,6 @@ func (self *XibbitHub) Trigger(event map[string]interface{}) (map[string]interfa
  **/
 func (self *XibbitHub) Send(event map[string]interface{}, recipient string, emitOnly bool) (map[string]interface{}, error) {
 	var e error = nil
-	keysToSkip := []string{"_session", "_conn"}
 	if emitOnly {
 		address := ""
 		if recipient != "" {
@@ -970,15 +2206,15 @@ func (self *XibbitHub) Send(event map[string]interface{}, recipient string, emit
 			address = recipient
 		}
 		if address != "" {
-			recipients := self.GetSessionsByUsername(address)
-			if len(recipients) > 0 {
-				for _, recipient := range recipients {
-					socks := recipient["_conn"].(map[string]interface{})["sockets"].([]*SocketWrapper)
-					for _, sock := range socks {
-						clone := self.CloneEvent(event, keysToSkip)
-						self.OutputStream.write(sock, "client", clone)
-					}
-				}
+			self.emit(event, address)
+			// deliver to the sockets on the other nodes
+			if self.cluster != nil {
+				keysToSkip := []string{"_session", "_conn"}
+				e = self.cluster.Publish(self.node, map[string]interface{}{
+					"type":  "send",
+					"to":    address,
+					"event": self.CloneEvent(event, keysToSkip),
+				})
 			}
 		}
 	} else {
@@ -1003,6 +2239,168 @@ func (self *XibbitHub) Send(event map[string]interface{}, recipient string, emit
 	return event, e
 }
 
+/**
+ * Send an event to a username or instance and wait
+ * for the client to answer with a _reply event.
+ *
+ * The event gets a _rid property that the client
+ * copies into its _reply event.
+ *
+ * @param ctx Context The deadline for the reply.
+ * @param address string The username or instance to send to.
+ * @param event map The event to send.
+ * @return map The _reply event from the client.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) Request(ctx context.Context, address string, event map[string]interface{}) (map[string]interface{}, error) {
+	rid := self.GenerateInstance()
+	replies := make(chan map[string]interface{}, 1)
+	self.requestsMu.Lock()
+	self.requests[rid] = &pendingRequest{replies: replies, address: address}
+	self.requestsMu.Unlock()
+	// tell the other nodes where to pass the reply
+	if self.cluster != nil {
+		msg := map[string]interface{}{"type": "request", "rid": rid, "to": address}
+		if e := self.cluster.Publish(self.node, msg); e != nil {
+			log.Println(e)
+		}
+	}
+	defer func() {
+		self.requestsMu.Lock()
+		delete(self.requests, rid)
+		self.requestsMu.Unlock()
+		if self.cluster != nil {
+			self.cluster.Publish(self.node, map[string]interface{}{"type": "request_done", "rid": rid})
+		}
+	}()
+	keysToSkip := []string{"_session", "_conn", "_id", "__id"}
+	clone := self.CloneEvent(event, keysToSkip)
+	clone["_rid"] = rid
+	_, e := self.Send(clone, address, true)
+	self.OutputStream.Flush()
+	if e != nil {
+		return nil, e
+	}
+	select {
+	case reply := <-replies:
+		return reply, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+/**
+ * A Request that is waiting for a _reply event
+ * from the username or instance it was sent to.
+ *
+ * @author DanielWHoward
+ **/
+type pendingRequest struct {
+	replies chan map[string]interface{}
+	address string
+	node    string
+}
+
+/**
+ * Return true if the session_data belongs to a
+ * username or instance that a request was sent to.
+ *
+ * @author DanielWHoward
+ **/
+func (self *pendingRequest) answeredBy(sessionData map[string]interface{}) bool {
+	if sessionData == nil {
+		return false
+	}
+	if instance, _ := sessionData["instance_id"].(string); (instance != "") && (instance == self.address) {
+		return true
+	}
+	return isAddressed(sessionData, self.address)
+}
+
+/**
+ * Pass a _reply event from a client to the Request
+ * that is waiting for it.  Replies for requests on
+ * other nodes are passed along to that node.
+ *
+ * The _session of the event must belong to the
+ * username or instance that the request was sent to.
+ *
+ * @param event map The _reply event.
+ * @return boolean True if the request was found.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) Reply(event map[string]interface{}) bool {
+	keysToSkip := []string{"_session", "_conn"}
+	clone := self.CloneEvent(event, keysToSkip)
+	sessionData, _ := event["_session"].(map[string]interface{})
+	if self.reply(clone, sessionData) {
+		return true
+	}
+	rid, _ := clone["_rid"].(string)
+	self.requestsMu.Lock()
+	request, ok := self.clusterRequests[rid]
+	self.requestsMu.Unlock()
+	if !ok || !request.answeredBy(sessionData) {
+		return false
+	}
+	e := self.cluster.Publish(self.node, map[string]interface{}{
+		"type":         "reply",
+		"node":         request.node,
+		"event":        clone,
+		"session_data": sessionData,
+	})
+	return e == nil
+}
+
+/**
+ * Pass a _reply event to a Request on this node.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) reply(event map[string]interface{}, sessionData map[string]interface{}) bool {
+	rid, _ := event["_rid"].(string)
+	self.requestsMu.Lock()
+	request, ok := self.requests[rid]
+	ok = ok && request.answeredBy(sessionData)
+	if ok {
+		delete(self.requests, rid)
+	}
+	self.requestsMu.Unlock()
+	if ok {
+		request.replies <- event
+	}
+	return ok
+}
+
+/**
+ * Write an event to the sockets on this node for
+ * a username, an instance, a #channel or "all"
+ * usernames.
+ *
+ * @param event map The event to send.
+ * @param address string The username, instance or #channel to send to.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) emit(event map[string]interface{}, address string) {
+	keysToSkip := []string{"_session", "_conn"}
+	recipients := self.Sessions.GetSessionsByUsername(address)
+	if session := self.Sessions.GetSessionByInstance(address); session != nil {
+		recipients = append(recipients, session)
+	}
+	for _, recipient := range recipients {
+		if _conn, ok := recipient["_conn"].(map[string]interface{}); ok {
+			socks, _ := _conn["sockets"].([]*SocketWrapper)
+			for _, sock := range socks {
+				clone := self.CloneEvent(event, keysToSkip)
+				self.OutputStream.Write(sock, "client", clone)
+			}
+		}
+	}
+}
+
 /**
  * Return an array of events for this user.
  *
@@ -1028,28 +2426,237 @@ func (self *XibbitHub) Receive(events []map[string]interface{}, session map[stri
 	return events
 }
 
+/**
+ * Add an event to the queue for an instance.  It
+ * is delivered by ReceiveQueuedEvents() until the
+ * client acks it.
+ *
+ * @param instance string An instance string.
+ * @param event map The event to queue.
+ * @return int The sequence number of the event.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) QueueEvent(instance string, event map[string]interface{}) (int, error) {
+	keysToSkip := []string{"_session", "_conn"}
+	return self.Events.Push(instance, self.CloneEvent(event, keysToSkip))
+}
+
+/**
+ * Return the queued events for an instance that
+ * have not been sent yet.  Each event has a _seq
+ * property that the client acks with an _ack event.
+ *
+ * @param instance string An instance string.
+ * @return array An array of events.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) ReceiveQueuedEvents(instance string) []map[string]interface{} {
+	self.eventsMu.Lock()
+	defer self.eventsMu.Unlock()
+	events, e := self.Events.Read(instance, self.eventsSent[instance])
+	if e != nil {
+		log.Println(e)
+	}
+	if len(events) > 0 {
+		seq, _ := toFloat(events[len(events)-1]["_seq"])
+		self.eventsSent[instance] = int(seq)
+	}
+	return events
+}
+
+/**
+ * Remove the queued events for an instance up to
+ * and including a sequence number.
+ *
+ * @param instance string An instance string.
+ * @param seq int The sequence number from an _ack event.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) AckEvents(instance string, seq int) error {
+	if instance == "" {
+		return errors.New("missing:instance")
+	}
+	return self.Events.Ack(instance, seq)
+}
+
+/**
+ * Send the unacked events for an instance again,
+ * such as after the client reconnects.
+ *
+ * @param instance string An instance string.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) ReplayQueuedEvents(instance string) {
+	self.eventsMu.Lock()
+	delete(self.eventsSent, instance)
+	self.eventsMu.Unlock()
+}
+
+/**
+ * Remove queued events that are older than secs
+ * and forget what was sent to instances that no
+ * longer have a session.
+ *
+ * @param secs int A number of seconds.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) ExpireQueuedEvents(secs int) {
+	if e := self.Events.Expire(secs); e != nil {
+		log.Println(e)
+	}
+	instances := map[string]bool{}
+	for _, session := range self.Sessions.All() {
+		if sessionData, ok := session["session_data"].(map[string]interface{}); ok {
+			instance, _ := sessionData["instance_id"].(string)
+			instances[instance] = true
+		}
+	}
+	self.eventsMu.Lock()
+	for instance := range self.eventsSent {
+		if !instances[instance] {
+			delete(self.eventsSent, instance)
+		}
+	}
+	self.eventsMu.Unlock()
+}
+
 /**
  * Connect or disconnect a user from the event system.
  *
+ * The user's roles are saved in the session.  If no
+ * roles are provided, the roles function in the
+ * config, if any, is used to look them up.
+ *
  * @param event array The event to connect or disconnect.
  * @param connect boolean Connect or disconnect.
+ * @param roles string The optional roles of the user.
  * @return array The modified event.
  *
  * @author DanielWHoward
  **/
-func (self *XibbitHub) Connect(event map[string]interface{}, username string, connect bool) map[string]interface{} {
+func (self *XibbitHub) Connect(event map[string]interface{}, username string, connect bool, roles ...string) map[string]interface{} {
 	// update last connection time for user in the database
 	//	connected := 0
 	session := event["_session"].(map[string]interface{})
 	// update username variables
+	delete(session, "_roles")
 	if connect {
 		session["_username"] = username
+		if loadRoles, ok := self.config["roles"].(func(username string) []string); ok && (len(roles) == 0) {
+			roles = loadRoles(username)
+		}
+		if len(roles) > 0 {
+			session["_roles"] = roles
+		}
 	} else {
 		delete(session, "_username")
 	}
 	return event
 }
 
+/**
+ * Return true if the user in a session has a role.
+ *
+ * @param session map The session_data, usually event["_session"].
+ * @param role string The role.
+ * @return boolean True if the user has the role.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) HasRole(session map[string]interface{}, role string) bool {
+	for _, r := range toStrings(session["_roles"]) {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Return true if one of the roles of the user in a
+ * session grants a permission.  The permissions
+ * map in the config maps each role to an array of
+ * permissions.
+ *
+ * @param session map The session_data, usually event["_session"].
+ * @param permission string The permission.
+ * @return boolean True if the user has the permission.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) HasPermission(session map[string]interface{}, permission string) bool {
+	permissions, _ := self.config["permissions"].(map[string]interface{})
+	for _, role := range toStrings(session["_roles"]) {
+		for _, p := range toStrings(permissions[role]) {
+			if p == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/**
+ * Return true if the user in a session has one of
+ * the roles and all of the permissions in the
+ * options for a handler.
+ *
+ * @param session map The session_data.
+ * @param options map The handler options.
+ * @return boolean True if the user is authorized.
+ *
+ * @author DanielWHoward
+ **/
+func (self *XibbitHub) IsAuthorized(session map[string]interface{}, options map[string]interface{}) bool {
+	if roles, ok := options["roles"]; ok {
+		authorized := false
+		for _, role := range toStrings(roles) {
+			if self.HasRole(session, role) {
+				authorized = true
+				break
+			}
+		}
+		if !authorized {
+			return false
+		}
+	}
+	for _, permission := range toStrings(options["permissions"]) {
+		if !self.HasPermission(session, permission) {
+			return false
+		}
+	}
+	return true
+}
+
+/**
+ * Return a string or array of strings, which might
+ * have been through JSON, as a string array.
+ *
+ * @author DanielWHoward
+ **/
+func toStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		strs := make([]string, 0, len(v))
+		for _, item := range v {
+			if str, ok := item.(string); ok {
+				strs = append(strs, str)
+			}
+		}
+		return strs
+	}
+	return nil
+}
+
 /**
  * Update the connected value for this user.
  *
@@ -1078,6 +2685,11 @@ func (self *XibbitHub) CheckClock() {
 		globalVars := self.ReadGlobalVars()
 		// create tick and lastTick native time objects
 		tick := time.Now()
+		// another hub might have handled this tick already
+		if lastTickStr, _ := globalVars["_lastTick"].(string); lastTickStr == tick.Format("2006-01-02 15:04:05") {
+			self.UnlockGlobalVars()
+			return
+		}
 		lastTick := time.Unix(tick.Unix(), 0)
 		if lastTickStr, ok := globalVars["_lastTick"].(string); ok {
 			lastTickObject, e := time.Parse("2006-01-02 15:04:05", lastTickStr)
@@ -1293,8 +2905,7 @@ func (self *XibbitHub) RandSecure(min int, max int) int {
  * @author DanielWHoward
  **/
 func (self *XibbitHub) LockGlobalVars() bool {
-	self.mu.Lock()
-	return true
+	return self.globalVars.Lock()
 }
 
 /**
@@ -1303,46 +2914,7 @@ func (self *XibbitHub) LockGlobalVars() bool {
  * @author DanielWHoward
  **/
 func (self *XibbitHub) LockGlobalVarsUsingSql() bool {
-	now := time.Now().Format("2006-01-02 15:04:05")
-	// generate a unique lock identifier
-	var length = 25
-	var a = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
-	var lockId = ""
-	for i := 0; i < length; i++ {
-		lockId += string(a[self.RandSecure(0, len(a))])
-	}
-	vars := "{\"id\":" + lockId + "}"
-	// try to get the lock
-	q := "INSERT INTO " + self.prefix + "sockets_sessions "
-	q += "(`id`, `socksessid`, `connected`, `touched`, `vars`) VALUES ("
-	q += "0, "
-	q += "'lock', "
-	q += "'" + self.Mysql_real_escape_string(now) + "', "
-	q += "'" + self.Mysql_real_escape_string(now) + "', "
-	q += "'" + self.Mysql_real_escape_string(vars) + "');"
-	qr, e, _ := self.Mysql_query(q)
-	oneAndOnly := false
-	if e == nil {
-		oneAndOnly = true
-		self.Mysql_free_query(qr)
-	}
-	if oneAndOnly {
-		// retrieve lock ID and confirm that it's the same
-		q = "SELECT vars FROM " + self.prefix + "sockets_sessions WHERE `socksessid` = 'lock'"
-		qr, _, _ := self.Mysql_query(q)
-		row := self.Mysql_fetch_assoc(qr)
-		if (qr != nil) && (row != nil) && (row["vars"] != nil) {
-			oneAndOnly = true
-		} else {
-			oneAndOnly = false
-		}
-		self.Mysql_free_query(qr)
-	}
-	if !oneAndOnly {
-		// release the lock if it has been too long
-		self.DeleteExpired("sockets_sessions", 60, " AND `socksessid` = 'lock'")
-	}
-	return oneAndOnly
+	return self.globalVarsSql.Lock()
 }
 
 /**
@@ -1351,8 +2923,7 @@ func (self *XibbitHub) LockGlobalVarsUsingSql() bool {
  * @author DanielWHoward
  **/
 func (self *XibbitHub) UnlockGlobalVars() error {
-	self.mu.Unlock()
-	return nil
+	return self.globalVars.Unlock()
 }
 
 /**
@@ -1361,11 +2932,7 @@ func (self *XibbitHub) UnlockGlobalVars() error {
  * @author DanielWHoward
  **/
 func (self *XibbitHub) UnlockGlobalVarsUsingSql() error {
-	// release the lock
-	q := "DELETE FROM " + self.prefix + "sockets_sessions WHERE socksessid = 'lock';"
-	qr, e, _ := self.Mysql_query(q)
-	self.Mysql_free_query(qr)
-	return e
+	return self.globalVarsSql.Unlock()
 }
 
 /**
@@ -1374,7 +2941,7 @@ func (self *XibbitHub) UnlockGlobalVarsUsingSql() error {
  * @author DanielWHoward
  **/
 func (self *XibbitHub) ReadGlobalVars() map[string]interface{} {
-	return self.globalVars
+	return self.globalVars.Read()
 }
 
 /**
@@ -1383,18 +2950,7 @@ func (self *XibbitHub) ReadGlobalVars() map[string]interface{} {
  * @author DanielWHoward
  **/
 func (self *XibbitHub) ReadGlobalVarsUsingSql() map[string]interface{} {
-	q := "SELECT vars FROM `" + self.prefix + "sockets_sessions` WHERE socksessid = 'global';"
-	qr, _, _ := self.Mysql_query(q)
-	vars := map[string]interface{}{}
-	s := self.Mysql_fetch_assoc(qr)
-	if s != nil {
-		e := json.Unmarshal([]byte(s["vars"].(string)), &vars)
-		if e != nil {
-			log.Println(e)
-		}
-	}
-	self.Mysql_free_query(qr)
-	return vars
+	return self.globalVarsSql.Read()
 }
 
 /**
@@ -1405,7 +2961,7 @@ func (self *XibbitHub) ReadGlobalVarsUsingSql() map[string]interface{} {
  * @author DanielWHoward
  **/
 func (self *XibbitHub) WriteGlobalVars(vars map[string]interface{}) {
-	self.globalVars = vars
+	self.globalVars.Write(vars)
 }
 
 /**
@@ -1416,15 +2972,7 @@ func (self *XibbitHub) WriteGlobalVars(vars map[string]interface{}) {
  * @author DanielWHoward
  **/
 func (self *XibbitHub) WriteGlobalVarsUsingSql(vars map[string]interface{}) {
-	now := time.Now().Format("2006-01-02 15:04:05")
-	b, _ := json.Marshal(vars)
-	s := string(b)
-	q := "UPDATE `" + self.prefix + "sockets_sessions` SET "
-	q += "`touched` = '" + now + "',"
-	q += "`vars` = '" + s + "' "
-	q += "WHERE socksessid='global';"
-	qr, _, _ := self.Mysql_query(q)
-	self.Mysql_free_query(qr)
+	self.globalVarsSql.Write(vars)
 }
 
 /**
@@ -1575,15 +3123,34 @@ func (self *XibbitHub) CreateDatabaseTables(log ILog, users string) {
 	q = "CREATE TABLE `" + self.prefix + "sockets_events` ( "
 	q += "`id` bigint(20) unsigned NOT NULL auto_increment,"
 	q += "`sid` text,"
+	q += "`seq` bigint(20) unsigned DEFAULT NULL," // NULL if not queued by SqlEventQueue
+	q += "`acked` tinyint(1) NOT NULL DEFAULT 0,"
 	q += "`event` mediumtext,"
 	q += "`touched` datetime NOT NULL," // 2014-12-23 06:00:00 (PST)
-	q += "UNIQUE KEY `id` (`id`));"
+	q += "UNIQUE KEY `id` (`id`),"
+	q += "UNIQUE KEY `sid_seq` (`sid`(191), `seq`));"
 	_, e, _ = self.Mysql_query(q)
 	if (e == nil) {
 		log.Println(q, 0)
 	} else {
 		if self.Mysql_errno(e) == 1050 {
 			log.Println("Table " + self.prefix + "sockets_events already exists!", 1)
+			// add the SqlEventQueue columns to an older table
+			alters := []string{
+				"ADD COLUMN `seq` bigint(20) unsigned DEFAULT NULL",
+				"ADD COLUMN `acked` tinyint(1) NOT NULL DEFAULT 0",
+				"ADD UNIQUE KEY `sid_seq` (`sid`(191), `seq`)",
+			}
+			for _, alter := range alters {
+				q = "ALTER TABLE `" + self.prefix + "sockets_events` " + alter + ";"
+				_, e, _ = self.Mysql_query(q)
+				if e == nil {
+					log.Println(q, 0)
+				} else if errno := self.Mysql_errno(e); (errno != 1060) && (errno != 1061) {
+					// 1060 and 1061 mean that the column or key is already there
+					log.Println("Table " + self.prefix + "sockets_events had a MySQL error (" + strconv.Itoa(errno) + "): " + self.Mysql_errstr(e), 2)
+				}
+			}
 		} else {
 			log.Println("Table " + self.prefix + " had a MySQL error (" + strconv.Itoa(self.Mysql_errno(e)) + "): " + self.Mysql_errstr(e), 2)
 		}
@@ -1633,6 +3200,45 @@ func (self *XibbitHub) CreateDatabaseTables(log ILog, users string) {
 		log.Println("Table " + self.prefix + "sockets_sessions already has data!", 1)
 	}
 
+	// create the sockets_bus table
+	//  this table holds recent messages between the hubs of a cluster
+	q = "CREATE TABLE `" + self.prefix + "sockets_bus` ( "
+	q += "`id` bigint(20) unsigned NOT NULL auto_increment,"
+	q += "`node` varchar(25) NOT NULL,"
+	q += "`touched` datetime NOT NULL," // 2014-12-23 06:00:00 (PST)
+	q += "`msg` mediumtext,"
+	q += "UNIQUE KEY `id` (`id`));"
+	_, e, _ = self.Mysql_query(q)
+	if (e == nil) {
+		log.Println(q, 0)
+	} else {
+		if self.Mysql_errno(e) == 1050 {
+			log.Println("Table " + self.prefix + "sockets_bus already exists!", 1)
+		} else {
+			log.Println("Table " + self.prefix + " had a MySQL error (" + strconv.Itoa(self.Mysql_errno(e)) + "): " + self.Mysql_errstr(e), 2)
+		}
+	}
+
+	// create the sockets_ratelimits table
+	//  this table holds the token buckets of the rate limits
+	q = "CREATE TABLE `" + self.prefix + "sockets_ratelimits` ( "
+	q += "`id` bigint(20) unsigned NOT NULL auto_increment,"
+	q += "`bucket` varchar(191) NOT NULL,"
+	q += "`tokens` double NOT NULL,"
+	q += "`last` double NOT NULL,"
+	q += "UNIQUE KEY `id` (`id`),"
+	q += "UNIQUE KEY `bucket` (`bucket`));"
+	_, e, _ = self.Mysql_query(q)
+	if (e == nil) {
+		log.Println(q, 0)
+	} else {
+		if self.Mysql_errno(e) == 1050 {
+			log.Println("Table " + self.prefix + "sockets_ratelimits already exists!", 1)
+		} else {
+			log.Println("Table " + self.prefix + " had a MySQL error (" + strconv.Itoa(self.Mysql_errno(e)) + "): " + self.Mysql_errstr(e), 2)
+		}
+	}
+
 	// create the users table
 	if users != "" {
 		q = "CREATE TABLE `" + self.prefix + "users` ( "
@@ -1692,6 +3298,24 @@ func (self *XibbitHub) DropDatabaseTables(log ILog, users bool) {
 		log.Println(self.Mysql_errstr(e), 2)
 	}
 
+	// this table only has temporary data
+	q = "DROP TABLE `" + self.prefix + "sockets_bus`;"
+	_, e, _ = self.Mysql_query(q)
+	if e == nil {
+		log.Println(q, 0)
+	} else {
+		log.Println(self.Mysql_errstr(e), 2)
+	}
+
+	// this table only has temporary data
+	q = "DROP TABLE `" + self.prefix + "sockets_ratelimits`;"
+	_, e, _ = self.Mysql_query(q)
+	if e == nil {
+		log.Println(q, 0)
+	} else {
+		log.Println(self.Mysql_errstr(e), 2)
+	}
+
 	// required for XibbitHub but might have persistent data
     if users {
 		q = "DROP TABLE `" + self.prefix + "users`;"
@@ -0,0 +1,367 @@
+// The MIT License (MIT)
+//
+// xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// @version 2.0.0
+// @copyright xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+// @license http://opensource.org/licenses/MIT
+package xibdb
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * The SQL differences between database servers.
+ *
+ * XibDb builds its queries through a dialect so
+ * the same row operations work on MySQL, SQLite
+ * and PostgreSQL.
+ *
+ * @author DanielWHoward
+ */
+type Dialect interface {
+	// the dialect name like "mysql"
+	Name() string
+	// quote a table, column or table.column name
+	QuoteIdentifier(name string) string
+	// escape a string for a quoted SQL literal
+	EscapeString(unescaped_string string) string
+	// the placeholder for the nth (1-based) bind argument
+	Placeholder(n int) string
+	// the bind argument or literal for a boolean
+	Bool(value bool) interface{}
+	// the query that describes the columns of a table
+	DescribeQuery(table string) string
+	// decode one row of the DescribeQuery() result
+	DescribeColumn(row map[string]interface{}) (field string, typ string, autoIncrement bool)
+	// the default value for a column type
+	ColumnDefault(typ string, mapBool bool) interface{}
+	// a condition that matches a float column to a value
+	CompareFloat(column string, param string) string
+	// the LIMIT clause for an UPDATE of one row, if supported
+	UpdateLimit() string
+	// the clause that returns the auto_increment value, if needed
+	Returning(column string) string
+	// the LIMIT and OFFSET clause; a negative limit means no limit
+	Limit(limit int, offset int) string
+}
+
+/**
+ * Return a dialect for a name like "mysql", "sqlite"
+ * or "postgres".
+ *
+ * @param name string The dialect or driver name.
+ * @return Dialect A dialect or nil if unknown.
+ *
+ * @author DanielWHoward
+ */
+func NewDialect(name string) Dialect {
+	switch strings.ToLower(name) {
+	case "", "mysql", "mariadb":
+		return NewMysqlDialect()
+	case "sqlite", "sqlite3":
+		return NewSqliteDialect()
+	case "postgres", "postgresql", "pgx":
+		return NewPostgresDialect()
+	}
+	return nil
+}
+
+/**
+ * Quote a possibly qualified identifier like
+ * table.column with the quote character.
+ *
+ * @author DanielWHoward
+ */
+func quoteIdentifier(name string, q string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		if part != "*" {
+			parts[i] = q + strings.ReplaceAll(part, q, q+q) + q
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+/**
+ * Return the default value for a MySQL style
+ * column type.
+ *
+ * @author DanielWHoward
+ */
+func columnDefault(typ string, mapBool bool) interface{} {
+	typ = strings.ToLower(typ)
+	if mapBool && (strings.Contains(typ, "tinyint(1)") || strings.Contains(typ, "bool")) {
+		return false
+	} else if strings.Contains(typ, "int") {
+		return 0
+	} else if strings.Contains(typ, "float") || strings.Contains(typ, "real") {
+		return floatval(0)
+	} else if strings.Contains(typ, "double") || strings.Contains(typ, "numeric") || strings.Contains(typ, "decimal") {
+		return doubleval(0)
+	} else if strings.Contains(typ, "datetime") || strings.Contains(typ, "timestamp") {
+		t, _ := time.Parse("2006-01-02 15:04:05", "1970-01-01 00:00:00")
+		return t
+	}
+	return ""
+}
+
+/**
+ * The MySQL and MariaDB dialect.
+ *
+ * @author DanielWHoward
+ */
+type MysqlDialect struct {
+}
+
+/**
+ * Create a MySQL dialect.
+ *
+ * @author DanielWHoward
+ */
+func NewMysqlDialect() *MysqlDialect {
+	self := new(MysqlDialect)
+	return self
+}
+
+func (self MysqlDialect) Name() string {
+	return "mysql"
+}
+
+func (self MysqlDialect) QuoteIdentifier(name string) string {
+	return quoteIdentifier(name, "`")
+}
+
+func (self MysqlDialect) EscapeString(unescaped_string string) (escaped_string string) {
+	escaped_string = strings.ReplaceAll(unescaped_string, "\\0", "\\x00")
+	escaped_string = strings.ReplaceAll(escaped_string, "\n", "\\n")
+	escaped_string = strings.ReplaceAll(escaped_string, "\r", "\\r")
+	escaped_string = strings.ReplaceAll(escaped_string, "\\", "\\\\")
+	escaped_string = strings.ReplaceAll(escaped_string, "'", "\\'")
+	escaped_string = strings.ReplaceAll(escaped_string, "\"", "\\\"")
+	escaped_string = strings.ReplaceAll(escaped_string, "\x1a", "\\\x1a")
+	return
+}
+
+func (self MysqlDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (self MysqlDialect) Bool(value bool) interface{} {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func (self MysqlDialect) DescribeQuery(table string) string {
+	return "DESCRIBE " + self.QuoteIdentifier(table) + ";"
+}
+
+func (self MysqlDialect) DescribeColumn(row map[string]interface{}) (field string, typ string, autoIncrement bool) {
+	field, _ = row["Field"].(string)
+	typ, _ = row["Type"].(string)
+	extra, _ := row["Extra"].(string)
+	autoIncrement = (extra == "auto_increment")
+	return
+}
+
+func (self MysqlDialect) ColumnDefault(typ string, mapBool bool) interface{} {
+	return columnDefault(typ, mapBool)
+}
+
+func (self MysqlDialect) CompareFloat(column string, param string) string {
+	return column + " LIKE " + param
+}
+
+func (self MysqlDialect) UpdateLimit() string {
+	return " LIMIT 1"
+}
+
+func (self MysqlDialect) Returning(column string) string {
+	return ""
+}
+
+func (self MysqlDialect) Limit(limit int, offset int) string {
+	// MySQL needs a LIMIT for an OFFSET
+	if limit < 0 {
+		return "LIMIT 18446744073709551615 OFFSET " + strconv.Itoa(offset)
+	}
+	return "LIMIT " + strconv.Itoa(limit) + " OFFSET " + strconv.Itoa(offset)
+}
+
+/**
+ * The SQLite dialect.
+ *
+ * @author DanielWHoward
+ */
+type SqliteDialect struct {
+}
+
+/**
+ * Create a SQLite dialect.
+ *
+ * @author DanielWHoward
+ */
+func NewSqliteDialect() *SqliteDialect {
+	self := new(SqliteDialect)
+	return self
+}
+
+func (self SqliteDialect) Name() string {
+	return "sqlite"
+}
+
+func (self SqliteDialect) QuoteIdentifier(name string) string {
+	return quoteIdentifier(name, "\"")
+}
+
+func (self SqliteDialect) EscapeString(unescaped_string string) string {
+	return strings.ReplaceAll(unescaped_string, "'", "''")
+}
+
+func (self SqliteDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (self SqliteDialect) Bool(value bool) interface{} {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func (self SqliteDialect) DescribeQuery(table string) string {
+	return "PRAGMA table_info(" + self.QuoteIdentifier(table) + ");"
+}
+
+func (self SqliteDialect) DescribeColumn(row map[string]interface{}) (field string, typ string, autoIncrement bool) {
+	field, _ = row["name"].(string)
+	typ, _ = row["type"].(string)
+	pk, _ := row["pk"].(string)
+	// an INTEGER PRIMARY KEY is an alias for the rowid
+	autoIncrement = (pk == "1") && (strings.ToUpper(typ) == "INTEGER")
+	return
+}
+
+func (self SqliteDialect) ColumnDefault(typ string, mapBool bool) interface{} {
+	return columnDefault(typ, mapBool)
+}
+
+func (self SqliteDialect) CompareFloat(column string, param string) string {
+	return column + "=" + param
+}
+
+func (self SqliteDialect) UpdateLimit() string {
+	return ""
+}
+
+func (self SqliteDialect) Returning(column string) string {
+	return ""
+}
+
+func (self SqliteDialect) Limit(limit int, offset int) string {
+	return "LIMIT " + strconv.Itoa(limit) + " OFFSET " + strconv.Itoa(offset)
+}
+
+/**
+ * The PostgreSQL dialect.
+ *
+ * @author DanielWHoward
+ */
+type PostgresDialect struct {
+}
+
+/**
+ * Create a PostgreSQL dialect.
+ *
+ * @author DanielWHoward
+ */
+func NewPostgresDialect() *PostgresDialect {
+	self := new(PostgresDialect)
+	return self
+}
+
+func (self PostgresDialect) Name() string {
+	return "postgres"
+}
+
+func (self PostgresDialect) QuoteIdentifier(name string) string {
+	return quoteIdentifier(name, "\"")
+}
+
+func (self PostgresDialect) EscapeString(unescaped_string string) string {
+	return strings.ReplaceAll(unescaped_string, "'", "''")
+}
+
+func (self PostgresDialect) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (self PostgresDialect) Bool(value bool) interface{} {
+	return value
+}
+
+func (self PostgresDialect) DescribeQuery(table string) string {
+	q := "SELECT column_name, data_type, column_default, is_identity"
+	q += " FROM information_schema.columns"
+	q += " WHERE table_schema=current_schema() AND table_name='" + self.EscapeString(table) + "'"
+	q += " ORDER BY ordinal_position;"
+	return q
+}
+
+func (self PostgresDialect) DescribeColumn(row map[string]interface{}) (field string, typ string, autoIncrement bool) {
+	field, _ = row["column_name"].(string)
+	typ, _ = row["data_type"].(string)
+	def, _ := row["column_default"].(string)
+	identity, _ := row["is_identity"].(string)
+	autoIncrement = strings.HasPrefix(def, "nextval(") || (identity == "YES")
+	return
+}
+
+func (self PostgresDialect) ColumnDefault(typ string, mapBool bool) interface{} {
+	if strings.ToLower(typ) == "boolean" {
+		return false
+	}
+	return columnDefault(typ, mapBool)
+}
+
+func (self PostgresDialect) CompareFloat(column string, param string) string {
+	return "CAST(" + column + " AS TEXT) LIKE " + param
+}
+
+func (self PostgresDialect) UpdateLimit() string {
+	return ""
+}
+
+func (self PostgresDialect) Returning(column string) string {
+	return " RETURNING " + self.QuoteIdentifier(column)
+}
+
+func (self PostgresDialect) Limit(limit int, offset int) string {
+	if limit < 0 {
+		return "OFFSET " + strconv.Itoa(offset)
+	}
+	return "LIMIT " + strconv.Itoa(limit) + " OFFSET " + strconv.Itoa(offset)
+}
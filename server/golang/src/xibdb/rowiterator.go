@@ -0,0 +1,131 @@
+// The MIT License (MIT)
+//
+// xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// @version 2.0.0
+// @copyright xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+// @license http://opensource.org/licenses/MIT
+package xibdb
+
+import (
+	"database/sql"
+)
+
+/**
+ * Reads the rows of a query one at a time so that
+ * memory use does not grow with the result.
+ *
+ * Rows are decoded like ReadRowsNative() does.  Call
+ * Next() until it returns false, then check Err()
+ * and Close() the iterator.
+ *
+ * @author DanielWHoward
+ */
+type RowIterator struct {
+	xdb        XibDb
+	rows       *sql.Rows
+	desc       map[string]interface{}
+	json_field string
+	row        map[string]interface{}
+	e          error
+}
+
+/**
+ * Start reading JSON table rows from the database.
+ *
+ * The query object has the same keys as the one for
+ * ReadRowsNative().
+ *
+ * @param querySpec A query object or a database table string or array.
+ * @return An iterator over the rows.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) ReadRowsIter(querySpec interface{}) (*RowIterator, error) {
+	if that.DumpSql || that.DryRun {
+		that.log.Println("ReadRowsIter()")
+	}
+	rows, desc, json_field, e := that.readRowsQuery(querySpec, nil, nil, nil)
+	if e != nil {
+		return nil, e
+	}
+	self := new(RowIterator)
+	self.xdb = that
+	self.rows = rows
+	self.desc = desc
+	self.json_field = json_field
+	return self, nil
+}
+
+/**
+ * Move to the next row.
+ *
+ * @return False if there are no more rows or an error.
+ *
+ * @author DanielWHoward
+ */
+func (self *RowIterator) Next() bool {
+	self.row = nil
+	if self.rows == nil {
+		return false
+	}
+	row := self.xdb.Mysql_fetch_assoc(self.rows)
+	if row == nil {
+		self.e = self.rows.Err()
+		return false
+	}
+	self.row = self.xdb.decodeRow(row, self.desc, self.json_field)
+	return true
+}
+
+/**
+ * Return the current row.
+ *
+ * @return A JSON object or nil.
+ *
+ * @author DanielWHoward
+ */
+func (self *RowIterator) Row() map[string]interface{} {
+	return self.row
+}
+
+/**
+ * Return the error that stopped Next(), if any.
+ *
+ * @author DanielWHoward
+ */
+func (self *RowIterator) Err() error {
+	return self.e
+}
+
+/**
+ * Release the database rows.
+ *
+ * @author DanielWHoward
+ */
+func (self *RowIterator) Close() error {
+	if self.rows == nil {
+		return nil
+	}
+	e := self.rows.Close()
+	self.rows = nil
+	return e
+}
@@ -0,0 +1,394 @@
+// The MIT License (MIT)
+//
+// xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in all
+// copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+//
+// @version 2.0.0
+// @copyright xibbit 2.0.0 Copyright (c) © 2021 Daniel W. Howard and Sanjana A. Joshi Partnership
+// @license http://opensource.org/licenses/MIT
+package xibdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+/**
+ * A struct field and the row key it maps to.
+ *
+ * The key comes from the `xibdb` struct tag, then
+ * the `json` struct tag, then the field name.  A
+ * field tagged `xibdb:"-"` is skipped and a
+ * map[string]interface{} field tagged
+ * `xibdb:",overflow"` holds the keys that no other
+ * field claims, which live in the JSON column.
+ *
+ * @author DanielWHoward
+ */
+type structField struct {
+	index    []int
+	key      string
+	overflow bool
+}
+
+/**
+ * Return the mapped fields of a struct type.
+ *
+ * @param t reflect.Type A struct type.
+ * @return The fields and the overflow field, if any.
+ *
+ * @author DanielWHoward
+ */
+func structFields(t reflect.Type) (fields []structField, overflow *structField, e error) {
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() {
+			continue
+		}
+		if f.Anonymous && (f.Type.Kind() == reflect.Struct) {
+			// the promoted fields are visited separately
+			continue
+		}
+		tag := f.Tag.Get("xibdb")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if opts == "overflow" {
+			if f.Type != reflect.TypeOf(map[string]interface{}{}) {
+				return nil, nil, errors.New("xibdb: overflow field " + f.Name + " must be map[string]interface{}")
+			}
+			overflow = &structField{index: f.Index, overflow: true}
+			continue
+		}
+		if name == "" {
+			jsonName, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if jsonName == "-" {
+				continue
+			}
+			name = jsonName
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, structField{index: f.Index, key: name})
+	}
+	return
+}
+
+/**
+ * Read JSON table rows from the database into
+ * structs.
+ *
+ * The query object has the same keys as the one for
+ * ReadRowsNative().  Each row key is assigned to the
+ * struct field that maps to it and the remaining keys
+ * go to the overflow field, if any.
+ *
+ * @param xdb *XibDb The database.
+ * @param querySpec A query object or a database table string or array.
+ * @return A slice of structs.
+ *
+ * @author DanielWHoward
+ */
+func ReadRowsInto[T any](xdb *XibDb, querySpec interface{}) ([]T, error) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if t.Kind() != reflect.Struct {
+		return nil, errors.New("xibdb: ReadRowsInto() needs a struct type but got " + t.String())
+	}
+	fields, overflow, e := structFields(t)
+	if e != nil {
+		return nil, e
+	}
+	it, e := xdb.ReadRowsIter(querySpec)
+	if e != nil {
+		return nil, e
+	}
+	defer it.Close()
+	objs := []T{}
+	for it.Next() {
+		var obj T
+		if e := decodeStruct(it.Row(), it.desc, reflect.ValueOf(&obj).Elem(), fields, overflow); e != nil {
+			return nil, e
+		}
+		objs = append(objs, obj)
+	}
+	if e := it.Err(); e != nil {
+		return nil, e
+	}
+	return objs, nil
+}
+
+/**
+ * Copy a row into a struct.
+ *
+ * SQL columns without a field are dropped.
+ *
+ * @author DanielWHoward
+ */
+func decodeStruct(row map[string]interface{}, desc map[string]interface{}, v reflect.Value, fields []structField, overflow *structField) error {
+	used := map[string]bool{}
+	for _, f := range fields {
+		value, ok := row[f.key]
+		if !ok {
+			continue
+		}
+		used[f.key] = true
+		if e := assignValue(v.FieldByIndex(f.index), value); e != nil {
+			return errors.New("xibdb: field " + f.key + ": " + e.Error())
+		}
+	}
+	if overflow != nil {
+		extra := map[string]interface{}{}
+		// only JSON column data overflows
+		for key, value := range row {
+			_, isSql := desc[key]
+			if !used[key] && !isSql {
+				extra[key] = value
+			}
+		}
+		v.FieldByIndex(overflow.index).Set(reflect.ValueOf(extra))
+	}
+	return nil
+}
+
+/**
+ * Assign a decoded row value to a struct field.
+ *
+ * NULL sets the zero value and allocates nothing
+ * for a pointer field.
+ *
+ * @author DanielWHoward
+ */
+func assignValue(v reflect.Value, value interface{}) error {
+	if value == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+	if v.Kind() == reflect.Ptr {
+		p := reflect.New(v.Type().Elem())
+		if e := assignValue(p.Elem(), value); e != nil {
+			return e
+		}
+		v.Set(p)
+		return nil
+	}
+	if scanner, ok := v.Addr().Interface().(sql.Scanner); ok {
+		return scanner.Scan(value)
+	}
+	if rv := reflect.ValueOf(value); rv.Type().AssignableTo(v.Type()) {
+		v.Set(rv)
+		return nil
+	}
+	if v.Type() == reflect.TypeOf(time.Time{}) {
+		valueStr, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("cannot assign %T to time.Time", value)
+		}
+		t, e := time.Parse("2006-01-02 15:04:05", valueStr)
+		if e != nil {
+			t, e = time.Parse(time.RFC3339, valueStr)
+		}
+		if e != nil {
+			return e
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		switch value := value.(type) {
+		case bool:
+			v.SetBool(value)
+		case string:
+			v.SetBool((value == "1") || (strings.ToLower(value) == "true"))
+		default:
+			if is_int(value) {
+				v.SetBool(intval(value) != 0)
+			} else if is_float(value) {
+				v.SetBool(floatval(value) != 0)
+			} else {
+				return fmt.Errorf("cannot assign %T to bool", value)
+			}
+		}
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if is_int(value) {
+			v.SetInt(int64(intval(value)))
+		} else if is_float(value) {
+			v.SetInt(int64(floatval(value)))
+		} else if valueBool, ok := value.(bool); ok {
+			v.SetInt(0)
+			if valueBool {
+				v.SetInt(1)
+			}
+		} else {
+			return fmt.Errorf("cannot assign %T to %s", value, v.Type())
+		}
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if valueUint64, ok := value.(uint64); ok {
+			v.SetUint(valueUint64)
+		} else if is_int(value) {
+			v.SetUint(uint64(intval(value)))
+		} else if is_float(value) {
+			v.SetUint(uint64(floatval(value)))
+		} else {
+			return fmt.Errorf("cannot assign %T to %s", value, v.Type())
+		}
+		return nil
+	case reflect.Float32, reflect.Float64:
+		if valueInt64, ok := value.(int64); ok {
+			v.SetFloat(float64(valueInt64))
+		} else if is_float(value) || is_int(value) {
+			v.SetFloat(floatval(value))
+		} else {
+			return fmt.Errorf("cannot assign %T to %s", value, v.Type())
+		}
+		return nil
+	case reflect.String:
+		v.SetString(fmt.Sprintf("%v", value))
+		return nil
+	}
+	// nested JSON data like maps, slices and structs
+	jsonBytes, e := json.Marshal(value)
+	if e != nil {
+		return e
+	}
+	return json.Unmarshal(jsonBytes, v.Addr().Interface())
+}
+
+/**
+ * Insert a struct as a row of JSON into a database
+ * table.
+ *
+ * The fields map to columns like they do for
+ * ReadRowsInto().  Keys that are not SQL columns and
+ * the overflow field entries are stored in the JSON
+ * column.  A zero auto_increment field is left out
+ * and set to the new id afterwards.
+ *
+ * @param querySpec A query object or a database table string.
+ * @param v A pointer to a struct.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) InsertStruct(querySpec interface{}, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if (rv.Kind() != reflect.Ptr) || rv.IsNil() || (rv.Elem().Kind() != reflect.Struct) {
+		return errors.New("xibdb: InsertStruct() needs a pointer to a struct")
+	}
+	rv = rv.Elem()
+	fields, overflow, e := structFields(rv.Type())
+	if e != nil {
+		return e
+	}
+
+	// find the auto_increment column
+	tableStr, _ := querySpec.(string)
+	if queryMap, ok := querySpec.(map[string]interface{}); ok { // is_map
+		tableStr, _ = queryMap["table"].(string)
+	}
+	if _, e := that.ReadDescNative(tableStr); e != nil {
+		return e
+	}
+	auto_increment_field, _ := that.cache[tableStr].(map[string]interface{})["auto_increment_column"].(string)
+
+	values := map[string]interface{}{}
+	if overflow != nil {
+		for key, value := range rv.FieldByIndex(overflow.index).Interface().(map[string]interface{}) {
+			values[key] = value
+		}
+	}
+	var autoIncrement reflect.Value
+	for _, f := range fields {
+		fv := rv.FieldByIndex(f.index)
+		if f.key == auto_increment_field {
+			autoIncrement = fv
+			if fv.IsZero() {
+				continue
+			}
+		}
+		value, e := fieldValue(fv)
+		if e != nil {
+			return errors.New("xibdb: field " + f.key + ": " + e.Error())
+		}
+		values[f.key] = value
+	}
+
+	valuesMap, e := that.InsertRowNative(querySpec, "", values, -1)
+	if e != nil {
+		return e
+	}
+	if autoIncrement.IsValid() && (valuesMap[auto_increment_field] != nil) {
+		return assignValue(autoIncrement, valuesMap[auto_increment_field])
+	}
+	return nil
+}
+
+/**
+ * Convert a struct field to a value that
+ * InsertRowNative() can store.
+ *
+ * @author DanielWHoward
+ */
+func fieldValue(v reflect.Value) (interface{}, error) {
+	if (v.Kind() == reflect.Ptr) || (v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return nil, nil
+		}
+	}
+	if valuer, ok := v.Interface().(driver.Valuer); ok {
+		return valuer.Value()
+	}
+	if (v.Kind() == reflect.Ptr) || (v.Kind() == reflect.Interface) {
+		v = v.Elem()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t, nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	}
+	// nested data becomes plain JSON values
+	jsonBytes, e := json.Marshal(v.Interface())
+	if e != nil {
+		return nil, e
+	}
+	var value interface{}
+	if e := json.Unmarshal(jsonBytes, &value); e != nil {
+		return nil, e
+	}
+	return convertFloatToInt(value), nil
+}
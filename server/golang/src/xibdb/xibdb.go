@@ -26,6 +26,7 @@
 package xibdb
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
@@ -83,8 +84,8 @@ func convertFloatToInt(o interface{}) interface{} {
 
 /**
  * Reads, inserts, deletes, updates and moves rows in
- * MySQL database using the JSON (JavaScript Object
- * Notation) format.
+ * a MySQL, SQLite or PostgreSQL database using the
+ * JSON (JavaScript Object Notation) format.
  *
  * Adapted, refactored and re-licensed from the jsonhib
  * open source project.
@@ -98,17 +99,21 @@ type XibDb struct {
 	AutoCommit       bool
 	DryRun           bool
 	DumpSql          bool
+	FlattenQueries   bool
 	MapBool          bool
 	Opt              bool
 	log              Logger
 	paramRand        string
+	tx               *sql.Tx
+	ctx              context.Context
+	Dialect          Dialect
 }
 
 /**
  * Use a database for JSON.
  *
  * Configurations are arrays with keys like "sort_column",
- * "json_column", and "link_identifier" keys.
+ * "json_column", "link_identifier" and "dialect" keys.
  *
  * @param config A configuration.
  *
@@ -127,6 +132,17 @@ func NewXibDb(config map[string]interface{}) *XibDb {
 	}
 	self.DryRun = false
 	self.DumpSql = false
+	if obj, ok := config["flattenQueries"].(bool); ok {
+		self.FlattenQueries = obj
+	}
+	if obj, ok := config["dialect"].(Dialect); ok {
+		self.Dialect = obj
+	} else if obj, ok := config["dialect"].(string); ok {
+		self.Dialect = NewDialect(obj)
+	}
+	if self.Dialect == nil {
+		self.Dialect = NewMysqlDialect()
+	}
 	self.Opt = true
 	self.log = self
 	if obj, ok := config["log"].(Logger); ok {
@@ -148,6 +164,12 @@ func NewXibDb(config map[string]interface{}) *XibDb {
  * If there is no sort column, the rows are in arbitrary
  * order.
  *
+ * The query object can have an "order by" SQL string
+ * or list of columns, a "limit" and an "offset" to
+ * read a page of rows or a slice of a JSON array, and
+ * an "after" map with the "order by" values of the
+ * last row of the previous page.
+ *
  * @param {string} querySpec A query object or a database table string or array.
  * @param {string} whereSpec Usually nil but a WHERE clause or raw SQL.
  * @param {string} columnsSpec Usually nil but a columns clause.
@@ -172,6 +194,41 @@ func (that XibDb) ReadRowsNative(querySpec interface{}, whereSpec interface{}, c
 		}
 	}
 
+	// read the table
+	rows, desc, json_field, e := that.readRowsQuery(querySpec, whereSpec, columnsSpec, onSpec)
+	if e != nil {
+		return nil, e
+	}
+	// read result
+	objs = []map[string]interface{}{}
+	for row := that.Mysql_fetch_assoc(rows); row != nil; row = that.Mysql_fetch_assoc(rows) {
+		objs = append(objs, that.decodeRow(row, desc, json_field))
+	}
+	that.Mysql_free_query(rows)
+
+	// check constraints
+	if that.CheckConstraints {
+		e = that.CheckSortColumnConstraint(querySpec, whereSpec)
+		if e == nil {
+			e = that.CheckJsonColumnConstraint(querySpec, whereSpec)
+		}
+		if e != nil {
+			return nil, that.Fail(e, "post-check: " + e.Error(), "", nil)
+		}
+	}
+
+	return
+}
+
+/**
+ * Run the SELECT query for ReadRowsNative() and
+ * ReadRowsIter().
+ *
+ * @return The rows, the merged table description and the json_column.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) readRowsQuery(querySpec interface{}, whereSpec interface{}, columnsSpec interface{}, onSpec interface{}) (*sql.Rows, map[string]interface{}, string, error) {
 	// decode the arguments into variables
 	queryMap, ok := querySpec.(map[string]interface{})
 	if !ok { // not is_map
@@ -183,18 +240,37 @@ func (that XibDb) ReadRowsNative(querySpec interface{}, whereSpec interface{}, c
 		"on":       "",
 		"where":    "",
 		"order by": "",
+		"limit":    -1,
+		"offset":   0,
+		"after":    nil,
 	}, map[string]interface{}{
 		"table":    querySpec,
 		"columns":  columnsSpec,
 		"on":       onSpec,
 		"where":    whereSpec,
 		"order by": "",
+		"limit":    -1,
+		"offset":   0,
+		"after":    nil,
 	}, queryMap)
 	table := queryMap["table"]
 	columns := queryMap["columns"]
 	onVar := queryMap["on"]
 	where := queryMap["where"]
 	orderby := queryMap["order by"]
+	limit := -1
+	if is_int(queryMap["limit"]) {
+		limit = intval(queryMap["limit"])
+	} else if is_float(queryMap["limit"]) {
+		limit = int(floatval(queryMap["limit"]))
+	}
+	offset := 0
+	if is_int(queryMap["offset"]) {
+		offset = intval(queryMap["offset"])
+	} else if is_float(queryMap["offset"]) {
+		offset = int(floatval(queryMap["offset"]))
+	}
+	after, _ := queryMap["after"].(map[string]interface{})
 
 	// decode ambiguous table argument
 	tableArr, okList := table.([]string)
@@ -227,12 +303,14 @@ func (that XibDb) ReadRowsNative(querySpec interface{}, whereSpec interface{}, c
     }
 	sort_field, _ := descMap["sort_column"].(string)
 	json_field, _ := descMap["json_column"].(string)
-	orderByStr := ""
-	if sort_field != "" {
-		orderByStr = " ORDER BY `" + sort_field + "` ASC"
+	orderByStr, orderCols, e := that.implementOrderBy(orderby)
+	if e != nil {
+		return nil, nil, "", that.Fail(e, "", "", nil)
 	}
-	if orderByParamStr, ok := orderby.(string); ok && (orderByParamStr != "") {
-		orderByStr = " ORDER BY " + orderByParamStr
+	if (orderByStr == "") && (sort_field != "") {
+		// JSON arrays are in sort_column order
+		orderByStr = " ORDER BY " + that.quote(sort_field) + " ASC"
+		orderCols = []orderColumn{{sort_field, false}}
 	}
 
 	// decode remaining ambiguous arguments
@@ -245,7 +323,7 @@ func (that XibDb) ReadRowsNative(querySpec interface{}, whereSpec interface{}, c
 			if columnsStr != "" {
 				columnsStr += ", "
 			}
-			columnsStr += "`" + tbl + "`.*"
+			columnsStr += that.quote(tbl) + ".*"
 		}
 	} else if columnArr, ok := columns.([]string); ok { // is_list
 		if len(tableArr) == 1 {
@@ -254,7 +332,7 @@ func (that XibDb) ReadRowsNative(querySpec interface{}, whereSpec interface{}, c
 				if columnsStr != "" {
 					columnsStr += ", "
 				}
-				columnsStr += "`" + col + "`"
+				columnsStr += that.quote(col)
 			}
 		} else if len(tableArr) >= 2 {
 			// pick specific columns from first table
@@ -262,7 +340,7 @@ func (that XibDb) ReadRowsNative(querySpec interface{}, whereSpec interface{}, c
 				if columnsStr != "" {
 					columnsStr += ", "
 				}
-				columnsStr += "`" + tableStr + "`.`" + col + "`"
+				columnsStr += that.quote(tableStr) + "." + that.quote(col)
 			}
 			// assume '*' columns from remaining tables
 			for t := 1; t < len(tableArr); t++ {
@@ -270,7 +348,7 @@ func (that XibDb) ReadRowsNative(querySpec interface{}, whereSpec interface{}, c
 				if columnsStr != "" {
 					columnsStr += ", "
 				}
-				columnsStr += "`" + tbl + "`.*"
+				columnsStr += that.quote(tbl) + ".*"
 			}
 		}
 	}
@@ -297,93 +375,106 @@ func (that XibDb) ReadRowsNative(querySpec interface{}, whereSpec interface{}, c
 	if onVarStr != "" {
 		onVarStr = " " + onVarStr
 	}
+	params := map[string]interface{}{}
 	whereStr, _ := where.(string)
 	if whereMap, ok := where.(map[string]interface{}); ok { // is_map
 		whereMap = that.ApplyTablesToWhere(whereMap, tableStr)
-		whereStr = that.ImplementWhere(whereMap)
+		whereStr = that.implementWhere(whereMap, params)
+	}
+	if after != nil {
+		// continue after the last row of the previous page
+		afterStr, e := that.implementAfter(after, orderCols, params)
+		if e != nil {
+			return nil, nil, "", that.Fail(e, "", "", nil)
+		}
+		if whereStr == "" {
+			whereStr = afterStr
+		} else {
+			// the condition is wrapped without its WHERE keyword
+			cond := strings.TrimSpace(whereStr)
+			if strings.HasPrefix(strings.ToUpper(cond), "WHERE ") {
+				cond = strings.TrimSpace(cond[len("WHERE "):])
+			}
+			whereStr = "(" + cond + ") AND " + afterStr
+		}
 	}
 	if (whereStr != "") && !strings.HasPrefix(whereStr, " ") {
 		whereStr = " WHERE " + whereStr
 	}
+	limitStr := ""
+	if (limit >= 0) || (offset > 0) {
+		limitStr = " " + that.Dialect.Limit(limit, offset)
+	}
 
-	config := that.config
-
-	// read the table
-	q := "SELECT " + columnsStr + " FROM `" + tableStr + "`" + onVarStr + whereStr + orderByStr + ";"
-	params := map[string]interface{}{}
+	q := "SELECT " + columnsStr + " FROM " + that.quote(tableStr) + onVarStr + whereStr + orderByStr + limitStr + ";"
 	rows, e, _ := that.Mysql_query(q, params)
 	if e != nil {
-		return nil, that.Fail(e, "", q, nil)
+		return nil, nil, "", that.Fail(e, "", q, nil)
 	}
-	// read result
-	objs = []map[string]interface{}{}
-	for row := that.Mysql_fetch_assoc(rows); row != nil; row = that.Mysql_fetch_assoc(rows) {
-		obj := map[string]interface{}{}
-		// add the SQL data first
-		for key, value := range row {
-			if key == "class" {
-				key = "clazz"
+	return rows, desc, json_field, nil
+}
+
+/**
+ * Convert a row from Mysql_fetch_assoc() to a JSON
+ * object using the table description.
+ *
+ * @param row map A row of strings.
+ * @param desc map The table description.
+ * @param json_field string The json_column or "".
+ * @return A JSON object.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) decodeRow(row map[string]interface{}, desc map[string]interface{}, json_field string) map[string]interface{} {
+	obj := map[string]interface{}{}
+	// add the SQL data first
+	for key, value := range row {
+		if key == "class" {
+			key = "clazz"
+		}
+		valueStr, valueIsStr := value.(string)
+		if key == json_field {
+			// add non-SQL JSON data later
+			_ = 0
+		} else if key == that.config["sort_column"] {
+			// sort column isn't user data
+		} else if value == nil {
+			obj[key] = nil
+		} else if _, ok := desc[key].(bool); that.MapBool && is_numeric(value) && (intval(fmt.Sprintf("%v", (intval(value)))) == intval(value)) && ok {
+			//TODO intval() conditional above does nothing; make it actually check properly
+			valueStr = fmt.Sprintf("%v", value)
+			boolVal := false
+			if valueStr == "1" {
+				boolVal = true
 			}
-			valueStr, valueIsStr := value.(string)
-			if key == json_field {
-				// add non-SQL JSON data later
-				_ = 0
-			} else if key == config["sort_column"] {
-				// sort column isn't user data
-			} else if value == nil {
-				obj[key] = nil
-			} else if _, ok := desc[key].(bool); that.MapBool && is_numeric(value) && (intval(fmt.Sprintf("%v", (intval(value)))) == intval(value)) && ok {
-				//TODO intval() conditional above does nothing; make it actually check properly
-				valueStr = fmt.Sprintf("%v", value)
-				boolVal := false
-				if valueStr == "1" {
-					boolVal = true
-				}
-				obj[key] = boolVal
-			} else if is_numeric(value) && (fmt.Sprintf("%v", (intval(value))) == value) && is_int(desc[key]) {
-				obj[key] = intval(value)
-			} else if is_numeric(value) && is_float(desc[key]) {
-				obj[key] = floatval(value)
-			} else if _, ok := desc[key].(time.Time); valueIsStr && ok {
-				obj[key], _ = time.Parse("2006-01-02 15:04:05", valueStr)
+			obj[key] = boolVal
+		} else if is_numeric(value) && (fmt.Sprintf("%v", (intval(value))) == value) && is_int(desc[key]) {
+			obj[key] = intval(value)
+		} else if is_numeric(value) && is_float(desc[key]) {
+			obj[key] = floatval(value)
+		} else if _, ok := desc[key].(time.Time); valueIsStr && ok {
+			obj[key], _ = time.Parse("2006-01-02 15:04:05", valueStr)
+		} else {
+			val := []map[string]interface{}{}
+			if e := json.Unmarshal([]byte(valueStr), &val); e == nil {
+				obj[key] = val
 			} else {
-				val := []map[string]interface{}{}
-				e = json.Unmarshal([]byte(valueStr), &val)
-				if e == nil {
-					obj[key] = val
-				} else {
-					obj[key] = value
-				}
+				obj[key] = value
 			}
 		}
-		// add non-SQL JSON data
-		if (json_field != "") && (row[json_field] != nil) {
-			jsonMap := map[string]interface{}{}
-			jsonStr, _ := row[json_field].(string)
-			e = json.Unmarshal([]byte(jsonStr), &jsonMap)
-			if e == nil {
-				jsonMap = convertFloatToInt(jsonMap).(map[string]interface{})
-				for key, value := range jsonMap {
-					obj[key] = value
-				}
-			}
-		}
-		objs = append(objs, obj)
 	}
-	that.Mysql_free_query(rows)
-
-	// check constraints
-	if that.CheckConstraints {
-		e = that.CheckSortColumnConstraint(querySpec, whereSpec)
-		if e == nil {
-			e = that.CheckJsonColumnConstraint(querySpec, whereSpec)
-		}
-		if e != nil {
-			return nil, that.Fail(e, "post-check: " + e.Error(), "", nil)
+	// add non-SQL JSON data
+	if (json_field != "") && (row[json_field] != nil) {
+		jsonMap := map[string]interface{}{}
+		jsonStr, _ := row[json_field].(string)
+		if e := json.Unmarshal([]byte(jsonStr), &jsonMap); e == nil {
+			jsonMap = convertFloatToInt(jsonMap).(map[string]interface{})
+			for key, value := range jsonMap {
+				obj[key] = value
+			}
 		}
 	}
-
-	return
+	return obj
 }
 
 func (that XibDb) ReadRows(querySpec interface{}, whereSpec interface{}, columnsSpec interface{}, onSpec interface{}) (rowsStr string, e error) {
@@ -399,6 +490,19 @@ func (that XibDb) ReadRows(querySpec interface{}, whereSpec interface{}, columns
 	return
 }
 
+/**
+ * Get JSON table rows from the database with a
+ * context that can cancel the query.
+ * The other parameters are the same as ReadRowsNative().
+ *
+ * @param ctx Context The context for the queries.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) ReadRowsNativeContext(ctx context.Context, querySpec interface{}, whereSpec interface{}, columnsSpec interface{}, onSpec interface{}) ([]map[string]interface{}, error) {
+	return that.WithContext(ctx).ReadRowsNative(querySpec, whereSpec, columnsSpec, onSpec)
+}
+
 /**
  * Get a JSON table description from the database.
  *
@@ -437,34 +541,22 @@ func (that XibDb) ReadDescNative(querySpec interface{}) (desc map[string]interfa
 		sort_column := ""
 		json_column := ""
 		auto_increment_column := ""
-		q := "DESCRIBE `" + tableStr + "`;"
+		q := that.Dialect.DescribeQuery(tableStr)
 		params := map[string]interface{}{}
 		rows, e, _ := that.Mysql_query(q, params)
 		if e != nil {
 			return nil, that.Fail(e, "", q, nil)
 		}
 		for rowdesc := that.Mysql_fetch_assoc(rows); rowdesc != nil; rowdesc = that.Mysql_fetch_assoc(rows) {
-			field, _ := rowdesc["Field"].(string)
-			typ, _ := rowdesc["Type"].(string)
-			extra, _ := rowdesc["Extra"].(string)
+			field, typ, autoIncrement := that.Dialect.DescribeColumn(rowdesc)
 			if field == config["sort_column"] {
 				sort_column = field
 			} else if field == config["json_column"] {
 				json_column = field
-			} else if that.MapBool && strings.Contains(typ, "tinyint(1)") {
-				desc[field] = false
-			} else if strings.Contains(typ, "int") {
-				desc[field] = 0
-			} else if strings.Contains(typ, "float") {
-				desc[field] = floatval(0)
-			} else if strings.Contains(typ, "double") {
-				desc[field] = doubleval(0)
-			} else if strings.Contains(typ, "datetime") {
-				desc[field], _ = time.Parse("2006-01-02 15:04:05", "1970-01-01 00:00:00")
 			} else {
-				desc[field] = ""
+				desc[field] = that.Dialect.ColumnDefault(typ, that.MapBool)
 			}
-			if extra == "auto_increment" {
+			if autoIncrement {
 				auto_increment_column = field
 			}
 		}
@@ -513,6 +605,19 @@ func (that XibDb) ReadDesc(querySpec interface{}) (descStr string, e error) {
 	return
 }
 
+/**
+ * Get a JSON table description from the database
+ * with a context that can cancel the query.
+ * The other parameters are the same as ReadDescNative().
+ *
+ * @param ctx Context The context for the queries.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) ReadDescNativeContext(ctx context.Context, querySpec interface{}) (map[string]interface{}, error) {
+	return that.WithContext(ctx).ReadDescNative(querySpec)
+}
+
 /**
  * Insert a row of JSON into a database table.
  *
@@ -581,7 +686,7 @@ func (that XibDb) InsertRowNative(querySpec interface{}, whereSpec interface{},
 	auto_increment_field, _ := descMap["auto_increment_column"].(string)
 	orderByStr := ""
 	if sort_field != "" {
-		orderByStr = " ORDER BY `" + sort_field + "` DESC"
+		orderByStr = " ORDER BY " + that.quote(sort_field) + " DESC"
 	}
 
 	// decode remaining ambiguous arguments
@@ -607,6 +712,10 @@ func (that XibDb) InsertRowNative(querySpec interface{}, whereSpec interface{},
 				if _, ok := desc[col].(string); ok {
 					compat = true
 				}
+				// NULL is a valid value for any column
+				if jsonMap[col] == nil {
+					compat = true
+				}
 				if compat {
 					sqlValuesMap[col] = jsonMap[col]
 					delete(jsonMap, col)
@@ -619,9 +728,10 @@ func (that XibDb) InsertRowNative(querySpec interface{}, whereSpec interface{},
 		}
 	}
 	nInt, _ := n.(int)
+	params := map[string]interface{}{}
 	whereStr, _ := where.(string)
 	if whereMap, ok := where.(map[string]interface{}); ok { // is_map
-		whereStr = that.ImplementWhere(whereMap)
+		whereStr = that.implementWhere(whereMap, params)
 	}
 	if (whereStr != "") && !strings.HasPrefix(whereStr, " ") {
 		whereStr = " WHERE " + whereStr
@@ -631,15 +741,20 @@ func (that XibDb) InsertRowNative(querySpec interface{}, whereSpec interface{},
 		limitStr = " LIMIT 1"
 	}
 
-	transaction, _ := that.Xibdb_begin()
+	transaction, e := that.Xibdb_begin()
+	if e != nil {
+		return nil, that.Fail(e, "", "", nil)
+	}
+	if tx, ok := transaction.(*sql.Tx); ok {
+		that.tx = tx
+	}
 
 	qa := []string{}
-	params := map[string]interface{}{}
 
 	// update the positions
 	if sort_field != "" {
 		nLen := 0
-		q := "SELECT `" + sort_field + "` FROM `" + tableStr + "`" + whereStr + orderByStr + limitStr + ";"
+		q := "SELECT " + that.quote(sort_field) + " FROM " + that.quote(tableStr) + whereStr + orderByStr + limitStr + ";"
 		qr_reorder, e, _ := that.Mysql_query(q, params)
 		if e != nil {
 			return nil, that.Fail(e, "", q, transaction)
@@ -661,15 +776,15 @@ func (that XibDb) InsertRowNative(querySpec interface{}, whereSpec interface{},
 				andStr = " AND "
 			}
 			if that.Opt {
-				setStr += " SET `" + sort_field + "`=`" + sort_field + "`+1"
-				andStr += "`" + sort_field + "`>=" + strconv.Itoa(nInt)
-				q := "UPDATE `" + tableStr + "`" + setStr + whereStr + andStr + ";"
+				setStr += " SET " + that.quote(sort_field) + "=" + that.quote(sort_field) + "+1"
+				andStr += that.quote(sort_field) + ">=" + strconv.Itoa(nInt)
+				q := "UPDATE " + that.quote(tableStr) + setStr + whereStr + andStr + ";"
 				qa = append(qa, q)
 				break
 			} else {
-				setStr += " SET `" + sort_field + "`=" + strconv.Itoa(nValue+1)
-				andStr += " `" + sort_field + "`=" + strconv.Itoa(nValue)
-				q := "UPDATE `" + tableStr + "`" + setStr + whereStr + andStr + ";"
+				setStr += " SET " + that.quote(sort_field) + "=" + strconv.Itoa(nValue+1)
+				andStr += " " + that.quote(sort_field) + "=" + strconv.Itoa(nValue)
+				q := "UPDATE " + that.quote(tableStr) + setStr + whereStr + andStr + ";"
 				qa = append(qa, q)
 			}
 		}
@@ -678,7 +793,7 @@ func (that XibDb) InsertRowNative(querySpec interface{}, whereSpec interface{},
 			nInt = 0
 		}
 		if nInt > nLen {
-			return nil, that.Fail(e, "`n` value out of range", q, nil)
+			return nil, that.Fail(e, "`n` value out of range", q, transaction)
 		}
 
 		// add sort field to sqlValuesMap
@@ -691,10 +806,14 @@ func (that XibDb) InsertRowNative(querySpec interface{}, whereSpec interface{},
 	if len(sqlValuesMap) > 0 {
 		colsStr := ""
 		for col, value := range sqlValuesMap {
+			// let the database generate the auto_increment value
+			if (col == auto_increment_field) && (fmt.Sprintf("%v", value) == "0") {
+				continue
+			}
 			if colsStr != "" {
 				colsStr += ","
 			}
-			colsStr += "`" + that.Mysql_real_escape_string(col) + "`"
+			colsStr += that.quote(col)
 			if valuesStr != "" {
 				valuesStr += ","
 			}
@@ -705,15 +824,20 @@ func (that XibDb) InsertRowNative(querySpec interface{}, whereSpec interface{},
 		valuesStr = " (" + colsStr + ") VALUES (" + valuesStr + ")"
 	}
 
-	q := "INSERT INTO `" + tableStr + "`" + valuesStr + ";"
+	returningStr := ""
+	if auto_increment_field != "" {
+		returningStr = that.Dialect.Returning(auto_increment_field)
+	}
+	q := "INSERT INTO " + that.quote(tableStr) + valuesStr + returningStr + ";"
 	qa = append(qa, q)
 
 	var qr *sql.Result = nil
+	var insertId interface{} = nil
 
 	for _, q := range qa {
 		var rows* sql.Rows = nil
 		isInsert := strings.HasPrefix(q, "INSERT INTO ")
-		if isInsert {
+		if isInsert && (returningStr == "") {
 			qr, e, _ = that.Mysql_exec(q, params)
 		} else {
 			rows, e, _ = that.Mysql_query(q, params)
@@ -721,12 +845,17 @@ func (that XibDb) InsertRowNative(querySpec interface{}, whereSpec interface{},
 		if e != nil {
 			return nil, that.Fail(e, "", q, transaction)
 		}
-		if !isInsert {
-			that.Mysql_free_query(rows)
+		if isInsert && (rows != nil) {
+			if row := that.Mysql_fetch_assoc(rows); row != nil {
+				insertId = intval(row[auto_increment_field])
+			}
 		}
+		that.Mysql_free_query(rows)
 	}
 
-	if (auto_increment_field != "") && (valuesMap != nil) && (qr != nil) {
+	if (auto_increment_field != "") && (valuesMap != nil) && (insertId != nil) {
+		valuesMap[auto_increment_field] = insertId
+	} else if (auto_increment_field != "") && (valuesMap != nil) && (qr != nil) {
 		valuesMap[auto_increment_field], e = that.Mysql_insert_id(qr)
 		if e != nil {
 			return nil, that.Fail(e, "", q, transaction)
@@ -735,7 +864,13 @@ func (that XibDb) InsertRowNative(querySpec interface{}, whereSpec interface{},
 
 	that.Mysql_free_exec(qr)
 
-	that.Xibdb_commit(transaction)
+	e = that.Xibdb_commit(transaction)
+	if e != nil {
+		return nil, that.Fail(e, "", "", nil)
+	}
+	if transaction != nil {
+		that.tx = nil
+	}
 
 	// check constraints
 	if that.CheckConstraints {
@@ -764,6 +899,19 @@ func (that XibDb) InsertRow(querySpec interface{}, whereSpec interface{}, values
 	return
 }
 
+/**
+ * Insert a row of JSON into a database table with
+ * a context that can cancel the queries.
+ * The other parameters are the same as InsertRowNative().
+ *
+ * @param ctx Context The context for the queries.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) InsertRowNativeContext(ctx context.Context, querySpec interface{}, whereSpec interface{}, valuesSpec interface{}, nSpec interface{}) (map[string]interface{}, error) {
+	return that.WithContext(ctx).InsertRowNative(querySpec, whereSpec, valuesSpec, nSpec)
+}
+
 /**
  * Delete a row of JSON from a database table.
  *
@@ -824,7 +972,13 @@ func (that XibDb) DeleteRowNative(querySpec interface{}, whereSpec interface{},
 	descMap := that.cache[tableStr].(map[string]interface{})
 	sort_field, _ := descMap["sort_column"].(string)
 
-	transaction, _ := that.Xibdb_begin()
+	transaction, e := that.Xibdb_begin()
+	if e != nil {
+		return that.Fail(e, "", "", nil)
+	}
+	if tx, ok := transaction.(*sql.Tx); ok {
+		that.tx = tx
+	}
 
 	// decode remaining ambiguous arguments
 	params := map[string]interface{}{}
@@ -832,7 +986,7 @@ func (that XibDb) DeleteRowNative(querySpec interface{}, whereSpec interface{},
 	nStr, _ := n.(string)
 	whereStr, _ := where.(string)
 	if whereMap, ok := where.(map[string]interface{}); ok { // is_map
-		whereStr = that.ImplementWhere(whereMap)
+		whereStr = that.implementWhere(whereMap, params)
 	}
 	if (whereStr != "") && !strings.HasPrefix(whereStr, " ") {
 		whereStr = " WHERE " + whereStr
@@ -843,7 +997,7 @@ func (that XibDb) DeleteRowNative(querySpec interface{}, whereSpec interface{},
 		if whereStr != "" {
 			opStr = " AND "
 		}
-		andStr += opStr + "`" + sort_field + "`=" + strconv.Itoa(nInt)
+		andStr += opStr + that.quote(sort_field) + "=" + strconv.Itoa(nInt)
 	} else {
 		if nInt == -1 {
 			andStr = ""
@@ -870,9 +1024,9 @@ func (that XibDb) DeleteRowNative(querySpec interface{}, whereSpec interface{},
 		}
 		orderByStr := ""
 		if sort_field != "" {
-			orderByStr = " ORDER BY `" + sort_field + "` DESC"
+			orderByStr = " ORDER BY " + that.quote(sort_field) + " DESC"
 		}
-		q := "SELECT COUNT(*) AS num_rows FROM `" + tableStr + "`" + whereStr + andStr + orderByStr + ";"
+		q := "SELECT COUNT(*) AS num_rows FROM " + that.quote(tableStr) + whereStr + andStr + orderByStr + ";"
 		qr, e, _ := that.Mysql_query(q, params)
 		if e != nil {
 			return that.Fail(e, "", q, transaction)
@@ -887,9 +1041,9 @@ func (that XibDb) DeleteRowNative(querySpec interface{}, whereSpec interface{},
 		that.Mysql_free_query(qr)
 		quotedField := field
 		if field != "*" {
-			quotedField = "`" + field + "`"
+			quotedField = that.quote(field)
 		}
-		q = "SELECT " + quotedField + " FROM `" + tableStr + "`" + whereStr + andStr + orderByStr + ";"
+		q = "SELECT " + quotedField + " FROM " + that.quote(tableStr) + whereStr + andStr + orderByStr + ";"
 		// verify that non-standard n var yields valid rows
 		if num_rows == 1 {
 			qr, e, _ = that.Mysql_query(q, params)
@@ -906,7 +1060,7 @@ func (that XibDb) DeleteRowNative(querySpec interface{}, whereSpec interface{},
 			} else {
 				andStr += " AND "
 			}
-			andStr += "`" + sort_field + "`=" + strconv.Itoa(nInt)
+			andStr += that.quote(sort_field) + "=" + strconv.Itoa(nInt)
 		} else {
 			return that.Fail(nil, "xibdb.DeleteRow():num_rows:" + strconv.Itoa(num_rows), q, transaction)
 		}
@@ -918,13 +1072,13 @@ func (that XibDb) DeleteRowNative(querySpec interface{}, whereSpec interface{},
 	// update the positions
 	if sort_field != "" {
 		nLen := 0
-		orderByStr := " ORDER BY `" + sort_field + "` ASC"
+		orderByStr := " ORDER BY " + that.quote(sort_field) + " ASC"
 		limitStr := ""
 		if that.Opt {
-			orderByStr = " ORDER BY `" + sort_field + "` DESC"
+			orderByStr = " ORDER BY " + that.quote(sort_field) + " DESC"
 			limitStr = " LIMIT 1"
 		}
-		q := "SELECT `" + sort_field + "` FROM `" + tableStr + "`" + whereStr + orderByStr + limitStr + ";"
+		q := "SELECT " + that.quote(sort_field) + " FROM " + that.quote(tableStr) + whereStr + orderByStr + limitStr + ";"
 		qr_reorder, e, _ := that.Mysql_query(q, params)
 		if e != nil {
 			return that.Fail(e, "", q, transaction)
@@ -940,25 +1094,25 @@ func (that XibDb) DeleteRowNative(querySpec interface{}, whereSpec interface{},
 				andSetStr = " AND "
 			}
 			if that.Opt {
-				setStr += " SET `" + sort_field + "`=`" + sort_field + "`-1"
-				andSetStr += "`" + sort_field + "`>=" + strconv.Itoa(nInt)
-				q = "UPDATE `" + tableStr + "`" + setStr + whereStr + andSetStr + ";"
+				setStr += " SET " + that.quote(sort_field) + "=" + that.quote(sort_field) + "-1"
+				andSetStr += that.quote(sort_field) + ">=" + strconv.Itoa(nInt)
+				q = "UPDATE " + that.quote(tableStr) + setStr + whereStr + andSetStr + ";"
 				qa = append(qa, q)
 				break
 			} else {
-				setStr += " SET `" + sort_field + "`=" + strconv.Itoa(nValue-1)
-				andSetStr += "`" + sort_field + "`=" + strconv.Itoa(nValue)
-				q = "UPDATE `" + tableStr + "`" + setStr + whereStr + andSetStr + ";"
+				setStr += " SET " + that.quote(sort_field) + "=" + strconv.Itoa(nValue-1)
+				andSetStr += that.quote(sort_field) + "=" + strconv.Itoa(nValue)
+				q = "UPDATE " + that.quote(tableStr) + setStr + whereStr + andSetStr + ";"
 				qa = append(qa, q)
 			}
 		}
 		that.Mysql_free_query(qr_reorder)
 		if nInt >= nLen {
-			return that.Fail(nil, "`n` value out of range", q, nil)
+			return that.Fail(nil, "`n` value out of range", q, transaction)
 		}
 	}
 
-	q := "DELETE FROM `" + tableStr + "`" + whereStr + andStr + ";"
+	q := "DELETE FROM " + that.quote(tableStr) + whereStr + andStr + ";"
 	qa = append([]string{q}, qa...)
 
 	for _, q := range qa {
@@ -969,7 +1123,13 @@ func (that XibDb) DeleteRowNative(querySpec interface{}, whereSpec interface{},
 		}
 	}
 
-	that.Xibdb_commit(transaction)
+	e = that.Xibdb_commit(transaction)
+	if e != nil {
+		return that.Fail(e, "", "", nil)
+	}
+	if transaction != nil {
+		that.tx = nil
+	}
 
 	// check constraints
 	if that.CheckConstraints {
@@ -989,6 +1149,19 @@ func (that XibDb) DeleteRow(querySpec interface{}, whereSpec interface{}, nSpec
 	return that.DeleteRowNative(querySpec, whereSpec, nSpec)
 }
 
+/**
+ * Delete a row of JSON from a database table with
+ * a context that can cancel the queries.
+ * The other parameters are the same as DeleteRowNative().
+ *
+ * @param ctx Context The context for the queries.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) DeleteRowNativeContext(ctx context.Context, querySpec interface{}, whereSpec interface{}, nSpec interface{}) error {
+	return that.WithContext(ctx).DeleteRowNative(querySpec, whereSpec, nSpec)
+}
+
 /**
  * Update a row of JSON in a database table.
  *
@@ -1059,7 +1232,7 @@ func (that XibDb) UpdateRowNative(querySpec interface{}, whereSpec interface{},
 	json_field, _ := descMap["json_column"].(string)
 	orderByStr := ""
 	if sort_field != "" {
-		orderByStr = " ORDER BY `" + sort_field + "` ASC"
+		orderByStr = " ORDER BY " + that.quote(sort_field) + " ASC"
 	}
 
 	// decode remaining ambiguous arguments
@@ -1086,6 +1259,10 @@ func (that XibDb) UpdateRowNative(querySpec interface{}, whereSpec interface{},
 					if _, ok := desc[col].(string); ok {
 						compat = true
 					}
+					// NULL is a valid value for any column
+					if jsonMap[col] == nil {
+						compat = true
+					}
 					if compat {
 						sqlValuesMap[col] = jsonMap[col]
 						delete(jsonMap, col)
@@ -1097,9 +1274,10 @@ func (that XibDb) UpdateRowNative(querySpec interface{}, whereSpec interface{},
 	updateJson := (json_field != "") && (len(jsonMap) > 0)
 	nInt, _ := n.(int)
 	limitInt, _ := limit.(int)
+	params := map[string]interface{}{}
 	whereStr, _ := where.(string)
 	if whereMap, ok := where.(map[string]interface{}); ok { // is_map
-		whereStr = that.ImplementWhere(whereMap)
+		whereStr = that.implementWhere(whereMap, params)
 	}
 	if (whereStr != "") && !strings.HasPrefix(whereStr, " ") {
 		whereStr = " WHERE " + whereStr
@@ -1110,14 +1288,19 @@ func (that XibDb) UpdateRowNative(querySpec interface{}, whereSpec interface{},
 		if whereStr != "" {
 			andStr = " AND"
 		}
-		andStr += " `" + sort_field + "`=" + strconv.Itoa(nInt)
+		andStr += " " + that.quote(sort_field) + "=" + strconv.Itoa(nInt)
 	}
 
-	transaction, _ := that.Xibdb_begin()
+	transaction, e := that.Xibdb_begin()
+	if e != nil {
+		return nil, that.Fail(e, "", "", nil)
+	}
+	if tx, ok := transaction.(*sql.Tx); ok {
+		that.tx = tx
+	}
 
 	// get the number of rows_affected and save values
-	q := "SELECT * FROM `" + tableStr + "`" + whereStr + andStr + orderByStr + ";"
-	params := map[string]interface{}{}
+	q := "SELECT * FROM " + that.quote(tableStr) + whereStr + andStr + orderByStr + ";"
 	qr, e, _ := that.Mysql_query(q, params)
 	if e != nil {
 		return nil, that.Fail(e, "", q, transaction)
@@ -1136,6 +1319,7 @@ func (that XibDb) UpdateRowNative(querySpec interface{}, whereSpec interface{},
 			jsonRowMap := map[string]interface{}{}
 			e = json.Unmarshal([]byte(jsonValue), &jsonRowMap)
 			if e != nil {
+				that.Mysql_free_query(qr)
 				return nil, that.Fail(e, "\"" + that.Mysql_real_escape_string(row[json_field].(string)) + "\" value in `" + json_field + "` column in `" + tableStr + "` table; " + e.Error(), q, transaction)
 			}
 		}
@@ -1145,29 +1329,28 @@ func (that XibDb) UpdateRowNative(querySpec interface{}, whereSpec interface{},
 
 	if rows_affected == 0 {
 		if andStr == "" {
-			return nil, that.Fail(nil, "0 rows affected", q, nil)
+			return nil, that.Fail(nil, "0 rows affected", q, transaction)
 		}
-		q = "SELECT COUNT(*) AS rows_affected FROM `" + tableStr + "`" + whereStr + ";"
+		q = "SELECT COUNT(*) AS rows_affected FROM " + that.quote(tableStr) + whereStr + ";"
 		qr, _, _ = that.Mysql_query(q, params)
 		for qr.Next() {
 			qr.Scan(&rows_affected)
 		}
 		that.Mysql_free_query(qr)
 		if rows_affected > 0 {
-			return nil, that.Fail(nil, "`n` value out of range", "", nil)
+			return nil, that.Fail(nil, "`n` value out of range", "", transaction)
 		} else {
-			return nil, that.Fail(nil, "0 rows affected", "", nil)
+			return nil, that.Fail(nil, "0 rows affected", "", transaction)
 		}
-		return nil, e
 	} else if (limitInt != -1) && (rows_affected > limitInt) {
-		return nil, that.Fail(nil, strconv.Itoa(rows_affected) + " rows affected but limited to " + strconv.Itoa(limitInt) + " rows", "", nil)
+		return nil, that.Fail(nil, strconv.Itoa(rows_affected) + " rows affected but limited to " + strconv.Itoa(limitInt) + " rows", "", transaction)
 	}
 
 	qa := []string{}
 
 	// generate UPDATE statements using json_field
 	if valuesStr, ok := values.(string); ok {
-		q := "UPDATE `" + tableStr + "`" + valuesStr + whereStr + andStr + ";"
+		q := "UPDATE " + that.quote(tableStr) + valuesStr + whereStr + andStr + ";"
 		qa = append(qa, q)
 	} else {
 		for _, sqlRowMap := range sqlRowMaps {
@@ -1196,25 +1379,25 @@ func (that XibDb) UpdateRowNative(querySpec interface{}, whereSpec interface{},
 					if valuesRow != " SET " {
 						valuesRow += ", "
 					}
-					valuesRow += "`" + that.Mysql_real_escape_string(col) + "`=" + param
+					valuesRow += that.quote(col) + "=" + param
 				}
 			}
 			// construct WHERE clause
 			whereRow := " WHERE "
 			for col, value := range sqlRowMap {
 				param := "{{{" + that.paramRand + "--where--" + strconv.Itoa(len(qa)) + "--" + col + "}}}"
-				opStr := "="
+				condStr := that.quote(col) + "=" + param
 				if is_numeric(value) && is_float(desc[col]) {
-					opStr = " LIKE "
+					condStr = that.Dialect.CompareFloat(that.quote(col), param)
 				}
 				params[param] = value
 				if whereRow != " WHERE " {
 					whereRow += " AND "
 				}
-				whereRow += "`" + that.Mysql_real_escape_string(col) + "`" + opStr + param
+				whereRow += condStr
 			}
 			if valuesRow != " SET " {
-				q = "UPDATE `" + tableStr + "`" + valuesRow + whereRow + " LIMIT 1;"
+				q = "UPDATE " + that.quote(tableStr) + valuesRow + whereRow + that.Dialect.UpdateLimit() + ";"
 				qa = append(qa, q)
 			}
 		}
@@ -1228,7 +1411,13 @@ func (that XibDb) UpdateRowNative(querySpec interface{}, whereSpec interface{},
 		that.Mysql_free_query(rows)
 	}
 
-	that.Xibdb_commit(transaction)
+	e = that.Xibdb_commit(transaction)
+	if e != nil {
+		return nil, that.Fail(e, "", "", nil)
+	}
+	if transaction != nil {
+		that.tx = nil
+	}
 
 	// check constraints
 	if that.CheckConstraints {
@@ -1257,6 +1446,19 @@ func (that XibDb) UpdateRow(querySpec interface{}, whereSpec interface{}, nSpec
 	return
 }
 
+/**
+ * Update a row of JSON in a database table with a
+ * context that can cancel the queries.
+ * The other parameters are the same as UpdateRowNative().
+ *
+ * @param ctx Context The context for the queries.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) UpdateRowNativeContext(ctx context.Context, querySpec interface{}, whereSpec interface{}, valuesSpec interface{}, nSpec interface{}, limitSpec interface{}) (map[string]interface{}, error) {
+	return that.WithContext(ctx).UpdateRowNative(querySpec, whereSpec, valuesSpec, nSpec, limitSpec)
+}
+
 /**
  * Reorder a row of JSON in a database table.
  *
@@ -1321,7 +1523,7 @@ func (that XibDb) MoveRowNative(querySpec interface{}, whereSpec interface{}, mS
 	sort_field, _ := descMap["sort_column"].(string)
 	orderByStr := ""
 	if sort_field != "" {
-		orderByStr = " ORDER BY `" + sort_field + "` DESC"
+		orderByStr = " ORDER BY " + that.quote(sort_field) + " DESC"
 	} else {
 		return that.Fail(nil, tableStr + " does not have a sort_field", "", nil)
 	}
@@ -1332,10 +1534,11 @@ func (that XibDb) MoveRowNative(querySpec interface{}, whereSpec interface{}, mS
 	}
 
 	// decode remaining ambiguous arguments
+	params := map[string]interface{}{}
 	whereStr, _ := where.(string)
 	if whereMap, ok := where.(map[string]interface{}); ok { // is_map
 		whereMap = that.ApplyTablesToWhere(whereMap, tableStr)
-		whereStr = that.ImplementWhere(whereMap)
+		whereStr = that.implementWhere(whereMap, params)
 	}
 	if (whereStr != "") && !strings.HasPrefix(whereStr, " ") {
 		whereStr = " WHERE " + whereStr
@@ -1348,11 +1551,16 @@ func (that XibDb) MoveRowNative(querySpec interface{}, whereSpec interface{}, mS
 		}
 	}
 
-	transaction, _ := that.Xibdb_begin()
+	transaction, e := that.Xibdb_begin()
+	if e != nil {
+		return that.Fail(e, "", "", nil)
+	}
+	if tx, ok := transaction.(*sql.Tx); ok {
+		that.tx = tx
+	}
 
 	// get the length of the array
-	q := "SELECT `" + sort_field + "` FROM `" + tableStr + "`" + whereStr + orderByStr + limitStr + ";"
-	params := map[string]interface{}{}
+	q := "SELECT " + that.quote(sort_field) + " FROM " + that.quote(tableStr) + whereStr + orderByStr + limitStr + ";"
 	qr_end, e, _ := that.Mysql_query(q, params)
 	if e != nil {
 		return that.Fail(e, "", q, transaction)
@@ -1363,53 +1571,53 @@ func (that XibDb) MoveRowNative(querySpec interface{}, whereSpec interface{}, mS
 	}
 	that.Mysql_free_query(qr_end)
 	if (m < 0) || (m >= nLen) {
-		return that.Fail(nil, "`m` value out of range", q, nil)
+		return that.Fail(nil, "`m` value out of range", q, transaction)
 	}
 	if (n < 0) || (n >= nLen) {
-		return that.Fail(nil, "`n` value out of range", q, nil)
+		return that.Fail(nil, "`n` value out of range", q, transaction)
 	}
 
 	qa := []string{}
 
 	// save the row at the m-th to the end
-	setStr := " SET `" + sort_field + "`=" + strconv.Itoa(nLen)
-	andStr := opStr + " `" + sort_field + "`=" + strconv.Itoa(m)
-	q = "UPDATE `" + tableStr + "`" + setStr + whereStr + andStr + ";"
+	setStr := " SET " + that.quote(sort_field) + "=" + strconv.Itoa(nLen)
+	andStr := opStr + " " + that.quote(sort_field) + "=" + strconv.Itoa(m)
+	q = "UPDATE " + that.quote(tableStr) + setStr + whereStr + andStr + ";"
 	qa = append(qa, q)
 
 	// update the indices between m and n
 	if that.Opt {
 		if m < n {
-			setStr = " SET `" + sort_field + "`=`" + sort_field + "`-1"
-			andStr = opStr + " `" + sort_field + "`>" + strconv.Itoa(m) + " AND `" + sort_field + "`<=" + strconv.Itoa(n)
+			setStr = " SET " + that.quote(sort_field) + "=" + that.quote(sort_field) + "-1"
+			andStr = opStr + " " + that.quote(sort_field) + ">" + strconv.Itoa(m) + " AND " + that.quote(sort_field) + "<=" + strconv.Itoa(n)
 		} else {
-			setStr = " SET `" + sort_field + "`=`" + sort_field + "`+1"
-			andStr = opStr + " `" + sort_field + "`>=" + strconv.Itoa(n) + " AND `" + sort_field + "`<" + strconv.Itoa(m)
+			setStr = " SET " + that.quote(sort_field) + "=" + that.quote(sort_field) + "+1"
+			andStr = opStr + " " + that.quote(sort_field) + ">=" + strconv.Itoa(n) + " AND " + that.quote(sort_field) + "<" + strconv.Itoa(m)
 		}
-		q = "UPDATE `" + tableStr + "`" + setStr + whereStr + andStr + ";"
+		q = "UPDATE " + that.quote(tableStr) + setStr + whereStr + andStr + ";"
 		qa = append(qa, q)
 	} else {
 		if m < n {
 			for i := m; i < n; i++ {
-				setStr := " SET `" + sort_field + "`=" + strconv.Itoa(i)
-				andStr = opStr + " `" + sort_field + "`=" + strconv.Itoa(i+1)
-				q = "UPDATE `" + tableStr + "`" + setStr + whereStr + andStr + ";"
+				setStr := " SET " + that.quote(sort_field) + "=" + strconv.Itoa(i)
+				andStr = opStr + " " + that.quote(sort_field) + "=" + strconv.Itoa(i+1)
+				q = "UPDATE " + that.quote(tableStr) + setStr + whereStr + andStr + ";"
 				qa = append(qa, q)
 			}
 		} else {
 			for i := m - 1; i >= n; i-- {
-				setStr := " SET `" + sort_field + "`=" + strconv.Itoa(i+1)
-				andStr = opStr + " `" + sort_field + "`=" + strconv.Itoa(i)
-				q = "UPDATE `" + tableStr + "`" + setStr + whereStr + andStr + ";"
+				setStr := " SET " + that.quote(sort_field) + "=" + strconv.Itoa(i+1)
+				andStr = opStr + " " + that.quote(sort_field) + "=" + strconv.Itoa(i)
+				q = "UPDATE " + that.quote(tableStr) + setStr + whereStr + andStr + ";"
 				qa = append(qa, q)
 			}
 		}
 	}
 
 	// copy the row at the end to the n-th position
-	setStr = " SET `" + sort_field + "`=" + strconv.Itoa(n)
-	andStr = opStr + " `" + sort_field + "`=" + strconv.Itoa(nLen)
-	q = "UPDATE `" + tableStr + "`" + setStr + whereStr + andStr + ";"
+	setStr = " SET " + that.quote(sort_field) + "=" + strconv.Itoa(n)
+	andStr = opStr + " " + that.quote(sort_field) + "=" + strconv.Itoa(nLen)
+	q = "UPDATE " + that.quote(tableStr) + setStr + whereStr + andStr + ";"
 	qa = append(qa, q)
 
 	for _, q := range qa {
@@ -1420,7 +1628,13 @@ func (that XibDb) MoveRowNative(querySpec interface{}, whereSpec interface{}, mS
 		that.Mysql_free_query(rows)
 	}
 
-	that.Xibdb_commit(transaction)
+	e = that.Xibdb_commit(transaction)
+	if e != nil {
+		return that.Fail(e, "", "", nil)
+	}
+	if transaction != nil {
+		that.tx = nil
+	}
 
 	// check constraints
 	if that.CheckConstraints {
@@ -1440,6 +1654,46 @@ func (that XibDb) MoveRow(querySpec interface{}, whereSpec interface{}, mSpec in
 	return that.MoveRowNative(querySpec, whereSpec, mSpec, nSpec)
 }
 
+/**
+ * Reorder a row of JSON in a database table with a
+ * context that can cancel the queries.
+ * The other parameters are the same as MoveRowNative().
+ *
+ * @param ctx Context The context for the queries.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) MoveRowNativeContext(ctx context.Context, querySpec interface{}, whereSpec interface{}, mSpec interface{}, nSpec interface{}) error {
+	return that.WithContext(ctx).MoveRowNative(querySpec, whereSpec, mSpec, nSpec)
+}
+
+/**
+ * Return a copy of this object that runs its
+ * queries with a context so they are cancelled
+ * when the context is done.
+ *
+ * @param ctx Context The context for the queries.
+ * @return XibDb A copy that uses the context.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) WithContext(ctx context.Context) *XibDb {
+	that.ctx = ctx
+	return &that
+}
+
+/**
+ * Return the context for the queries.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) queryContext() context.Context {
+	if that.ctx != nil {
+		return that.ctx
+	}
+	return context.Background()
+}
+
 /**
  * Flexible mysql_query() function.
  *
@@ -1450,41 +1704,15 @@ func (that XibDb) MoveRow(querySpec interface{}, whereSpec interface{}, mSpec in
  * @author DanielWHoward
  */
 func (that XibDb) Mysql_query(query string, a map[string]interface{}) (rows *sql.Rows, e error, columnNames []string) {
-/*	parameterizedQuery := true*/
-	ordinaryQuery := true
-	var link_identifier *sql.DB = nil
 	// convert to real parameterized query
-	placeholder := "?"
-	paramTypes := ""
-	paramQuery := query
-	paramValues := []interface{}{}
-	for aKey, aValue := range a {
-		i := strings.Index(paramQuery, aKey)
-		if i != -1 {
-			if aValueMap, ok := aValue.(map[string]interface{}); ok {
-				jsonBytes, _ := json.Marshal(aValueMap)
-				jsonStr := string(jsonBytes)
-				if (jsonStr == "") || (jsonStr == "[]") {
-					jsonStr = "{}"
-				}
-				aValue = jsonStr
-			} else if valueBool, ok := aValue.(bool); that.MapBool && ok {
-				if valueBool {
-					aValue = 1
-				} else {
-					aValue = 0
-				}
-			} else if aValueTime, ok := aValue.(time.Time); ok {
-				aValue = aValueTime.Format("2006-01-02 15:04:05")
-			}
-			paramTypes += "s"
-			paramValues = append(paramValues, aValue)
-			paramQuery = strings.ReplaceAll(paramQuery, aKey, placeholder)
-		}
+	paramQuery, paramValues := that.Xibdb_bind_query(query, a)
+	if that.FlattenQueries {
+		paramQuery = that.Xibdb_flatten_query(query, a)
+		paramValues = []interface{}{}
 	}
 	if that.DumpSql || that.DryRun {
 		if len(paramValues) == 0 {
-			that.log.Println(query)
+			that.log.Println(paramQuery)
 		} else {
 			jsonBytes, _ := json.Marshal(paramValues)
 			jsonStr := string(jsonBytes)
@@ -1492,30 +1720,54 @@ func (that XibDb) Mysql_query(query string, a map[string]interface{}) (rows *sql
 		}
 	}
 	columnNames = []string{}
-	if that.DryRun && !strings.HasPrefix(query, "SELECT ") && !strings.HasPrefix(query, "DESCRIBE ") {
+	if that.DryRun && !strings.HasPrefix(query, "SELECT ") && !strings.HasPrefix(query, "DESCRIBE ") && !strings.HasPrefix(query, "PRAGMA ") {
 		return
 	}
 	// execute parameterized or ordinary query
-	if link, ok := that.config["link_identifier"].(*sql.DB); ok {
-		link_identifier = link
-		if ordinaryQuery && (e == nil) {
-			query = that.Xibdb_flatten_query(query, a)
-			rows, e = link_identifier.Query(query)
-			if e == nil {
-				columnNames, _ = rows.Columns()
-			}
+	if !that.returnsRows(query) {
+		// some drivers only run a query when its rows are read
+		if that.tx != nil {
+			_, e = that.tx.ExecContext(that.queryContext(), paramQuery, paramValues...)
+		} else if link, ok := that.config["link_identifier"].(*sql.DB); ok {
+			_, e = link.ExecContext(that.queryContext(), paramQuery, paramValues...)
 		}
+	} else if that.tx != nil {
+		rows, e = that.tx.QueryContext(that.queryContext(), paramQuery, paramValues...)
+	} else if link, ok := that.config["link_identifier"].(*sql.DB); ok {
+		rows, e = link.QueryContext(that.queryContext(), paramQuery, paramValues...)
+	}
+	if (e == nil) && (rows != nil) {
+		columnNames, _ = rows.Columns()
 	}
 	// fail on error
 	if e != nil {
 		if !that.DumpSql && !that.DryRun {
-			that.log.Println(query)
+			that.log.Println(paramQuery)
 		}
 		that.log.Println(e.Error())
 	}
 	return
 }
 
+/**
+ * Return true if a query returns rows.
+ *
+ * @param {string} query The query to execute.
+ * @return {bool} True for SELECT-like queries.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) returnsRows(query string) bool {
+	keywords := []string{"SELECT", "DESCRIBE", "SHOW", "PRAGMA", "WITH", "EXPLAIN"}
+	upper := strings.ToUpper(strings.TrimSpace(query))
+	for _, keyword := range keywords {
+		if strings.HasPrefix(upper, keyword) {
+			return true
+		}
+	}
+	return strings.Contains(upper, " RETURNING ")
+}
+
 /**
  * Renamed mysql_query() for INSERT queries so
  * mysql_insert_id() will work.
@@ -1527,38 +1779,21 @@ func (that XibDb) Mysql_query(query string, a map[string]interface{}) (rows *sql
  * @author DanielWHoward
  */
 func (that XibDb) Mysql_exec(query string, a map[string]interface{}) (*sql.Result, error, []string) {
-	var link_identifier *sql.DB = nil
 	var result sql.Result = nil
 	var e error = nil
 	// convert to real parameterized query
-/*	placeholder := "?"
-	paramTypes := ""
-	paramQuery := query
-	paramValues := []string{}
-	for aKey, aValue := range a {
-		i := strings.Index(paramQuery, aKey)
-		if i != -1 {
-			valueStr := aValue.(string)
-			if aValueMap, ok := aValue.(map[string]interface{}); ok {
-				jsonBytes, _ := json.Marshal(aValueMap)
-				jsonStr := string(jsonBytes)
-				if (jsonStr == "") || (jsonStr == "[]") {
-					jsonStr = "{}"
-				}
-				valueStr = jsonStr
-			}
-			paramTypes += "s"
-			paramValues = append(paramValues, valueStr)
-			query = strings.ReplaceAll(aKey, placeholder, paramQuery)
-		}
-	}*/
+	paramQuery, paramValues := that.Xibdb_bind_query(query, a)
+	if that.FlattenQueries {
+		paramQuery = that.Xibdb_flatten_query(query, a)
+		paramValues = []interface{}{}
+	}
 	if that.DumpSql || that.DryRun {
-		if len(a) == 0 {
-			that.log.Println(query)
+		if len(paramValues) == 0 {
+			that.log.Println(paramQuery)
 		} else {
-			jsonBytes, _ := json.Marshal(a)
+			jsonBytes, _ := json.Marshal(paramValues)
 			jsonStr := string(jsonBytes)
-			that.log.Println(query + " with params: " + jsonStr)
+			that.log.Println(paramQuery + " with params: " + jsonStr)
 		}
 	}
 	columnNames := []string{}
@@ -1566,13 +1801,15 @@ func (that XibDb) Mysql_exec(query string, a map[string]interface{}) (*sql.Resul
 		return nil, nil, columnNames
 	}
 	// execute parameterized or ordinary query
-	query = that.Xibdb_flatten_query(query, a)
-	link_identifier = (that.config["link_identifier"]).(*sql.DB)
-	result, e = link_identifier.Exec(query)
-	columnNames = []string{}
+	if that.tx != nil {
+		result, e = that.tx.ExecContext(that.queryContext(), paramQuery, paramValues...)
+	} else {
+		link_identifier := (that.config["link_identifier"]).(*sql.DB)
+		result, e = link_identifier.ExecContext(that.queryContext(), paramQuery, paramValues...)
+	}
 	if e != nil {
 		if !that.DumpSql && !that.DryRun {
-			that.log.Println(query)
+			that.log.Println(paramQuery)
 		}
 		that.log.Println(e.Error())
 	}
@@ -1598,11 +1835,23 @@ func (that XibDb) Mysql_fetch_assoc(rows *sql.Rows) (row map[string]interface{})
 		}
 		if e = rows.Scan(fields...); e == nil {
 			row = map[string]interface{}{}
+			// convert to strings like the MySQL text protocol
 			for i := 0; i < len(columnNames); i++ {
-				_, value := values[i].([]byte)
-				if value {
-					row[columnNames[i]] = string(values[i].([]byte))
-				} else {
+				switch value := values[i].(type) {
+				case []byte:
+					row[columnNames[i]] = string(value)
+				case int64:
+					row[columnNames[i]] = strconv.FormatInt(value, 10)
+				case float64:
+					row[columnNames[i]] = strconv.FormatFloat(value, 'f', -1, 64)
+				case bool:
+					row[columnNames[i]] = "0"
+					if value {
+						row[columnNames[i]] = "1"
+					}
+				case time.Time:
+					row[columnNames[i]] = value.Format("2006-01-02 15:04:05")
+				default:
 					row[columnNames[i]] = values[i]
 				}
 			}
@@ -1647,14 +1896,19 @@ func (that XibDb) Mysql_free_exec(result *sql.Result) {
  * @author DanielWHoward
  */
 func (that XibDb) Mysql_real_escape_string(unescaped_string string) (escaped_string string) {
-	escaped_string = strings.ReplaceAll(unescaped_string, "\\0", "\\x00")
-	escaped_string = strings.ReplaceAll(escaped_string, "\n", "\\n")
-	escaped_string = strings.ReplaceAll(escaped_string, "\r", "\\r")
-	escaped_string = strings.ReplaceAll(escaped_string, "\\", "\\\\")
-	escaped_string = strings.ReplaceAll(escaped_string, "'", "\\'")
-	escaped_string = strings.ReplaceAll(escaped_string, "\"", "\\\"")
-	escaped_string = strings.ReplaceAll(escaped_string, "\x1a", "\\\x1a")
-	return
+	return that.Dialect.EscapeString(unescaped_string)
+}
+
+/**
+ * Quote a table or column name for the dialect.
+ *
+ * @param {string} name A name like table or table.column.
+ * @return {string} The quoted name.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) quote(name string) string {
+	return that.Dialect.QuoteIdentifier(name)
 }
 
 /**
@@ -1678,6 +1932,19 @@ func (that XibDb) Mysql_insert_id(result *sql.Result) (int, error) {
  * @author DanielWHoward
  */
 func (that XibDb) Xibdb_begin() (transaction interface{}, e error) {
+	// join the transaction that is already in progress
+	if that.tx != nil {
+		return
+	}
+	if link, ok := that.config["link_identifier"].(*sql.DB); ok {
+		var tx *sql.Tx
+		tx, e = link.BeginTx(that.queryContext(), nil)
+		if e == nil {
+			transaction = tx
+		} else {
+			e = errors.New("Xibdb_begin() failed: " + e.Error())
+		}
+	}
 	return
 }
 
@@ -1687,9 +1954,88 @@ func (that XibDb) Xibdb_begin() (transaction interface{}, e error) {
  * @author DanielWHoward
  */
 func (that XibDb) Xibdb_commit(transaction interface{}) (e error) {
+	if tx, ok := transaction.(*sql.Tx); ok && (tx != nil) {
+		e = tx.Commit()
+		if e != nil {
+			e = errors.New("Xibdb_commit() failed: " + e.Error())
+		}
+	}
+	return
+}
+
+/**
+ * Return a parameterized query and its arguments.
+ *
+ * Each argument map name found in the query is
+ * replaced by a placeholder and its value is added
+ * to the argument list in the order that it appears
+ * in the query.
+ *
+ * @param query String The query to execute.
+ * @param a An argument map.
+ * @return The parameterized query and its arguments.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) Xibdb_bind_query(query string, a map[string]interface{}) (paramQuery string, paramValues []interface{}) {
+	paramValues = []interface{}{}
+	if len(a) == 0 {
+		return query, paramValues
+	}
+	prefix := "{{{" + that.paramRand
+	for {
+		i := strings.Index(query, prefix)
+		if i == -1 {
+			break
+		}
+		j := strings.Index(query[i:], "}}}")
+		if j == -1 {
+			break
+		}
+		name := query[i : i+j+3]
+		paramQuery += query[:i]
+		if value, ok := a[name]; ok {
+			paramQuery += that.Dialect.Placeholder(len(paramValues) + 1)
+			paramValues = append(paramValues, that.Xibdb_bind_value(value))
+		} else {
+			paramQuery += name
+		}
+		query = query[i+j+3:]
+	}
+	paramQuery += query
 	return
 }
 
+/**
+ * Return a value that database/sql can bind.
+ *
+ * Maps and lists become JSON strings, booleans
+ * become the dialect's boolean and times become
+ * DATETIME strings.
+ *
+ * @param value The argument map value.
+ * @return The bind argument.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) Xibdb_bind_value(value interface{}) interface{} {
+	_, isMap := value.(map[string]interface{})
+	_, isList := value.([]interface{})
+	if isMap || isList {
+		jsonBytes, _ := json.Marshal(value)
+		jsonStr := string(jsonBytes)
+		if (jsonStr == "") || (jsonStr == "[]") {
+			jsonStr = "{}"
+		}
+		return jsonStr
+	} else if valueBool, ok := value.(bool); ok {
+		return that.Dialect.Bool(valueBool)
+	} else if valueTime, ok := value.(time.Time); ok {
+		return valueTime.Format("2006-01-02 15:04:05")
+	}
+	return value
+}
+
 /**
  * Return query string with argument map appied.
  *
@@ -1698,7 +2044,8 @@ func (that XibDb) Xibdb_commit(transaction interface{}) (e error) {
  * xibdb to convert a query to a real parameterized
  * query in mysql_query().  But this method just does
  * a dumb substitution to create a query with escaped
- * strings.
+ * strings for drivers that cannot bind arguments; it
+ * is only used if "flattenQueries" is configured.
  *
  * @param query String The query to execute.
  * @param a An argument map.
@@ -1721,11 +2068,7 @@ func (that XibDb) Xibdb_flatten_query(query string, a map[string]interface{}) st
 			}
 			valueStr = "'" + that.Mysql_real_escape_string(jsonStr) + "'"
 		} else if valueBool, ok := value.(bool); ok {
-			if valueBool {
-				valueStr = "1"
-			} else {
-				valueStr = "0"
-			}
+			valueStr = fmt.Sprintf("%v", that.Dialect.Bool(valueBool))
 		} else if valueInt, ok := value.(int); ok {
 			valueStr = strconv.Itoa(int(valueInt))
 		} else if valueInt, ok := value.(int64); ok {
@@ -1754,6 +2097,54 @@ func (that XibDb) Xibdb_flatten_query(query string, a map[string]interface{}) st
  * @author DanielWHoward
  */
 func (that XibDb) Xibdb_rollback(transaction interface{}) (e error) {
+	if tx, ok := transaction.(*sql.Tx); ok && (tx != nil) {
+		e = tx.Rollback()
+		if (e != nil) && (e != sql.ErrTxDone) {
+			e = errors.New("Xibdb_rollback() failed: " + e.Error())
+			that.log.Println(e.Error())
+		}
+	}
+	return
+}
+
+/**
+ * Run several row operations in one database
+ * transaction.
+ *
+ * The callback receives a copy of this object that
+ * sends all queries through the transaction.  The
+ * transaction is committed if the callback returns
+ * nil and rolled back if it returns an error or
+ * panics.  Nested calls join the outer transaction.
+ *
+ * @param fn func A callback function.
+ * @return error The callback or commit error.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) WithTx(fn func(tx *XibDb) error) (e error) {
+	transaction, e := that.Xibdb_begin()
+	if e != nil {
+		return that.Fail(e, "", "", nil)
+	}
+	if tx, ok := transaction.(*sql.Tx); ok {
+		that.tx = tx
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			that.Xibdb_rollback(transaction)
+			panic(r)
+		}
+	}()
+	e = fn(&that)
+	if e != nil {
+		that.Xibdb_rollback(transaction)
+		return e
+	}
+	e = that.Xibdb_commit(transaction)
+	if e != nil {
+		return that.Fail(e, "", "", transaction)
+	}
 	return
 }
 
@@ -1794,23 +2185,23 @@ func (that XibDb) CheckSortColumnConstraint(querySpec interface{}, whereSpec int
 	sort_field, _ := descMap["sort_column"].(string)
 	orderByStr := ""
 	if sort_field != "" {
-		orderByStr = " ORDER BY `" + sort_field + "` ASC"
+		orderByStr = " ORDER BY " + that.quote(sort_field) + " ASC"
 	} else {
 		e = errors.New("CheckSortColumnConstraint(): " + tableStr + " does not contain `" + sort_field + "`")
 	}
 
 	if e == nil {
 		// decode remaining ambiguous arguments
+		params := map[string]interface{}{}
 		if whereMap, ok := where.(map[string]interface{}); ok { // is_map
-			whereStr = that.ImplementWhere(whereMap)
+			whereStr = that.implementWhere(whereMap, params)
 		}
 		if (whereStr != "") && !strings.HasPrefix(whereStr, " ") {
 			whereStr = " WHERE " + whereStr
 		}
 
 		// read the table
-		q := "SELECT `" + sort_field + "` FROM `" + tableStr + "`" + whereStr + orderByStr + ";"
-		params := map[string]interface{}{}
+		q := "SELECT " + that.quote(sort_field) + " FROM " + that.quote(tableStr) + whereStr + orderByStr + ";"
 		rows, e, _ := that.Mysql_query(q, params)
 		if e != nil {
 			e = errors.New("CheckSortColumnConstraint(): error in " + q)
@@ -1871,16 +2262,16 @@ func (that XibDb) CheckJsonColumnConstraint(querySpec interface{}, whereSpec int
 
 	if e == nil {
 		// decode remaining ambiguous arguments
+		params := map[string]interface{}{}
 		if whereMap, ok := where.(map[string]interface{}); ok { // is_map
-			whereStr = that.ImplementWhere(whereMap)
+			whereStr = that.implementWhere(whereMap, params)
 		}
 		if (whereStr != "") && !strings.HasPrefix(whereStr, " ") {
 			whereStr = " WHERE " + whereStr
 		}
 
 		// read the table
-		q := "SELECT `" + json_field + "` FROM `" + tableStr + "`" + whereStr + ";"
-		params := map[string]interface{}{}
+		q := "SELECT " + that.quote(json_field) + " FROM " + that.quote(tableStr) + whereStr + ";"
 		rows, e, _ := that.Mysql_query(q, params)
 		// read result
 		for row := that.Mysql_fetch_assoc(rows); (row != nil) && (e == nil); row = that.Mysql_fetch_assoc(rows) {
@@ -1940,8 +2331,22 @@ func (that XibDb) ApplyTablesToWhere(a map[string]interface{}, table string) (aa
  * @author DanielWHoward
  */
 func (that XibDb) ImplementWhere(whereSpec interface{}) (whereStr string) {
+	return that.implementWhere(whereSpec, nil)
+}
+
+/**
+ * Return a WHERE clause whose values are bind
+ * arguments in a parameter map.
+ *
+ * @param whereSpec An array with clause specification.
+ * @param params map The query parameters or nil to use literals.
+ * @return A clause string.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) implementWhere(whereSpec interface{}, params map[string]interface{}) (whereStr string) {
 	if whereMap, ok := whereSpec.(map[string]interface{}); ok { // is_map
-		whereStr = that.implementCondition(whereMap, "")
+		whereStr = that.implementCondition(whereMap, "", params)
 		if whereStr != "" {
 			whereStr = " WHERE " + whereStr
 		}
@@ -1951,6 +2356,133 @@ func (that XibDb) ImplementWhere(whereSpec interface{}) (whereStr string) {
 	return
 }
 
+/**
+ * A column and direction of an ORDER BY clause.
+ *
+ * @author DanielWHoward
+ */
+type orderColumn struct {
+	name string
+	desc bool
+}
+
+/**
+ * Return an ORDER BY clause and its columns.
+ *
+ * The order by value is a raw SQL string or a list
+ * of columns.  Each column is a name, optionally
+ * followed by ASC or DESC, or a map with column and
+ * direction keys.  The columns of a raw SQL string
+ * are not returned.
+ *
+ * @param orderby mixed A string or a list of columns.
+ * @return A clause string and the columns.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) implementOrderBy(orderby interface{}) (orderByStr string, cols []orderColumn, e error) {
+	if orderByParamStr, ok := orderby.(string); ok {
+		if orderByParamStr != "" {
+			orderByStr = " ORDER BY " + orderByParamStr
+		}
+		return
+	}
+	list := []interface{}{}
+	if strs, ok := orderby.([]string); ok {
+		for _, str := range strs {
+			list = append(list, str)
+		}
+	} else if items, ok := orderby.([]interface{}); ok {
+		list = items
+	} else if orderby != nil {
+		return "", nil, errors.New("order by must be a string or a list")
+	}
+	for _, item := range list {
+		col := orderColumn{}
+		direction := ""
+		if str, ok := item.(string); ok {
+			fields := strings.Fields(str)
+			if len(fields) > 0 {
+				col.name = fields[0]
+			}
+			if len(fields) > 1 {
+				direction = fields[1]
+			}
+		} else if m, ok := item.(map[string]interface{}); ok {
+			col.name, _ = m["column"].(string)
+			direction, _ = m["direction"].(string)
+		}
+		switch strings.ToUpper(direction) {
+		case "", "ASC":
+		case "DESC":
+			col.desc = true
+		default:
+			return "", nil, errors.New("order by direction must be ASC or DESC: " + direction)
+		}
+		if col.name == "" {
+			return "", nil, errors.New("order by column is missing")
+		}
+		cols = append(cols, col)
+	}
+	for _, col := range cols {
+		if orderByStr == "" {
+			orderByStr = " ORDER BY "
+		} else {
+			orderByStr += ", "
+		}
+		orderByStr += that.quote(col.name)
+		if col.desc {
+			orderByStr += " DESC"
+		} else {
+			orderByStr += " ASC"
+		}
+	}
+	return
+}
+
+/**
+ * Return a condition that selects the rows after a
+ * row in ORDER BY order for keyset pagination.
+ *
+ * The after map has the values of the ORDER BY
+ * columns for the last row of the previous page.
+ *
+ * @param after map The ORDER BY values of the last row.
+ * @param cols array The ORDER BY columns.
+ * @param params map The argument map for the values.
+ * @return A condition string.
+ *
+ * @author DanielWHoward
+ */
+func (that XibDb) implementAfter(after map[string]interface{}, cols []orderColumn, params map[string]interface{}) (cond string, e error) {
+	if len(cols) == 0 {
+		return "", errors.New("after requires a list of order by columns")
+	}
+	// (a > ?) OR (a = ? AND b > ?) OR ...
+	ors := []string{}
+	for c := range cols {
+		ands := []string{}
+		for p := 0; p <= c; p++ {
+			value, ok := after[cols[p].name]
+			if !ok {
+				return "", errors.New("after is missing the order by column " + cols[p].name)
+			}
+			param := "{{{" + that.paramRand + "--after--" + strconv.Itoa(len(params)) + "}}}"
+			params[param] = value
+			op := "="
+			if (p == c) && cols[p].desc {
+				op = "<"
+			} else if p == c {
+				op = ">"
+			}
+			ands = append(ands, that.quote(cols[p].name)+op+param)
+		}
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	cond = "(" + strings.Join(ors, " OR ") + ")"
+	return
+}
+
 /**
  * Return a clause string created from an array specification.
  *
@@ -1985,7 +2517,7 @@ func (that XibDb) implementOn(onVar interface{}) (onVarStr string) {
 				}
 			}
 			// build the JOIN clause
-			onVarStr += join + " `" + table + "` ON " + that.implementCondition(conds, "ON ")
+			onVarStr += join + " " + that.quote(table) + " ON " + that.implementCondition(conds, "ON ", nil)
 		}
 	} else {
 		onVarStr, _ = onVar.(string)
@@ -2001,11 +2533,12 @@ func (that XibDb) implementOn(onVar interface{}) (onVarStr string) {
  *
  * @param condObj An array with conditional specification.
  * @param onVar A string with an ON clause specification.
+ * @param params map The query parameters or nil to use literals.
  * @return A SQL string containing a nested conditional.
  *
  * @author DanielWHoward
  */
-func (that XibDb) implementCondition(condObj interface{}, onVar string) (cond string) {
+func (that XibDb) implementCondition(condObj interface{}, onVar string, params map[string]interface{}) (cond string) {
 	if condStr, ok := condObj.(string); ok {
 		cond = condStr
 	} else if condMap, ok := condObj.(map[string]interface{}); ok { // is_map
@@ -2024,19 +2557,23 @@ func (that XibDb) implementCondition(condObj interface{}, onVar string) (cond st
 					}
 				} else if _, ok := value.(map[string]interface{}); ok { // is_map
 					// assume it is a sub-clause
-					sub = that.implementCondition(value, "")
+					sub = that.implementCondition(value, "", params)
 					if sub != "" {
 						sub = "(" + sub + ")"
 					}
+				} else if (onVar == "") && (params != nil) {
+					param := "{{{" + that.paramRand + "--where--" + strconv.Itoa(len(params)) + "}}}"
+					params[param] = value
+					sub = that.quote(key) + "=" + param
 				} else {
 					sub = fmt.Sprintf("%v", value)
 					sub = that.Mysql_real_escape_string(sub)
 					if onVar == "" {
 						sub = "'" + sub + "'"
 					} else {
-						sub = "`" + strings.ReplaceAll(sub, ".", "`.`") + "`"
+						sub = that.quote(sub)
 					}
-					sub = "`" + strings.ReplaceAll(key, ".", "`.`") + "`=" + sub
+					sub = that.quote(key) + "=" + sub
 				}
 			}
 			if sub != "" {
@@ -2069,7 +2606,7 @@ func (that XibDb) ImplementSyntax(key string, syntax []interface{}) (sql string)
 		op := " OR "
 		clauses := []string{}
 		col, _ := syntax[1].(string)
-		likeStr := "`" + col + "` LIKE"
+		likeStr := that.quote(col) + " LIKE"
 		if len(syntax) == 3 {
 			valueStr, _ := syntax[2].(string)
 			valueStr = likeStr + " '" + that.Mysql_real_escape_string(valueStr) + "'"
@@ -2099,7 +2636,7 @@ func (that XibDb) ImplementSyntax(key string, syntax []interface{}) (sql string)
 		clauses := []string{}
 		for _, value := range syntax {
 			valueStr := fmt.Sprintf("%v", value)
-			valueStr = "`" + key + "`='" + that.Mysql_real_escape_string(valueStr) + "'"
+			valueStr = that.quote(key) + "='" + that.Mysql_real_escape_string(valueStr) + "'"
 			clauses = append(clauses, valueStr)
 		}
 		sql = strings.Join(clauses, op)
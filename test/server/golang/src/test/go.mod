@@ -9,6 +9,8 @@ replace github.com/xibbit/xibbit/server/golang/src/xibdb v0.0.0 => ../../../../.
 require (
 	github.com/go-sql-driver/mysql v1.8.1
 	github.com/googollee/go-socket.io v1.7.0
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/xibbit/xibbit/server/golang/src/xibbit v0.0.0
 	github.com/xibbit/xibbit/server/golang/src/xibdb v0.0.0
 )
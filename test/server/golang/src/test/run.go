@@ -25,7 +25,16 @@
 // @license http://opensource.org/licenses/MIT
 package main
 
+import (
+	"os"
+)
+
 func main() {
-	TestFullXibdb()
+	// the xibdb tests can run on "mysql", "sqlite" or "postgres"
+	dialect := "mysql"
+	if len(os.Args) > 1 {
+		dialect = os.Args[1]
+	}
+	TestFullXibdb(dialect)
 	TestFullXibbit()
 }
@@ -26,12 +26,19 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"database/sql"
@@ -100,6 +107,52 @@ func sortJsonNativeXibbit(o interface{}) (s string) {
 	}
 	return s
 }
+/**
+ * Global variables that are always locked by
+ * another process.
+ *
+ * @author DanielWHoward
+ **/
+type busyGlobalVars struct {
+	*xibbit.MemoryGlobalVars
+}
+
+func (self busyGlobalVars) Lock() bool {
+	return false
+}
+
+/**
+ * An output stream that takes a while to write
+ * each event.
+ *
+ * @author DanielWHoward
+ **/
+type slowOutputStream struct {
+	mu      sync.Mutex
+	started chan struct{}
+	once    sync.Once
+	types   string
+}
+
+func (self *slowOutputStream) Write(sock *xibbit.SocketWrapper, eventName string, data map[string]interface{}) {
+	self.once.Do(func() {
+		close(self.started)
+	})
+	time.Sleep(50 * time.Millisecond)
+	self.mu.Lock()
+	self.types += data["type"].(string) + ","
+	self.mu.Unlock()
+}
+
+func (self *slowOutputStream) Flush() {
+}
+
+func (self *slowOutputStream) written() string {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	return self.types
+}
+
 func assertBool(name string, output bool, actual bool) {
 	color := "green"
 	result := "passed"
@@ -170,7 +223,7 @@ func TestFullXibbit() {
 
 	installer.CreateDatabaseTables(log, "`json` text")
 
-	installer.StopHub()
+	installer.StopHub(context.Background())
 
 	session := map[string]interface{}{}
 	event := map[string]interface{}{}
@@ -187,7 +240,7 @@ func TestFullXibbit() {
 	conn1 := xibbit.NewFakeSocket("sid_abc")
 	conn2 := xibbit.NewFakeSocket("sid_def")
 	conn3 := xibbit.NewFakeSocket("sid_ghi")
-	hub.Sessions = append(hub.Sessions, map[string]interface{}{
+	hub.Sessions.InsertSession(map[string]interface{}{
 		"session_data": map[string]interface{}{
 			"instance_id": "instance_abc",
 			"value":       "quickbrownfox",
@@ -198,7 +251,7 @@ func TestFullXibbit() {
 			},
 		},
 	})
-	hub.Sessions = append(hub.Sessions, map[string]interface{}{
+	hub.Sessions.InsertSession(map[string]interface{}{
 		"session_data": map[string]interface{}{
 			"instance_id": "instance_def",
 			"value":       "jumpedover",
@@ -209,7 +262,7 @@ func TestFullXibbit() {
 			},
 		},
 	})
-	hub.Sessions = append(hub.Sessions, map[string]interface{}{
+	hub.Sessions.InsertSession(map[string]interface{}{
 		"session_data": map[string]interface{}{
 			"instance_id": "instance_ghi",
 			"value":       "lazydog",
@@ -226,7 +279,7 @@ func TestFullXibbit() {
 		string(b),
 		"{\"instance_id\":\"instance_def\",\"value\":\"jumpedover\"}",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -237,7 +290,7 @@ func TestFullXibbit() {
 	conn1 = xibbit.NewFakeSocket("sid_abc")
 	conn2 = xibbit.NewFakeSocket("sid_def")
 	conn3 = xibbit.NewFakeSocket("sid_ghi")
-	hub.Sessions = append(hub.Sessions, map[string]interface{}{
+	hub.Sessions.InsertSession(map[string]interface{}{
 		"session_data": map[string]interface{}{
 			"instance_id": "instance_abc",
 			"value":       "quickbrownfox",
@@ -248,7 +301,7 @@ func TestFullXibbit() {
 			},
 		},
 	})
-	hub.Sessions = append(hub.Sessions, map[string]interface{}{
+	hub.Sessions.InsertSession(map[string]interface{}{
 		"session_data": map[string]interface{}{
 			"instance_id": "instance_def",
 			"value":       "jumpedover",
@@ -259,7 +312,7 @@ func TestFullXibbit() {
 			},
 		},
 	})
-	hub.Sessions = append(hub.Sessions, map[string]interface{}{
+	hub.Sessions.InsertSession(map[string]interface{}{
 		"session_data": map[string]interface{}{
 			"instance_id": "instance_ghi",
 			"value":       "lazydog",
@@ -275,7 +328,7 @@ func TestFullXibbit() {
 		strconv.Itoa(retValInt),
 		"1",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -286,7 +339,7 @@ func TestFullXibbit() {
 	conn1 = xibbit.NewFakeSocket("sid_abc")
 	conn2 = xibbit.NewFakeSocket("sid_def")
 	conn3 = xibbit.NewFakeSocket("sid_ghi")
-	hub.Sessions = append(hub.Sessions, map[string]interface{}{
+	hub.Sessions.InsertSession(map[string]interface{}{
 		"session_data": map[string]interface{}{
 			"instance_id": "instance_abc",
 			"value":       "quickbrownfox",
@@ -297,7 +350,7 @@ func TestFullXibbit() {
 			},
 		},
 	})
-	hub.Sessions = append(hub.Sessions, map[string]interface{}{
+	hub.Sessions.InsertSession(map[string]interface{}{
 		"session_data": map[string]interface{}{
 			"instance_id": "instance_def",
 			"value":       "jumpedover",
@@ -308,7 +361,7 @@ func TestFullXibbit() {
 			},
 		},
 	})
-	hub.Sessions = append(hub.Sessions, map[string]interface{}{
+	hub.Sessions.InsertSession(map[string]interface{}{
 		"session_data": map[string]interface{}{
 			"instance_id": "instance_ghi",
 			"value":       "lazydog",
@@ -325,7 +378,7 @@ func TestFullXibbit() {
 		string(b),
 		"{\"instance_id\":\"instance_def\",\"value\":\"jumpedover\"}",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -335,20 +388,20 @@ func TestFullXibbit() {
 	hub = xibbit.NewXibbitHub(map[string]interface{}{})
 	session = map[string]interface{}{}
 	session["session_data"] = map[string]interface{}{"_username": "john", "value": "handsome"}
-	hub.Sessions = append(hub.Sessions, session)
+	hub.Sessions.InsertSession(session)
 	session = map[string]interface{}{}
 	session["session_data"] = map[string]interface{}{"_username": "bill", "value": "smart"}
-	hub.Sessions = append(hub.Sessions, session)
+	hub.Sessions.InsertSession(session)
 	session = map[string]interface{}{}
 	session["session_data"] = map[string]interface{}{"_username": "ray", "value": "wise"}
-	hub.Sessions = append(hub.Sessions, session)
+	hub.Sessions.InsertSession(session)
 	retValArrMap = hub.GetSessionsByUsername("bill")
 	b, _ = json.Marshal(retValArrMap)
 	assertStr("XibbitHub.GetSessionsByUsername #4", false,
 		string(b),
 		"[{\"session_data\":{\"_username\":\"bill\",\"value\":\"smart\"}}]",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -359,22 +412,22 @@ func TestFullXibbit() {
 	session = map[string]interface{}{}
 	session["username"] = "john"
 	session["session"] = map[string]interface{}{"value": "handsome"}
-	hub.Sessions = append(hub.Sessions, session)
+	hub.Sessions.InsertSession(session)
 	session = map[string]interface{}{}
 	session["username"] = "bill"
 	session["session"] = map[string]interface{}{"value": "smart"}
-	hub.Sessions = append(hub.Sessions, session)
+	hub.Sessions.InsertSession(session)
 	session = map[string]interface{}{}
 	session["username"] = "ray"
 	session["session"] = map[string]interface{}{"value": "wise"}
-	hub.Sessions = append(hub.Sessions, session)
+	hub.Sessions.InsertSession(session)
 	retValArrMap = hub.GetSessionsByUsername("all")
 	b, _ = json.Marshal(retValArrMap)
 	assertStr("XibbitHub.GetSessionsByUsername #5", false,
 		string(b),
 		"[{\"session\":{\"value\":\"handsome\"},\"username\":\"john\"},{\"session\":{\"value\":\"smart\"},\"username\":\"bill\"},{\"session\":{\"value\":\"wise\"},\"username\":\"ray\"}]",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -384,7 +437,7 @@ func TestFullXibbit() {
 	hub = xibbit.NewXibbitHub(map[string]interface{}{})
 	conn1 = xibbit.NewFakeSocket("sid_abc")
 	conn2 = xibbit.NewFakeSocket("sid_def")
-	hub.Sessions = append(hub.Sessions, map[string]interface{}{
+	hub.Sessions.InsertSession(map[string]interface{}{
 		"session_data": map[string]interface{}{
 			"instance_id": "instance_abc",
 			"a":           "bc",
@@ -398,10 +451,10 @@ func TestFullXibbit() {
 	sock := conn2
 	hub.AddSession(sock)
 	assertStr("XibbitHub.AddSession #6", false,
-		strconv.Itoa(len(hub.Sessions)),
+		strconv.Itoa(hub.Sessions.Len()),
 		"2",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -412,7 +465,7 @@ func TestFullXibbit() {
 	conn1 = xibbit.NewFakeSocket("sid_abc")
 	conn2 = xibbit.NewFakeSocket("sid_def")
 	conn3 = xibbit.NewFakeSocket("sid_ghi")
-	hub.Sessions = append(hub.Sessions, map[string]interface{}{
+	hub.Sessions.InsertSession(map[string]interface{}{
 		"session_data": map[string]interface{}{
 			"instance_id": "instance_abc",
 			"value":       "quickbrownfox",
@@ -423,7 +476,7 @@ func TestFullXibbit() {
 			},
 		},
 	})
-	hub.Sessions = append(hub.Sessions, map[string]interface{}{
+	hub.Sessions.InsertSession(map[string]interface{}{
 		"session_data": map[string]interface{}{
 			"instance_id": "instance_def",
 			"value":       "jumpedover",
@@ -434,7 +487,7 @@ func TestFullXibbit() {
 			},
 		},
 	})
-	hub.Sessions = append(hub.Sessions, map[string]interface{}{
+	hub.Sessions.InsertSession(map[string]interface{}{
 		"session_data": map[string]interface{}{
 			"instance_id": "instance_ghi",
 			"value":       "lazydog",
@@ -448,10 +501,10 @@ func TestFullXibbit() {
 	sock = conn2
 	hub.RemoveSocketFromSession(sock)
 	assertStr("XibbitHub.RemoveSocketFromSession #7", false,
-		strconv.Itoa(len(hub.Sessions[1]["_conn"].(map[string]interface{})["sockets"].([]*xibbit.SocketWrapper))),
+		strconv.Itoa(len(hub.Sessions.All()[1]["_conn"].(map[string]interface{})["sockets"].([]*xibbit.SocketWrapper))),
 		"0",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -473,7 +526,7 @@ func TestFullXibbit() {
 			},
 		},
 	}
-	hub.Sessions = append(hub.Sessions, session)
+	hub.Sessions.InsertSession(session)
 	session = map[string]interface{}{
 		"session_data": map[string]interface{}{
 			"instance_id": "instance_def",
@@ -485,7 +538,7 @@ func TestFullXibbit() {
 			},
 		},
 	}
-	hub.Sessions = append(hub.Sessions, session)
+	hub.Sessions.InsertSession(session)
 	session = map[string]interface{}{
 		"session_data": map[string]interface{}{
 			"instance_id": "instance_ghi",
@@ -497,14 +550,14 @@ func TestFullXibbit() {
 			},
 		},
 	}
-	hub.Sessions = append(hub.Sessions, session)
+	hub.Sessions.InsertSession(session)
 	sock = conn2
 	hub.CombineSessions("instance_ghi", sock)
 	assertStr("XibbitHub.CombineSessions #8", false,
-		hub.Sessions[1]["session_data"].(map[string]interface{})["value"].(string),
+		hub.Sessions.All()[1]["session_data"].(map[string]interface{})["value"].(string),
 		"lazydog",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -519,7 +572,7 @@ func TestFullXibbit() {
 		string(b),
 		"{\"value1\":\"fred\",\"value2\":4}",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -534,7 +587,7 @@ func TestFullXibbit() {
 		retValStr,
 		"{\"c\":6,\"a\":4,\"b\":5}",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -549,7 +602,7 @@ func TestFullXibbit() {
 		string(b),
 		"{\"a\":4,\"b\":5,\"c\":6}",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -565,7 +618,7 @@ func TestFullXibbit() {
 		"alwaysfails",
 		"alwayspasses",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -574,13 +627,13 @@ func TestFullXibbit() {
 
 	hub = xibbit.NewXibbitHub(map[string]interface{}{})
 	events := []map[string]interface{}{{"a": "b"}}
-	retValArrMap = hub.ReadAndWriteUploadEvent(events)
+	retValArrMap = hub.ReadAndWriteUploadEvent(events, "")
 	b, _ = json.Marshal(retValArrMap)
 	assertStr("XibbitHub.ReadAndWriteUploadEvent #13", false,
 		string(b),
 		"[{\"a\":\"b\"}]fails",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -595,7 +648,7 @@ func TestFullXibbit() {
 	assertBool("XibbitHub.On #14", false,
 		hub.Handler_groups["on"]["an_event"] != nil,
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -610,7 +663,7 @@ func TestFullXibbit() {
 	assertBool("XibbitHub.Api #15", false,
 		hub.Handler_groups["api"]["an_event"] != nil,
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -627,7 +680,7 @@ func TestFullXibbit() {
 	assertBool("XibbitHub.Trigger #16", false,
 		invoked,
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -642,14 +695,14 @@ func TestFullXibbit() {
 		},
 	}
 	session["_conn"] = map[string]interface{}{"sockets": []*xibbit.SocketWrapper{conn1}}
-	hub.Sessions = append(hub.Sessions, session)
+	hub.Sessions.InsertSession(session)
 	event = map[string]interface{}{"type": "an_event"}
 	retValMap, e = hub.Send(event, "bill", true)
 	assertStr("XibbitHub.Send #17", false,
 		conn1.Fake_data,
 		"{\"type\":\"an_event\"}",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -664,7 +717,7 @@ func TestFullXibbit() {
 		string(b),
 		"[{\"type\":\"an_event\"}]",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -679,7 +732,7 @@ func TestFullXibbit() {
 		string(b),
 		"{\"_session\":{\"_username\":\"bill\"},\"type\":\"an_event\"}",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -692,7 +745,7 @@ func TestFullXibbit() {
 		"alwaysfails",
 		"alwayspasses",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -715,7 +768,7 @@ func TestFullXibbit() {
 	assertBool("XibbitHub.CheckClock #21", false,
 		invoked,
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -742,7 +795,7 @@ func TestFullXibbit() {
 		string(value3),
 		"1970-01-01 00:00:00",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -764,7 +817,7 @@ func TestFullXibbit() {
 		strconv.FormatBool(retValBool),
 		"false",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -790,7 +843,7 @@ func TestFullXibbit() {
 		strconv.FormatBool(retValBool),
 		"false",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -803,7 +856,7 @@ func TestFullXibbit() {
 		strconv.Itoa(retValInt),
 		"-1",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -818,7 +871,7 @@ func TestFullXibbit() {
 		string(b),
 		"{\"c\":\"d\",\"type\":\"a_event\"}",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -833,7 +886,7 @@ func TestFullXibbit() {
 		string(b),
 		"{\"a\":\"b\",\"c\":\"z\",\"e\":\"f\",\"type\":\"a_event\"}",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -845,7 +898,7 @@ func TestFullXibbit() {
 	assertBool("XibbitHub.RandSecure #28", false,
 		(retValInt >= 0) && (retValInt <= 4),
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -858,7 +911,7 @@ func TestFullXibbit() {
 	assertBool("XibbitHub.LockGlobalVars #29", false,
 		retValBool,
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -876,7 +929,7 @@ func TestFullXibbit() {
 	assertBool("XibbitHub.LockGlobalVarsUsingSql #30", false,
 		retValBool,
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -889,7 +942,7 @@ func TestFullXibbit() {
 	assertBool("XibbitHub.UnlockGlobalVars #31", false,
 		e == nil,
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -907,7 +960,7 @@ func TestFullXibbit() {
 	assertBool("XibbitHub.UnlockGlobalVarsUsingSql #32", false,
 		e == nil,
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -922,7 +975,7 @@ func TestFullXibbit() {
 		string(b),
 		"{\"kind\":\"mammal\"}",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -942,7 +995,7 @@ func TestFullXibbit() {
 		string(b),
 		"{\"kind\":\"mouse\"}",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -958,7 +1011,7 @@ func TestFullXibbit() {
 		string(b),
 		"{\"craft\":\"beer\",\"good\":true}",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -979,7 +1032,7 @@ func TestFullXibbit() {
 		string(b),
 		"{\"make\":\"a profit\",\"take\":5}",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -999,7 +1052,7 @@ func TestFullXibbit() {
 		string(b),
 		"{\"socksessid\":\"global\"}",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -1012,7 +1065,7 @@ func TestFullXibbit() {
 		retValStr,
 		"\\\"Chicago Pizz\\'a\\\" is good",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -1031,7 +1084,7 @@ func TestFullXibbit() {
 		strconv.Itoa(retValInt),
 		"1054",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
 	hub = nil
 
 	//
@@ -1050,6 +1103,877 @@ func TestFullXibbit() {
 		retValStr,
 		"Error 1054 (42S22): Unknown column 'x' in 'field list'",
 	)
-	hub.StopHub()
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #41
+	//
+
+	hub = xibbit.NewXibbitHub(map[string]interface{}{
+		"mysql": map[string]interface{}{
+			"link":       link,
+			"SQL_PREFIX": sql_prefix,
+		},
+		"sessionStore": "sql",
+	})
+	conn1 = xibbit.NewFakeSocket("sid_abc")
+	hub.AddSession(conn1)
+	hub.SetSessionData(conn1, map[string]interface{}{
+		"instance_id": "instanceabcdefghijklmnopq",
+		"_username":   "bill",
+	})
+	hub.StopHub(context.Background())
+	hub = xibbit.NewXibbitHub(map[string]interface{}{
+		"mysql": map[string]interface{}{
+			"link":       link,
+			"SQL_PREFIX": sql_prefix,
+		},
+		"sessionStore": "sql",
+	})
+	retValMap = hub.Sessions.LoadSessionData("instanceabcdefghijklmnopq")
+	b, _ = json.Marshal(retValMap)
+	assertStr("XibbitHub.SqlSessionStore #41", false,
+		string(b),
+		"{\"_username\":\"bill\",\"instance_id\":\"instanceabcdefghijklmnopq\"}",
+	)
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #42
+	//
+
+	bus := xibbit.NewMemoryClusterBus()
+	hub = xibbit.NewXibbitHub(map[string]interface{}{
+		"cluster": bus,
+	})
+	hub2 := xibbit.NewXibbitHub(map[string]interface{}{
+		"cluster": bus,
+	})
+	conn1 = xibbit.NewFakeSocket("sid_abc")
+	hub2.AddSession(conn1)
+	hub2.SetSessionData(conn1, map[string]interface{}{
+		"instance_id": "instanceabcdefghijklmnopq",
+		"_username":   "bill",
+	})
+	retValArrMap = hub.GetSessionsByUsername("bill")
+	hub.Send(map[string]interface{}{"type": "an_event"}, "bill", true)
+	assertStr("XibbitHub.Cluster #42", false,
+		strconv.Itoa(len(retValArrMap))+conn1.Fake_data,
+		"1{\"type\":\"an_event\"}",
+	)
+	hub2.StopHub(context.Background())
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #43
+	//
+
+	hub = xibbit.NewXibbitHub(map[string]interface{}{
+		"mysql": map[string]interface{}{
+			"link":       link,
+			"SQL_PREFIX": sql_prefix,
+		},
+		"globalVars": "sql",
+	})
+	hub2 = xibbit.NewXibbitHub(map[string]interface{}{
+		"mysql": map[string]interface{}{
+			"link":       link,
+			"SQL_PREFIX": sql_prefix,
+		},
+		"globalVars": "sql",
+	})
+	retValBool = hub.LockGlobalVars()
+	retValBool = retValBool && !hub2.LockGlobalVars()
+	hub2.UnlockGlobalVars()
+	retValBool = retValBool && !hub2.LockGlobalVars()
+	hub.UnlockGlobalVars()
+	retValBool = retValBool && hub2.LockGlobalVars()
+	hub2.UnlockGlobalVars()
+	assertBool("XibbitHub.LockGlobalVars lease #43", false,
+		retValBool,
+	)
+	hub2.StopHub(context.Background())
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #44
+	//
+
+	hub = xibbit.NewXibbitHub(map[string]interface{}{})
+	schema := map[string]interface{}{
+		"to": map[string]interface{}{
+			"type":   "string",
+			"regexp": `^[a-z]+$`,
+		},
+		"age":  map[string]interface{}{"type": "int", "optional": true},
+		"user": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"kind": map[string]interface{}{"type": "string", "enum": []interface{}{"cat", "dog"}},
+			},
+		},
+	}
+	retValStr = hub.ValidateEvent(map[string]interface{}{"type": "an_event", "user": map[string]interface{}{"kind": "dog"}}, schema) + ","
+	retValStr += hub.ValidateEvent(map[string]interface{}{"type": "an_event", "to": 5, "user": map[string]interface{}{"kind": "dog"}}, schema) + ","
+	retValStr += hub.ValidateEvent(map[string]interface{}{"type": "an_event", "to": "A", "user": map[string]interface{}{"kind": "dog"}}, schema) + ","
+	retValStr += hub.ValidateEvent(map[string]interface{}{"type": "an_event", "to": "a", "user": map[string]interface{}{"kind": "cow"}}, schema) + ","
+	retValStr += hub.ValidateEvent(map[string]interface{}{"type": "an_event", "to": "a", "user": map[string]interface{}{"kind": "cat"}, "x": 1}, schema) + ","
+	retValStr += hub.ValidateEvent(map[string]interface{}{"type": "an_event", "_id": 1, "to": "a", "age": 4.0, "user": map[string]interface{}{"kind": "cat"}}, schema)
+	assertStr("XibbitHub.ValidateEvent #44", false,
+		retValStr,
+		"missing:to,typeof:to,regexp:to,enum:user.kind,property:x,",
+	)
+	// check properties in the declared order
+	schemaList := []interface{}{
+		map[string]interface{}{"name": "to", "type": "string", "not": []string{"root"}, "regexp": `^[a-z]+$`},
+		map[string]interface{}{"name": "pwd", "type": "string"},
+		map[string]interface{}{"name": "tags", "type": "any", "enum": []interface{}{[]interface{}{"a"}, "b"}},
+	}
+	retValStr = hub.ValidateEvent(map[string]interface{}{"type": "an_event"}, schemaList) + ","
+	retValStr += hub.ValidateEvent(map[string]interface{}{"type": "an_event", "to": "root", "pwd": "p", "tags": "b"}, schemaList) + ","
+	retValStr += hub.ValidateEvent(map[string]interface{}{"type": "an_event", "to": "a", "pwd": "p", "tags": []interface{}{"a"}}, schemaList) + ","
+	retValStr += hub.ValidateEvent(map[string]interface{}{"type": "an_event", "to": "a", "pwd": "p", "tags": "b"}, schemaList)
+	assertStr("XibbitHub.ValidateEvent #44", false,
+		retValStr,
+		"missing:to,invalid:to,enum:tags,",
+	)
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #45
+	//
+
+	hub = xibbit.NewXibbitHub(map[string]interface{}{})
+	hub.On("api", "an_event", func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
+		event["order"] = event["order"].(string) + "h"
+		return event
+	})
+	hub.Use(func(next xibbit.EventHandler) xibbit.EventHandler {
+		return func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
+			event["order"] = "a"
+			event = next(event, vars)
+			event["order"] = event["order"].(string) + "A"
+			return event
+		}
+	})
+	hub.Use(func(next xibbit.EventHandler) xibbit.EventHandler {
+		return func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
+			event["e"] = "stopped"
+			return event
+		}
+	}, "on")
+	retValMap, _ = hub.Trigger(map[string]interface{}{"type": "an_event"})
+	b, _ = json.Marshal(retValMap)
+	assertStr("XibbitHub.Use #45", false,
+		string(b),
+		"{\"order\":\"ahA\",\"type\":\"an_event\"}",
+	)
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #46
+	//
+
+	hub = xibbit.NewXibbitHub(map[string]interface{}{
+		"permissions": map[string]interface{}{
+			"admin": []string{"delete"},
+		},
+	})
+	hub.On("on", "an_event", func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
+		return event
+	}, map[string]interface{}{"permissions": []string{"delete"}})
+	event = hub.Connect(map[string]interface{}{"type": "an_event", "_session": map[string]interface{}{}}, "bill", true)
+	retValMap, _ = hub.Trigger(event)
+	retValStr, _ = retValMap["e"].(string)
+	event = hub.Connect(map[string]interface{}{"type": "an_event", "_session": map[string]interface{}{}}, "bill", true, "admin")
+	retValMap, _ = hub.Trigger(event)
+	if _, ok := retValMap["e"]; !ok {
+		retValStr += ",ok"
+	}
+	assertStr("XibbitHub.On roles #46", false,
+		retValStr,
+		"unauthorized,ok",
+	)
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #47
+	//
+
+	hub = xibbit.NewXibbitHub(map[string]interface{}{
+		"rateLimits": map[string]interface{}{
+			"api": map[string]interface{}{"rate": 1, "burst": 2},
+		},
+	})
+	hub.On("api", "an_event", func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
+		return event
+	})
+	retValStr = ""
+	for i := 0; i < 3; i++ {
+		event = map[string]interface{}{"type": "an_event", "_session": map[string]interface{}{"instance_id": "instance_abc"}}
+		retValMap, _ = hub.Trigger(event)
+		if e, ok := retValMap["e"].(string); ok {
+			retValStr += e
+			if retryAfter, _ := retValMap["retryAfter"].(int); retryAfter > 0 {
+				retValStr += "+retryAfter"
+			}
+		} else {
+			retValStr += "ok,"
+		}
+	}
+	assertStr("XibbitHub.Throttle #47", false,
+		retValStr,
+		"ok,ok,throttled+retryAfter",
+	)
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #48
+	//
+
+	type typedRequest struct {
+		To    string `json:"to"`
+		Count int    `json:"count"`
+	}
+	type typedReply struct {
+		Sum int    `json:"sum"`
+		I   string `json:"i"`
+	}
+	hub = xibbit.NewXibbitHub(map[string]interface{}{})
+	xibbit.Handle(hub, "on", "an_event", func(req *typedRequest, ctx *xibbit.EventContext) (*typedReply, error) {
+		if req.To == "" {
+			return nil, errors.New("missing:to")
+		}
+		ctx.Session.Set("seen", true)
+		return &typedReply{Sum: req.Count + ctx.Session.Int("uid"), I: ctx.Session.Username()}, nil
+	})
+	retValStr = ""
+	for _, value := range []interface{}{3, "3", nil} {
+		event = map[string]interface{}{"type": "an_event", "to": "bill", "count": value, "_session": map[string]interface{}{"uid": float64(2), "_username": "bill"}}
+		if value == nil {
+			delete(event, "to")
+		}
+		retValMap, _ = hub.Trigger(event)
+		if e, ok := retValMap["e"].(string); ok {
+			retValStr += e + ","
+		} else {
+			retValStr += fmt.Sprintf("%v %v %v", retValMap["sum"], retValMap["i"], retValMap["to"]) + ","
+		}
+	}
+	assertStr("xibbit.Handle #48", false,
+		retValStr,
+		"5 bill bill,typeof:count,missing:to,",
+	)
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #49
+	//
+
+	hub = xibbit.NewXibbitHub(map[string]interface{}{})
+	conn1 = xibbit.NewFakeSocket("sid_abc")
+	hub.AddSession(conn1)
+	hub.SetSessionData(conn1, map[string]interface{}{
+		"instance_id": "instanceabcdefghijklmnopq",
+		"_username":   "bill",
+	})
+	go func() {
+		// answer like a client once the question arrives
+		for i := 0; i < 100; i++ {
+			question := map[string]interface{}{}
+			json.Unmarshal([]byte(conn1.Fake_data), &question)
+			if rid, ok := question["_rid"].(string); ok {
+				hub.Reply(map[string]interface{}{"type": "_reply", "_rid": rid, "answer": "yes", "_session": map[string]interface{}{
+					"instance_id": "instanceabcdefghijklmnopq",
+					"_username":   "bill",
+				}})
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	retValMap, _ = hub.Request(ctx, "instanceabcdefghijklmnopq", map[string]interface{}{"type": "confirm"})
+	cancel()
+	retValStr, _ = retValMap["answer"].(string)
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	_, e = hub.Request(ctx, "bill", map[string]interface{}{"type": "confirm"})
+	cancel()
+	if e == context.DeadlineExceeded {
+		retValStr += ",timeout"
+	}
+	assertStr("XibbitHub.Request #49", false,
+		retValStr,
+		"yes,timeout",
+	)
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #50
+	//
+
+	hub = xibbit.NewXibbitHub(map[string]interface{}{})
+	for _, typ := range []string{"event_a", "event_b", "event_c"} {
+		hub.QueueEvent("instanceabcdefghijklmnopq", map[string]interface{}{"type": typ})
+	}
+	retValStr = ""
+	for _, event := range hub.ReceiveQueuedEvents("instanceabcdefghijklmnopq") {
+		retValStr += event["type"].(string) + strconv.Itoa(event["_seq"].(int)) + ","
+	}
+	retValStr += strconv.Itoa(len(hub.ReceiveQueuedEvents("instanceabcdefghijklmnopq"))) + ","
+	hub.AckEvents("instanceabcdefghijklmnopq", 2)
+	hub.ReplayQueuedEvents("instanceabcdefghijklmnopq")
+	hub.QueueEvent("instanceabcdefghijklmnopq", map[string]interface{}{"type": "event_d"})
+	for _, event := range hub.ReceiveQueuedEvents("instanceabcdefghijklmnopq") {
+		retValStr += event["type"].(string) + strconv.Itoa(event["_seq"].(int)) + ","
+	}
+	assertStr("XibbitHub.QueueEvent #50", false,
+		retValStr,
+		"event_a1,event_b2,event_c3,0,event_c3,event_d4,",
+	)
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #51
+	//
+
+	hub = xibbit.NewXibbitHub(map[string]interface{}{
+		"pollInterval": 250,
+	})
+	conn1 = xibbit.NewFakeSocket("sid_abc")
+	hub.AddSession(conn1)
+	hub.SetSessionData(conn1, map[string]interface{}{
+		"instance_id": "instanceabcdefghijklmnopq",
+		"_username":   "bill",
+	})
+	retValBool = hub.Deliver("instanceabcdefghijklmnopq", map[string]interface{}{"type": "an_event", "_session": map[string]interface{}{}})
+	retValBool = retValBool && !hub.Deliver("instancezzzzzzzzzzzzzzzzz", map[string]interface{}{"type": "an_event"})
+	assertStr("XibbitHub.Deliver #51", false,
+		strconv.FormatBool(retValBool)+conn1.Fake_data,
+		"true{\"type\":\"an_event\"}",
+	)
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #52
+	//
+
+	hub = xibbit.NewXibbitHub(map[string]interface{}{})
+	hub.On("api", "an_event", func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
+		event["i"] = "ok"
+		return event
+	})
+	queued := []map[string]interface{}{}
+	hub.On("api", "__receive", func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
+		event["eventQueue"] = queued
+		queued = []map[string]interface{}{}
+		return event
+	})
+	httpHandler := hub.GetHttpHandler()
+	request := httptest.NewRequest("POST", "/events", strings.NewReader(`[{"type":"_instance"},{"type":"an_event","_id":1}]`))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	httpHandler.ServeHTTP(recorder, request)
+	retValArrMap = []map[string]interface{}{}
+	json.Unmarshal(recorder.Body.Bytes(), &retValArrMap)
+	retValStr = ""
+	instance := ""
+	for _, event := range retValArrMap {
+		retValStr += event["type"].(string) + ","
+		if value, ok := event["instance"].(string); ok {
+			instance = value
+		}
+	}
+	queued = []map[string]interface{}{{"type": "queued_event"}}
+	form := url.Values{"instance": {instance}, "XIO": {`{}`}}
+	request = httptest.NewRequest("POST", "/events", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder = httptest.NewRecorder()
+	httpHandler.ServeHTTP(recorder, request)
+	retValArrMap = []map[string]interface{}{}
+	json.Unmarshal(recorder.Body.Bytes(), &retValArrMap)
+	for _, event := range retValArrMap {
+		retValStr += event["type"].(string) + ","
+	}
+	assertStr("XibbitHub.GetHttpHandler #52", false,
+		retValStr,
+		"_instance,an_event,queued_event,",
+	)
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #53
+	//
+
+	hub = xibbit.NewXibbitHub(map[string]interface{}{})
+	conn1 = xibbit.NewFakeSocket("sid_abc")
+	hub.AddSession(conn1)
+	hub.SetSessionData(conn1, map[string]interface{}{
+		"instance_id": "instanceabcdefghijklmnopq",
+		"_username":   "bill",
+	})
+	hub.QueueEvent("instanceabcdefghijklmnopq", map[string]interface{}{"type": "event_a"})
+	hub.QueueEvent("instanceabcdefghijklmnopq", map[string]interface{}{"type": "event_b"})
+	sseServer := httptest.NewServer(hub.GetSseHandler())
+	request, _ = http.NewRequest("GET", sseServer.URL+"?instance=instanceabcdefghijklmnopq", nil)
+	request.Header.Set("Last-Event-ID", "1")
+	response, e := http.DefaultClient.Do(request)
+	retValStr = ""
+	if e == nil {
+		retValStr = response.Header.Get("Content-Type") + ","
+		hub.Send(map[string]interface{}{"type": "notify_laughs"}, "bill", true)
+		line, _ := bufio.NewReader(response.Body).ReadString('\n')
+		retValStr += strings.TrimSpace(line) + ","
+		response.Body.Close()
+	}
+	retValArrMap, _ = hub.Events.Read("instanceabcdefghijklmnopq", 0)
+	for _, event := range retValArrMap {
+		retValStr += event["type"].(string) + ","
+	}
+	assertStr("XibbitHub.GetSseHandler #53", false,
+		retValStr,
+		"text/event-stream,data: {\"type\":\"notify_laughs\"},event_b,",
+	)
+	sseServer.Close()
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #54
+	//
+
+	recorder2 := xibbit.NewRecordingOutputStream(
+		xibbit.NewBoundedOutputStream(
+			xibbit.NewBatchingOutputStream(xibbit.NewXibbitHubOutputStreamImpl()), 2, true))
+	hub = xibbit.NewXibbitHub(map[string]interface{}{
+		"outputStream": recorder2,
+	})
+	conn1 = xibbit.NewFakeSocket("sid_abc")
+	hub.AddSession(conn1)
+	hub.SetSessionData(conn1, map[string]interface{}{
+		"instance_id": "instanceabcdefghijklmnopq",
+		"_username":   "bill",
+	})
+	hub.Deliver("instanceabcdefghijklmnopq", map[string]interface{}{"type": "notify_a", "n": 1})
+	hub.Deliver("instanceabcdefghijklmnopq", map[string]interface{}{"type": "notify_b"})
+	hub.Deliver("instanceabcdefghijklmnopq", map[string]interface{}{"type": "notify_a", "n": 2})
+	retValStr = conn1.Fake_data + ","
+	hub.OutputStream.Flush()
+	retValStr += conn1.Fake_data + "," + strconv.Itoa(len(recorder2.Records()))
+	assertStr("XibbitHub.OutputStream #54", false,
+		retValStr,
+		",[{\"type\":\"notify_b\"},{\"n\":2,\"type\":\"notify_a\"}],3",
+	)
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #55
+	//
+
+	hub = xibbit.NewXibbitHub(map[string]interface{}{
+		"presenceGrace":  0.05,
+		"presenceEvents": true,
+	})
+	presenceString := func() string {
+		s := ""
+		for _, user := range hub.Presence() {
+			s += user["username"].(string) + ":" + strconv.Itoa(user["instances"].(int)) + ","
+		}
+		return s + ";"
+	}
+	conn2 = xibbit.NewFakeSocket("sid_def")
+	hub.AddSession(conn2)
+	hub.SetSessionData(conn2, map[string]interface{}{
+		"instance_id": "instancezyxwvutsrqponmlkj",
+		"_username":   "ann",
+	})
+	conn1 = xibbit.NewFakeSocket("sid_abc")
+	hub.AddSession(conn1)
+	hub.SetSessionData(conn1, map[string]interface{}{
+		"instance_id": "instanceabcdefghijklmnopq",
+		"_username":   "bill",
+	})
+	retValStr = presenceString()
+	hub.RemoveSocketFromSession(conn1)
+	retValStr += presenceString()
+	time.Sleep(100 * time.Millisecond)
+	hub.ExpireSessions(60 * 60)
+	retValStr += presenceString()
+	retValStr += strings.ReplaceAll(conn2.Fake_data, "\"", "'")
+	assertStr("XibbitHub.Presence #55", false,
+		retValStr,
+		"ann:1,bill:1,;ann:1,bill:1,;ann:1,;"+
+			"{'to':'all','type':'presence_join','username':'ann'}"+
+			"{'to':'all','type':'presence_join','username':'bill'}"+
+			"{'to':'all','type':'presence_leave','username':'bill'}",
+	)
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #56
+	//
+
+	hub = xibbit.NewXibbitHub(map[string]interface{}{})
+	conn1 = xibbit.NewFakeSocket("sid_abc")
+	hub.AddSession(conn1)
+	hub.SetSessionData(conn1, map[string]interface{}{
+		"instance_id": "instanceabcdefghijklmnopq",
+		"_username":   "bill",
+	})
+	conn2 = xibbit.NewFakeSocket("sid_def")
+	hub.AddSession(conn2)
+	hub.SetSessionData(conn2, map[string]interface{}{
+		"instance_id": "instancezyxwvutsrqponmlkj",
+		"_username":   "ann",
+	})
+	retValStr = ""
+	for _, event := range hub.ReadAndWriteUploadEvent([]map[string]interface{}{
+		{"type": "_subscribe", "channel": "#room"},
+		{"type": "_subscribe", "channel": "no spaces"},
+	}, "instanceabcdefghijklmnopq") {
+		retValStr += fmt.Sprintf("%v,", event["i"]) + fmt.Sprintf("%v,", event["e"])
+	}
+	hub.ReadAndWriteUploadEvent([]map[string]interface{}{
+		{"type": "_subscribe", "channel": "room"},
+	}, "instancezyxwvutsrqponmlkj")
+	for _, event := range hub.ReadAndWriteUploadEvent([]map[string]interface{}{
+		{"type": "_unsubscribe", "channel": "room"},
+	}, "instancezyxwvutsrqponmlkj") {
+		retValStr += fmt.Sprintf("%v,", event["i"])
+	}
+	retValStr += strings.Join(xibbit.Channels(hub.GetSessionByInstance("instanceabcdefghijklmnopq")["session_data"].(map[string]interface{})), ",") + ","
+	hub.Send(map[string]interface{}{"type": "room_event", "to": "#room"}, "", true)
+	retValStr += strings.ReplaceAll(conn1.Fake_data, "\"", "'") + "," + conn2.Fake_data
+	assertStr("XibbitHub._subscribe #56", false,
+		retValStr,
+		"subscribed,<nil>,<nil>,malformed--channel,unsubscribed,room,{'to':'#room','type':'room_event'},",
+	)
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #57
+	//
+
+	hub = xibbit.NewXibbitHub(map[string]interface{}{})
+	started := make(chan bool)
+	slowState := "running,"
+	hub.On("api", "slow_event", func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
+		started <- true
+		time.Sleep(50 * time.Millisecond)
+		slowState = "finished,"
+		return event
+	})
+	retValStr = ""
+	go hub.ReadAndWriteUploadEvent([]map[string]interface{}{{"type": "slow_event"}}, "")
+	<-started
+	stopCtx, stopCancel := context.WithTimeout(context.Background(), time.Second)
+	retValStr += fmt.Sprintf("%v,", hub.StopHub(stopCtx))
+	stopCancel()
+	retValStr += slowState
+	for _, event := range hub.ReadAndWriteUploadEvent([]map[string]interface{}{{"type": "slow_event"}}, "") {
+		retValStr += fmt.Sprintf("%v,", event["e"])
+	}
+	retValStr += fmt.Sprintf("%v", hub.StopHub(context.Background()))
+	assertStr("XibbitHub.StopHub #57", false,
+		retValStr,
+		"<nil>,finished,unavailable,<nil>",
+	)
+	hub = nil
+
+	//
+	// #58
+	//
+
+	hub = xibbit.NewXibbitHub(map[string]interface{}{
+		"handlerTimeout": 10,
+	})
+	hub.On("api", "slow_event", func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
+		ctx := vars["ctx"].(context.Context)
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			event["i"] = "finished"
+		}
+		return event
+	}, map[string]interface{}{"timeout": 0.05})
+	hub.On("api", "fast_event", func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
+		_, hasDeadline := vars["ctx"].(context.Context).Deadline()
+		event["i"] = fmt.Sprintf("deadline %v", hasDeadline)
+		return event
+	})
+	retValStr = ""
+	for _, event := range hub.ReadAndWriteUploadEvent([]map[string]interface{}{
+		{"type": "slow_event"},
+		{"type": "fast_event"},
+	}, "") {
+		retValStr += fmt.Sprintf("%v,%v,", event["e"], event["i"])
+	}
+	assertStr("XibbitHub.handlerTimeout #58", false,
+		retValStr,
+		"timeout,<nil>,<nil>,deadline true,",
+	)
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #59
+	//
+
+	limiter := xibbit.NewGlobalVarsRateLimiter(busyGlobalVars{xibbit.NewMemoryGlobalVars()})
+	allowed, wait := limiter.Allow("socket:1:login", 1, 5)
+	assertBool("GlobalVarsRateLimiter.Allow busy #59", false,
+		!allowed && (wait > 0),
+	)
+
+	//
+	// #60
+	//
+
+	bus = xibbit.NewMemoryClusterBus()
+	hub = xibbit.NewXibbitHub(map[string]interface{}{
+		"cluster": bus,
+	})
+	hub2 = xibbit.NewXibbitHub(map[string]interface{}{
+		"cluster": bus,
+	})
+	conn1 = xibbit.NewFakeSocket("sid_abc")
+	hub2.AddSession(conn1)
+	hub2.SetSessionData(conn1, map[string]interface{}{
+		"instance_id": "instanceabcdefghijklmnopq",
+		"_username":   "bill",
+	})
+	bill := map[string]interface{}{"instance_id": "instanceabcdefghijklmnopq", "_username": "bill"}
+	mallory := map[string]interface{}{"instance_id": "instancezyxwvutsrqponmlkj", "_username": "mallory"}
+	retValStr = fmt.Sprintf("%v,", hub2.Reply(map[string]interface{}{"type": "_reply", "_rid": "bogus", "_session": bill}))
+	go func() {
+		// answer from the wrong session, then the right one
+		for i := 0; i < 100; i++ {
+			question := map[string]interface{}{}
+			json.Unmarshal([]byte(conn1.Fake_data), &question)
+			if rid, ok := question["_rid"].(string); ok {
+				retValBool = hub2.Reply(map[string]interface{}{"type": "_reply", "_rid": rid, "answer": "no", "_session": mallory})
+				hub2.Reply(map[string]interface{}{"type": "_reply", "_rid": rid, "answer": "yes", "_session": bill})
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	retValMap, _ = hub.Request(ctx, "bill", map[string]interface{}{"type": "confirm"})
+	cancel()
+	retValStr += fmt.Sprintf("%v,%v", retValBool, retValMap["answer"])
+	assertStr("XibbitHub.Reply cluster #60", false,
+		retValStr,
+		"false,false,yes",
+	)
+	hub2.StopHub(context.Background())
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #61
+	//
+
+	hub = xibbit.NewXibbitHub(map[string]interface{}{})
+	hub.QueueEvent("instanceabcdefghijklmnopq", map[string]interface{}{"type": "event_a"})
+	hub.QueueEvent("instanceabcdefghijklmnopq", map[string]interface{}{"type": "event_b"})
+	hub.ReceiveQueuedEvents("instanceabcdefghijklmnopq")
+	conn1 = xibbit.NewFakeSocket("sid_abc")
+	hub.AddSession(conn1)
+	hub.SetSessionData(conn1, map[string]interface{}{
+		"instance_id": "instanceabcdefghijklmnopq",
+	})
+	// the queue is dropped but the hub remembers what it sent
+	hub.ExpireQueuedEvents(-1)
+	hub.QueueEvent("instancezyxwvutsrqponmlkj", map[string]interface{}{"type": "event_c"})
+	hub.QueueEvent("instanceabcdefghijklmnopq", map[string]interface{}{"type": "event_d"})
+	retValStr = ""
+	for _, event := range hub.ReceiveQueuedEvents("instanceabcdefghijklmnopq") {
+		retValStr += event["type"].(string) + strconv.Itoa(event["_seq"].(int)) + ","
+	}
+	assertStr("XibbitHub.ExpireQueuedEvents #61", false,
+		retValStr,
+		"event_d3,",
+	)
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #62
+	//
+
+	bus = xibbit.NewMemoryClusterBus()
+	hub = xibbit.NewXibbitHub(map[string]interface{}{
+		"cluster": bus,
+	})
+	hub2 = xibbit.NewXibbitHub(map[string]interface{}{
+		"cluster": bus,
+	})
+	conn1 = xibbit.NewFakeSocket("sid_abc")
+	hub2.AddSession(conn1)
+	hub2.SetSessionData(conn1, map[string]interface{}{
+		"instance_id": "instanceabcdefghijklmnopq",
+		"_username":   "bill",
+	})
+	retValStr = fmt.Sprintf("%v,%v,", hub.IsStreaming("instanceabcdefghijklmnopq"), hub2.IsStreaming("instanceabcdefghijklmnopq"))
+	conn2 = xibbit.NewStreamingSocket("sse_abc", 16)
+	hub2.AddSession(conn2)
+	hub2.CombineSessions("instanceabcdefghijklmnopq", conn2)
+	retValStr += fmt.Sprintf("%v,%v,", hub.IsStreaming("instanceabcdefghijklmnopq"), hub2.IsStreaming("instanceabcdefghijklmnopq"))
+	hub2.RemoveSocketFromSession(conn2)
+	retValStr += fmt.Sprintf("%v,%v", hub.IsStreaming("instanceabcdefghijklmnopq"), hub2.IsStreaming("instanceabcdefghijklmnopq"))
+	assertStr("XibbitHub.IsStreaming #62", false,
+		retValStr,
+		"false,false,true,true,false,false",
+	)
+	hub2.StopHub(context.Background())
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #63
+	//
+
+	slow := &slowOutputStream{started: make(chan struct{})}
+	batching := xibbit.NewBatchingOutputStream(slow)
+	batching.Write(xibbit.NewFakeSocket("sid_abc"), "client", map[string]interface{}{"type": "event_a"})
+	go batching.Flush()
+	<-slow.started
+	// a second flush waits for the writes of the first
+	batching.Flush()
+	assertStr("BatchingOutputStream.Flush #63", false,
+		slow.written(),
+		"event_a,",
+	)
+
+	//
+	// #64
+	//
+
+	hub = xibbit.NewXibbitHub(map[string]interface{}{})
+	hub.On("api", "stubborn_event", func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
+		// ignore the context
+		time.Sleep(time.Second)
+		event["i"] = "finished"
+		return event
+	}, map[string]interface{}{"timeout": 0.05})
+	began := time.Now()
+	retValStr = ""
+	for _, event := range hub.ReadAndWriteUploadEvent([]map[string]interface{}{
+		{"type": "stubborn_event"},
+	}, "") {
+		retValStr += fmt.Sprintf("%v,%v,", event["e"], event["i"])
+	}
+	retValStr += fmt.Sprintf("%v", time.Since(began) < 500*time.Millisecond)
+	assertStr("XibbitHub.handlerTimeout stubborn #64", false,
+		retValStr,
+		"timeout,<nil>,true",
+	)
+	hub.StopHub(context.Background())
+	hub = nil
+
+	//
+	// #65
+	//
+
+	hub = xibbit.NewXibbitHub(map[string]interface{}{})
+	lateDone := make(chan bool, 1)
+	hub.On("api", "late_event", func(event map[string]interface{}, vars map[string]interface{}) map[string]interface{} {
+		// ignore the context and change the session late
+		time.Sleep(200 * time.Millisecond)
+		event["_session"].(map[string]interface{})["late"] = true
+		lateDone <- true
+		return event
+	}, map[string]interface{}{"timeout": 0.05})
+	lateSession := map[string]interface{}{"instance_id": "instanceabcdefghijklmnopq"}
+	retValMap, _ = hub.Trigger(map[string]interface{}{
+		"type":     "late_event",
+		"_session": lateSession,
+	})
+	hub.StopHub(context.Background())
+	// StopHub waits for the handler
+	select {
+	case <-lateDone:
+		retValStr = "finished,"
+	default:
+		retValStr = "running,"
+	}
+	retValStr += fmt.Sprintf("%v,%v,%v", retValMap["e"], lateSession["late"], retValMap["_session"].(map[string]interface{})["late"])
+	assertStr("XibbitHub.handlerTimeout copy #65", false,
+		retValStr,
+		"finished,timeout,<nil>,<nil>",
+	)
+	hub = nil
+
+	//
+	// #66
+	//
+
+	bus = xibbit.NewMemoryClusterBus()
+	hub = xibbit.NewXibbitHub(map[string]interface{}{
+		"cluster":        bus,
+		"presenceGrace":  0.05,
+		"presenceEvents": true,
+	})
+	hub2 = xibbit.NewXibbitHub(map[string]interface{}{
+		"cluster":        bus,
+		"presenceGrace":  0.05,
+		"presenceEvents": true,
+	})
+	conn1 = xibbit.NewFakeSocket("sid_abc")
+	hub.AddSession(conn1)
+	hub.SetSessionData(conn1, map[string]interface{}{
+		"instance_id": "instanceabcdefghijklmnopq",
+		"_username":   "ann",
+	})
+	conn2 = xibbit.NewFakeSocket("sid_def")
+	hub2.AddSession(conn2)
+	hub2.SetSessionData(conn2, map[string]interface{}{
+		"instance_id": "instancezyxwvutsrqponmlkj",
+		"_username":   "bill",
+	})
+	// bill is online on both nodes and then leaves one
+	conn3 = xibbit.NewFakeSocket("sid_ghi")
+	hub.AddSession(conn3)
+	hub.SetSessionData(conn3, map[string]interface{}{
+		"instance_id": "instancemnopqrstuvwxyzabc",
+		"_username":   "bill",
+	})
+	retValStr = ""
+	for _, user := range hub.Presence() {
+		retValStr += user["username"].(string) + ":" + strconv.Itoa(user["instances"].(int)) + ","
+	}
+	hub.RemoveSocketFromSession(conn3)
+	time.Sleep(100 * time.Millisecond)
+	hub.ExpireSessions(60 * 60)
+	hub2.RemoveSocketFromSession(conn2)
+	time.Sleep(100 * time.Millisecond)
+	hub.ExpireSessions(60 * 60)
+	retValStr += ";" + strings.ReplaceAll(conn1.Fake_data, "\"", "'")
+	assertStr("XibbitHub.Presence cluster #66", false,
+		retValStr,
+		"ann:1,bill:2,;"+
+			"{'to':'all','type':'presence_join','username':'ann'}"+
+			"{'to':'all','type':'presence_join','username':'bill'}"+
+			"{'to':'all','type':'presence_leave','username':'bill'}",
+	)
+	hub2.StopHub(context.Background())
+	hub.StopHub(context.Background())
 	hub = nil
 }
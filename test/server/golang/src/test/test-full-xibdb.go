@@ -26,6 +26,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -36,6 +38,8 @@ import (
 
 	"database/sql"
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 
 	"github.com/xibbit/xibbit/server/golang/src/xibdb"
 )
@@ -177,20 +181,30 @@ func assertRows(name string, rows []map[string]interface{}, output bool, expecte
 	}
 }
 
-func TestFullXibdb() {
-	// connect to the MySQL database
+func TestFullXibdb(dialect string) {
+	// connect to the MySQL, SQLite or PostgreSQL database
 	const host string = "127.0.0.1"
-	const spec string = "root:mysql@tcp(" + host + ":3306)/publicfigure"
-	link, e := sql.Open("mysql", spec)
+	driver := "mysql"
+	spec := "root:mysql@tcp(" + host + ":3306)/publicfigure"
+	if dialect == "sqlite" {
+		driver = "sqlite3"
+		spec = "file:publicfigure?mode=memory&cache=shared"
+	} else if dialect == "postgres" {
+		driver = "postgres"
+		spec = "postgres://postgres:postgres@" + host + ":5432/publicfigure?sslmode=disable"
+	}
+	link, e := sql.Open(driver, spec)
 	if e != nil {
 		log.Fatal(e)
 	}
-	// map the MySQL database to arrays and JSON
+	// map the database to arrays and JSON
 	xdb := xibdb.NewXibDb(map[string]interface{}{
 		"json_column":     "json", // freeform JSON column name
 		"sort_column":     "n",    // array index column name
 		"link_identifier": link,
+		"dialect":         dialect,
 	})
+	qi := xdb.Dialect.QuoteIdentifier
 
 	xdb.DumpSql = false
 	xdb.DryRun = false
@@ -199,13 +213,13 @@ func TestFullXibdb() {
 	nowStr := "2023-01-13 19:21:00"
 	now, _ := time.Parse("2006-01-02 15:04:05", nowStr)
 
-	q := "DROP TABLE `testplants`;"
+	q := "DROP TABLE " + qi("testplants") + ";"
 	_, e, _ = xdb.Mysql_query(q, params)
 	if e != nil {
 		log.Println(e)
 	}
 
-	q = "DROP TABLE `testratings`;"
+	q = "DROP TABLE " + qi("testratings") + ";"
 	_, e, _ = xdb.Mysql_query(q, params)
 	if e != nil {
 		log.Println(e)
@@ -223,6 +237,31 @@ func TestFullXibdb() {
 	q += "`n` bigint(20) unsigned NOT NULL,"
 	q += "`json` text,"
 	q += "UNIQUE KEY `id` (`id`));"
+	if dialect == "sqlite" {
+		q = "CREATE TABLE \"testplants\" ( "
+		q += "\"id\" INTEGER PRIMARY KEY AUTOINCREMENT,"
+		q += "\"category\" text,"
+		q += "\"val\" text,"
+		q += "\"colors\" text,"
+		q += "\"seeds\" tinyint(1),"
+		q += "\"total\" int,"
+		q += "\"price\" float,"
+		q += "\"created\" datetime NOT NULL,"
+		q += "\"n\" bigint NOT NULL,"
+		q += "\"json\" text);"
+	} else if dialect == "postgres" {
+		q = "CREATE TABLE \"testplants\" ( "
+		q += "\"id\" bigserial UNIQUE,"
+		q += "\"category\" text,"
+		q += "\"val\" text,"
+		q += "\"colors\" text,"
+		q += "\"seeds\" boolean,"
+		q += "\"total\" int,"
+		q += "\"price\" real,"
+		q += "\"created\" timestamp NOT NULL,"
+		q += "\"n\" bigint NOT NULL,"
+		q += "\"json\" text);"
+	}
 	_, e, _ = xdb.Mysql_query(q, params)
 	if e != nil {
 		log.Println(e)
@@ -235,6 +274,21 @@ func TestFullXibdb() {
 	q += "`rating` int,"
 	q += "`json` text,"
 	q += "UNIQUE KEY `id` (`id`));"
+	if dialect == "sqlite" {
+		q = "CREATE TABLE \"testratings\" ( "
+		q += "\"id\" INTEGER PRIMARY KEY AUTOINCREMENT,"
+		q += "\"pid\" bigint NOT NULL,"
+		q += "\"name\" text,"
+		q += "\"rating\" int,"
+		q += "\"json\" text);"
+	} else if dialect == "postgres" {
+		q = "CREATE TABLE \"testratings\" ( "
+		q += "\"id\" bigserial UNIQUE,"
+		q += "\"pid\" bigint NOT NULL,"
+		q += "\"name\" text,"
+		q += "\"rating\" int,"
+		q += "\"json\" text);"
+	}
 	_, e, _ = xdb.Mysql_query(q, params)
 	if e != nil {
 		log.Println(e)
@@ -682,7 +736,7 @@ func TestFullXibdb() {
 		"where": map[string]interface{}{
 			"category": "fruit",
 		},
-		"order by": "`testratings`.`rating`",
+		"order by": qi("testratings.rating"),
 	}, nil, nil, nil)
 	if e != nil {
 		log.Println(e)
@@ -710,7 +764,7 @@ func TestFullXibdb() {
 		"where": map[string]interface{}{
 			"category": "fruit",
 		},
-		"order by": "`testratings`.`rating`",
+		"order by": qi("testratings.rating"),
 	}, nil, nil, nil)
 	if e != nil {
 		log.Println(e)
@@ -830,7 +884,7 @@ func TestFullXibdb() {
 			"price":     1.02,
 			"created":   now,
 		},
-		"where": " WHERE `category`='fruit'",
+		"where": " WHERE " + qi("category") + "='fruit'",
 		"n":     4,
 	}, nil, nil, nil)
 	if e != nil {
@@ -858,7 +912,7 @@ func TestFullXibdb() {
 			"price":    2.50,
 			"created":  now,
 		},
-		"where": " WHERE `category`='fruit'",
+		"where": " WHERE " + qi("category") + "='fruit'",
 		"n":     4,
 	}, nil, nil, nil)
 	if e != nil {
@@ -886,7 +940,7 @@ func TestFullXibdb() {
 			"price":    1.13,
 			"created":  now,
 		},
-		"where": "`category`='fruit'",
+		"where": qi("category") + "='fruit'",
 		"n":     4,
 	}, nil, nil, nil)
 	if e != nil {
@@ -904,7 +958,7 @@ func TestFullXibdb() {
 
 	_, e = xdb.InsertRowNative(map[string]interface{}{
 		"table":  "testplants",
-		"values": "(`id`,`category`,`val`,`colors`,`seeds`,`total`,`price`,`created`,`n`,`json`) VALUES (0,'fruit','blueberry',' blue ',0,18,0.22,'2023-01-13 19:21:00',13,'{\"stains\":true}')",
+		"values": "(" + qi("category") + "," + qi("val") + "," + qi("colors") + "," + qi("seeds") + "," + qi("total") + "," + qi("price") + "," + qi("created") + "," + qi("n") + "," + qi("json") + ") VALUES ('fruit','blueberry',' blue ',FALSE,18,0.22,'2023-01-13 19:21:00',13,'{\"stains\":true}')",
 		"where": map[string]interface{}{
 			"category": "fruit",
 		},
@@ -1099,7 +1153,7 @@ func TestFullXibdb() {
 
 	e = xdb.DeleteRowNative(map[string]interface{}{
 		"table": "testplants",
-		"where": " WHERE `category`='fruit'",
+		"where": " WHERE " + qi("category") + "='fruit'",
 		"n":     0,
 	}, nil, nil)
 	if e != nil {
@@ -1117,7 +1171,7 @@ func TestFullXibdb() {
 
 	e = xdb.DeleteRowNative(map[string]interface{}{
 		"table": "testplants",
-		"where": " WHERE `category`='fruit'",
+		"where": " WHERE " + qi("category") + "='fruit'",
 		"n":     0,
 	}, nil, nil)
 	if e != nil {
@@ -1135,7 +1189,7 @@ func TestFullXibdb() {
 
 	e = xdb.DeleteRowNative(map[string]interface{}{
 		"table": "testplants",
-		"where": "`category`='fruit'",
+		"where": qi("category") + "='fruit'",
 		"n":     0,
 	}, nil, nil)
 	if e != nil {
@@ -1275,4 +1329,322 @@ func TestFullXibdb() {
 	// #43
 	//
 
+	e = xdb.WithTx(func(tx *xibdb.XibDb) error {
+		_, e := tx.InsertRowNative(map[string]interface{}{
+			"table": "testplants",
+			"values": map[string]interface{}{
+				"id":       0,
+				"category": "fruit",
+				"val":      "kiwi",
+				"colors":   " brown green ",
+				"seeds":    true,
+				"price":    0.5,
+				"created":  now,
+			},
+			"where": map[string]interface{}{
+				"category": "fruit",
+			},
+			"n": 0,
+		}, nil, nil, nil)
+		if e != nil {
+			return e
+		}
+		return errors.New("roll back the kiwi")
+	})
+	if e == nil {
+		log.Println("WithTx #43 should have failed")
+	}
+
+	assertDb("transaction #43", xdb, []string{"testplants", "testratings"}, false, []string{
+		"[{\"category\":\"flower\",\"colors\":\" white yellow red \",\"created\":\"2023-01-13 19:21:00\",\"id\":5,\"price\":5.0,\"seeds\":false,\"thorns\":true,\"total\":12,\"val\":\"rose\"},{\"category\":\"fruit\",\"colors\":\" orange \",\"created\":\"2023-01-13 19:21:00\",\"id\":6,\"price\":0.1,\"seeds\":true,\"skin\":{\"fragrant\":true,\"thickness\":\"thin\"},\"total\":1,\"val\":\"orange\"},{\"category\":\"flower\",\"colors\":\" white \",\"created\":\"2023-01-13 19:21:00\",\"id\":7,\"price\":1.75,\"seeds\":false,\"total\":1,\"val\":\"tulip\"},{\"category\":\"fruit\",\"colors\":\" red \",\"created\":\"2023-01-13 19:21:00\",\"id\":14,\"price\":2.5,\"seeds\":false,\"total\":1.5,\"val\":\"watermelon\"},{\"category\":\"fruit\",\"colors\":\" red \",\"created\":\"2023-01-13 19:21:00\",\"id\":8,\"price\":0.08,\"seeds\":false,\"total\":164,\"val\":\"strawberry\"},{\"category\":\"fruit\",\"colors\":\" yellow green \",\"created\":\"2023-01-13 19:21:00\",\"id\":13,\"price\":1.02,\"pulpcolor\":\"white\",\"seeds\":false,\"total\":3,\"val\":\"banana\"},{\"category\":\"fruit\",\"colors\":\" red \",\"created\":\"2023-01-13 19:21:00\",\"id\":11,\"price\":0.12,\"seeds\":false,\"sweet\":3,\"total\":17,\"val\":\"raspberry\"},{\"category\":\"fruit\",\"colors\":\" purple red white \",\"created\":\"2023-01-13 19:21:00\",\"id\":4,\"price\":4.08,\"seeds\":true,\"total\":5,\"val\":\"pomegrante\"},{\"category\":\"fruit\",\"colors\":\" orange yellow pink \",\"created\":\"2023-01-13 19:21:00\",\"id\":12,\"price\":3.14,\"seeds\":true,\"sour\":4,\"total\":3,\"val\":\"grapefruit\"},{\"category\":\"fruit\",\"colors\":\" red purple \",\"created\":\"2023-01-13 19:21:00\",\"id\":9,\"pit\":true,\"price\":0.16,\"seeds\":false,\"total\":22,\"val\":\"cherry\"}]",
+		"[{\"id\":1,\"name\":\"fruitycorp\",\"pid\":8,\"rating\":9},{\"id\":2,\"name\":\"greengrocer\",\"pid\":8,\"rating\":8},{\"id\":3,\"name\":\"fruitycorp\",\"pid\":3,\"rating\":4},{\"draft\":true,\"id\":5,\"name\":\"apricoteater\",\"pid\":3,\"rating\":3},{\"id\":6,\"name\":\"produceguy\",\"pid\":8,\"rating\":7}]",
+	})
+
+	//
+	// #44
+	//
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, e = xdb.ReadRowsNativeContext(cancelledCtx, map[string]interface{}{
+		"table": "testratings",
+	}, nil, nil, nil)
+	if e == nil {
+		log.Println("ReadRowsNativeContext #44 should have failed")
+	}
+	rows, e = xdb.ReadRowsNativeContext(context.Background(), map[string]interface{}{
+		"table": "testratings",
+		"where": map[string]interface{}{
+			"name": "fruitycorp",
+		},
+	}, nil, nil, nil)
+	if e != nil {
+		log.Println(e)
+	}
+
+	assertRows("context #44", rows, false,
+		"[{\"id\":1,\"name\":\"fruitycorp\",\"pid\":8,\"rating\":9},{\"id\":3,\"name\":\"fruitycorp\",\"pid\":3,\"rating\":4}]",
+	)
+
+	//
+	// #45
+	//
+
+	rows, e = xdb.ReadRowsNative(map[string]interface{}{
+		"table": "testratings",
+		"order by": []interface{}{
+			map[string]interface{}{"column": "rating", "direction": "DESC"},
+		},
+		"limit":  2,
+		"offset": 1,
+	}, nil, nil, nil)
+	if e != nil {
+		log.Println(e)
+	}
+
+	assertRows("limit #45", rows, false,
+		"[{\"id\":2,\"name\":\"greengrocer\",\"pid\":8,\"rating\":8},{\"id\":6,\"name\":\"produceguy\",\"pid\":8,\"rating\":7}]",
+	)
+
+	//
+	// #46
+	//
+
+	rows, e = xdb.ReadRowsNative(map[string]interface{}{
+		"table":    "testratings",
+		"order by": []string{"rating DESC", "id"},
+		"limit":    2,
+		"after": map[string]interface{}{
+			"rating": 7,
+			"id":     6,
+		},
+	}, nil, nil, nil)
+	if e != nil {
+		log.Println(e)
+	}
+
+	assertRows("after #46", rows, false,
+		"[{\"id\":3,\"name\":\"fruitycorp\",\"pid\":3,\"rating\":4},{\"draft\":true,\"id\":5,\"name\":\"apricoteater\",\"pid\":3,\"rating\":3}]",
+	)
+
+	//
+	// #47
+	//
+
+	rows, e = xdb.ReadRowsNative(map[string]interface{}{
+		"table": "testplants",
+		"where": map[string]interface{}{
+			"category": "fruit",
+		},
+		"columns": []string{"id", "val"},
+		"limit":   3,
+		"offset":  2,
+	}, nil, nil, nil)
+	if e != nil {
+		log.Println(e)
+	}
+
+	assertRows("slice #47", rows, false,
+		"[{\"id\":8,\"val\":\"strawberry\"},{\"id\":13,\"val\":\"banana\"},{\"id\":11,\"val\":\"raspberry\"}]",
+	)
+
+	//
+	// #48
+	//
+
+	it, e := xdb.ReadRowsIter(map[string]interface{}{
+		"table":    "testratings",
+		"order by": []string{"id"},
+	})
+	rows = []map[string]interface{}{}
+	if e == nil {
+		for it.Next() {
+			rows = append(rows, it.Row())
+		}
+		e = it.Err()
+		it.Close()
+	}
+	if e != nil {
+		log.Println(e)
+	}
+
+	assertRows("iterator #48", rows, false,
+		"[{\"id\":1,\"name\":\"fruitycorp\",\"pid\":8,\"rating\":9},{\"id\":2,\"name\":\"greengrocer\",\"pid\":8,\"rating\":8},{\"id\":3,\"name\":\"fruitycorp\",\"pid\":3,\"rating\":4},{\"draft\":true,\"id\":5,\"name\":\"apricoteater\",\"pid\":3,\"rating\":3},{\"id\":6,\"name\":\"produceguy\",\"pid\":8,\"rating\":7}]",
+	)
+
+	//
+	// #49
+	//
+
+	type plant struct {
+		Id       int                    `xibdb:"id"`
+		Category string                 `xibdb:"category"`
+		Val      string                 `xibdb:"val"`
+		Seeds    bool                   `xibdb:"seeds"`
+		Total    *int                   `xibdb:"total"`
+		Price    float64                `xibdb:"price"`
+		Created  time.Time              `xibdb:"created"`
+		Ignored  string                 `xibdb:"-"`
+		Extra    map[string]interface{} `xibdb:",overflow"`
+	}
+	created, _ := time.Parse("2006-01-02 15:04:05", "2015-01-02 03:04:05")
+	kiwi := plant{
+		Category: "fruit",
+		Val:      "kiwi",
+		Seeds:    true,
+		Price:    1.5,
+		Created:  created,
+		Ignored:  "ignored",
+		Extra: map[string]interface{}{
+			"origin": "nz",
+		},
+	}
+	e = xdb.InsertStruct("testplants", &kiwi)
+	if e != nil {
+		log.Println(e)
+	}
+	if kiwi.Id == 0 {
+		log.Println("InsertStruct #49 did not set the id")
+	}
+	plants, e := xibdb.ReadRowsInto[plant](xdb, map[string]interface{}{
+		"table": "testplants",
+		"where": map[string]interface{}{
+			"val": "kiwi",
+		},
+	})
+	if e != nil {
+		log.Println(e)
+	}
+	rows = []map[string]interface{}{}
+	for _, p := range plants {
+		row := map[string]interface{}{
+			"same":    p.Id == kiwi.Id,
+			"val":     p.Val,
+			"seeds":   p.Seeds,
+			"null":    p.Total == nil,
+			"price":   p.Price,
+			"created": p.Created,
+			"ignored": p.Ignored,
+		}
+		for key, value := range p.Extra {
+			row[key] = value
+		}
+		rows = append(rows, row)
+	}
+
+	assertRows("structs #49", rows, false,
+		"[{\"created\":\"2015-01-02 03:04:05\",\"ignored\":\"\",\"null\":true,\"origin\":\"nz\",\"price\":1.5,\"same\":true,\"seeds\":true,\"val\":\"kiwi\"}]",
+	)
+
+	//
+	// #50
+	//
+
+	tricky := "it's \"quoted\" \\ back\x00nul"
+	_, e = xdb.InsertRowNative(map[string]interface{}{
+		"table": "testratings",
+		"values": map[string]interface{}{
+			"pid":    9,
+			"name":   tricky,
+			"rating": 1,
+		},
+	}, nil, nil, nil)
+	if e != nil {
+		log.Println(e)
+	}
+	rows, e = xdb.ReadRowsNative(map[string]interface{}{
+		"table": "testratings",
+		"where": map[string]interface{}{
+			"name": tricky,
+		},
+		"columns": []string{"pid", "name"},
+	}, nil, nil, nil)
+	if e != nil {
+		log.Println(e)
+	}
+	e = xdb.DeleteRowNative(map[string]interface{}{
+		"table": "testratings",
+		"where": map[string]interface{}{
+			"pid": 9,
+		},
+	}, nil, nil)
+	if e != nil {
+		log.Println(e)
+	}
+
+	assertRows("bind #50", rows, false,
+		"[{\"name\":\"it's \\\"quoted\\\" \\ back\x00nul\",\"pid\":9}]",
+	)
+
+	//
+	// #51
+	//
+
+	// SQLite cannot flatten a NUL into the query text
+	flat := *xdb
+	flat.FlattenQueries = true
+	tricky = "it's \"quoted\" \\ back"
+	_, e = flat.InsertRowNative(map[string]interface{}{
+		"table": "testratings",
+		"values": map[string]interface{}{
+			"pid":    10,
+			"name":   tricky,
+			"rating": 2,
+		},
+	}, nil, nil, nil)
+	if e != nil {
+		log.Println(e)
+	}
+	rows, e = flat.ReadRowsNative(map[string]interface{}{
+		"table": "testratings",
+		"where": map[string]interface{}{
+			"name": tricky,
+		},
+		"columns": []string{"pid", "name"},
+	}, nil, nil, nil)
+	if e != nil {
+		log.Println(e)
+	}
+	e = flat.DeleteRowNative(map[string]interface{}{
+		"table": "testratings",
+		"where": map[string]interface{}{
+			"pid": 10,
+		},
+	}, nil, nil)
+	if e != nil {
+		log.Println(e)
+	}
+
+	assertRows("flatten #51", rows, false,
+		"[{\"name\":\"it's \\\"quoted\\\" \\ back\",\"pid\":10}]",
+	)
+
+	//
+	// #52
+	//
+
+	rows, e = xdb.ReadRowsNative(map[string]interface{}{
+		"table": "testratings",
+		"where": map[string]interface{}{
+			"pid": 8,
+		},
+		"order by": []string{"rating DESC", "id"},
+		"limit":    2,
+		"after": map[string]interface{}{
+			"rating": 8,
+			"id":     2,
+		},
+	}, nil, nil, nil)
+	if e != nil {
+		log.Println(e)
+	}
+
+	assertRows("where after #52", rows, false,
+		"[{\"id\":6,\"name\":\"produceguy\",\"pid\":8,\"rating\":7}]",
+	)
+
+	//
+	// #53
+	//
+
 }